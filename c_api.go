@@ -4,13 +4,55 @@ package main
 #cgo CXXFLAGS: -std=c++11
 #include <stdio.h>
 #include <stdlib.h>
+
+// Returned by the On* and StopAllCall functions
+typedef enum {
+	EVENT_ACCEPTED = 0,
+	EVENT_DROPPED_RELOADING,
+	EVENT_DROPPED_BLOCKED,
+	EVENT_DROPPED_NO_CHANNEL,
+	EVENT_QUEUE_FULL,
+	EVENT_INVALID_ARGUMENT,
+	EVENT_DROPPED_CLOSED,
+	EVENT_DROPPED_NO_INSTANCE,
+} EventResult;
+
+typedef void (*StatusCallback)(char* name, int recorderType, int channel, int rtspState, int errCode);
+
+static inline void invokeStatusCallback(StatusCallback cb, char* name, int recorderType, int channel, int rtspState, int errCode) {
+	cb(name, recorderType, channel, rtspState, errCode);
+}
+
+// A recording gap closed by a reconnection, from startMs to endMs in
+// milliseconds since the epoch, after attempts reconnections
+typedef void (*GapCallback)(char* name, int recorderType, int channel, long long startMs, long long endMs, int attempts);
+
+static inline void invokeGapCallback(GapCallback cb, char* name, int recorderType, int channel, long long startMs, long long endMs, int attempts) {
+	cb(name, recorderType, channel, startMs, endMs, attempts);
+}
+
+// level is one of the LOG_LEVEL_* values, fields a JSON object
+typedef void (*LogCallback)(int level, char* message, char* fields);
+
+static inline void invokeLogCallback(LogCallback cb, int level, char* message, char* fields) {
+	cb(level, message, fields);
+}
+
+typedef enum {
+	LOG_LEVEL_DEBUG = 0,
+	LOG_LEVEL_INFO,
+	LOG_LEVEL_WARN,
+	LOG_LEVEL_ERROR,
+	LOG_LEVEL_FATAL,
+} LogLevel;
 */
 import "C"
 import (
 	"dvrs.lib/RTSPClient/constant"
-	"dvrs.lib/RTSPClient/handlers"
-	"fmt"
-	"os"
+	"dvrs.lib/RTSPClient/recorder"
+	"encoding/json"
+	"time"
+	"unsafe"
 )
 
 var GitVersion string
@@ -20,186 +62,560 @@ func GetGitVersion() *C.char {
 	return C.CString(GitVersion)
 }
 
+// OnBriefState and the other On* functions return an EventResult telling the
+// host whether the event was queued or why it was dropped. The functions
+// without the Instance prefix act on the default client, instance 0. They
+// return EVENT_DROPPED_NO_INSTANCE after Shutdown until Init is called again.
+// With the block queue policy they wait for room at most the release timeout,
+// then return EVENT_QUEUE_FULL.
+//
 //export OnBriefState
-func OnBriefState(statusC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) {
-	rtspClient := handlers.GetRTSPClient()
-	if rtspClient.GetReloadState() != constant.NON_RELOAD || rtspClient.NumNonGroupCh == 0 {
-		return
+func OnBriefState(statusC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	return InstanceOnBriefState(0, statusC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSize, crdMsgSize, crdMsgIdSize)
+}
+
+//export InstanceOnBriefState
+func InstanceOnBriefState(instanceC C.int, statusC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
 	}
-	name := C.GoStringN(nameC, nameSize)
-	key := handlers.CallKey{
-		Name:         name,
+	return onBriefState(client, statusC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSize, crdMsgSize, crdMsgIdSize)
+}
+
+func onBriefState(client *recorder.RecorderClient, statusC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err := client.OnCallEvent(ctx, recorder.CallEvent{
+		Event:        constant.BRIEF_STATE_EVENT,
+		Name:         C.GoStringN(nameC, nameSize),
 		RecorderType: constant.RET_BRIEF,
+		State:        int(statusC),
+		ListenPort:   int(listenPortC),
+		CRD:          recorder.ParseCRDPairs(C.GoStringN(crdMsgC, crdMsgSize), C.GoStringN(crdMsgIdC, crdMsgIdSize)),
+	})
+	return C.int(recorder.EventResultOf(err))
+}
+
+//export OnGroupState
+func OnGroupState(recorderTypeC C.int, statusC C.int, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	return InstanceOnGroupState(0, recorderTypeC, statusC, crdMsgC, crdMsgIdC, listenPortC, crdMsgSize, crdMsgIdSize)
+}
+
+//export InstanceOnGroupState
+func InstanceOnGroupState(instanceC C.int, recorderTypeC C.int, statusC C.int, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
 	}
-	callInfo, blockState := rtspClient.GetCallInfo(key)
-	if blockState != constant.NON_BLOCK {
-		return
+	return onGroupState(client, recorderTypeC, statusC, crdMsgC, crdMsgIdC, listenPortC, crdMsgSize, crdMsgIdSize)
+}
+
+func onGroupState(client *recorder.RecorderClient, recorderTypeC C.int, statusC C.int, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err := client.OnCallEvent(ctx, recorder.CallEvent{
+		Event:        constant.GROUP_STATE_EVENT,
+		RecorderType: constant.RecorderType(recorderTypeC),
+		State:        int(statusC),
+		ListenPort:   int(listenPortC),
+		CRD:          recorder.ParseCRDPairs(C.GoStringN(crdMsgC, crdMsgSize), C.GoStringN(crdMsgIdC, crdMsgIdSize)),
+	})
+	return C.int(recorder.EventResultOf(err))
+}
+
+//export OnRadioState
+func OnRadioState(sipTypeC, radioButtonStateC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	return InstanceOnRadioState(0, sipTypeC, radioButtonStateC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSize, crdMsgSize, crdMsgIdSize)
+}
+
+//export InstanceOnRadioState
+func InstanceOnRadioState(instanceC C.int, sipTypeC, radioButtonStateC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
+	}
+	return onRadioState(client, sipTypeC, radioButtonStateC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSize, crdMsgSize, crdMsgIdSize)
+}
+
+func onRadioState(client *recorder.RecorderClient, sipTypeC, radioButtonStateC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) C.int {
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err := client.OnCallEvent(ctx, recorder.CallEvent{
+		Event:        constant.RADIO_STATE_EVENT,
+		Name:         C.GoStringN(nameC, nameSize),
+		RecorderType: constant.RecorderType(sipTypeC),
+		State:        int(radioButtonStateC),
+		ListenPort:   int(listenPortC),
+		CRD:          recorder.ParseCRDPairs(C.GoStringN(crdMsgC, crdMsgSize), C.GoStringN(crdMsgIdC, crdMsgIdSize)),
+	})
+	return C.int(recorder.EventResultOf(err))
+}
+
+//export OnCallState
+func OnCallState(callStateC C.int, sipTypeC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSizeC C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	return InstanceOnCallState(0, callStateC, sipTypeC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSizeC, crdMsgSizeC, crdMsgIdSizeC)
+}
+
+//export InstanceOnCallState
+func InstanceOnCallState(instanceC C.int, callStateC C.int, sipTypeC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSizeC C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
 	}
-	crdMsg := C.GoStringN(crdMsgC, crdMsgSize)
-	crdMsgId := C.GoStringN(crdMsgIdC, crdMsgIdSize)
-	listenPort := int(listenPortC)
-	status := int(statusC)
+	return onCallState(client, callStateC, sipTypeC, nameC, crdMsgC, crdMsgIdC, listenPortC, nameSizeC, crdMsgSizeC, crdMsgIdSizeC)
+}
+
+func onCallState(client *recorder.RecorderClient, callStateC C.int, sipTypeC C.int, nameC *C.char,
+	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
+	nameSizeC C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err := client.OnCallEvent(ctx, recorder.CallEvent{
+		Event:        constant.CALL_STATE_EVENT,
+		Name:         C.GoStringN(nameC, nameSizeC),
+		RecorderType: constant.RecorderType(sipTypeC),
+		State:        int(callStateC),
+		ListenPort:   int(listenPortC),
+		CRD:          recorder.ParseCRDPairs(C.GoStringN(crdMsgC, crdMsgSizeC), C.GoStringN(crdMsgIdC, crdMsgIdSizeC)),
+	})
+	return C.int(recorder.EventResultOf(err))
+}
+
+//export OnCallMediaState
+func OnCallMediaState(mediaStateC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char,
+	nameSize C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	return InstanceOnCallMediaState(0, mediaStateC, nameC, crdMsgC, crdMsgIdC, nameSize, crdMsgSizeC, crdMsgIdSizeC)
+}
 
-	if listenPort != 0 && listenPort != callInfo.ListenPort {
-		callInfo.UpdatelistenPort(listenPort)
+//export InstanceOnCallMediaState
+func InstanceOnCallMediaState(instanceC C.int, mediaStateC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char,
+	nameSize C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
 	}
-	callInfo.HandleBriefState(constant.BriefState(status), crdMsg, crdMsgId)
+	return onCallMediaState(client, mediaStateC, nameC, crdMsgC, crdMsgIdC, nameSize, crdMsgSizeC, crdMsgIdSizeC)
 }
 
-//export OnGroupState
-func OnGroupState(recorderTypeC C.int, statusC C.int, crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int, crdMsgSize C.int, crdMsgIdSize C.int) {
-	rtspClient := handlers.GetRTSPClient()
-	if rtspClient.GetReloadState() != constant.NON_RELOAD {
-		return
+func onCallMediaState(client *recorder.RecorderClient, mediaStateC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char,
+	nameSize C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) C.int {
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err := client.OnCallEvent(ctx, recorder.CallEvent{
+		Event:        constant.CALL_MEDIA_STATE_EVENT,
+		Name:         C.GoStringN(nameC, nameSize),
+		RecorderType: constant.RET_PHONE,
+		State:        int(mediaStateC),
+		CRD:          recorder.ParseCRDPairs(C.GoStringN(crdMsgC, crdMsgSizeC), C.GoStringN(crdMsgIdC, crdMsgIdSizeC)),
+	})
+	return C.int(recorder.EventResultOf(err))
+}
+
+//export OnCallEvent
+func OnCallEvent(docC *C.char, docSizeC C.int) C.int {
+	return InstanceOnCallEvent(0, docC, docSizeC)
+}
+
+//export InstanceOnCallEvent
+func InstanceOnCallEvent(instanceC C.int, docC *C.char, docSizeC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
 	}
-	recorderType := int(recorderTypeC)
-	if recorderType != int(constant.RET_AMBIENT) && rtspClient.NumGroupCh == 0 {
-		return
+	return onCallEvent(client, docC, docSizeC)
+}
+
+func onCallEvent(client *recorder.RecorderClient, docC *C.char, docSizeC C.int) C.int {
+	callEvent, err := recorder.ParseCallEvent(C.GoBytes(unsafe.Pointer(docC), docSizeC))
+	if err != nil {
+		client.Logger().LogError("Invalid call event", "err", err)
+		return C.int(constant.EVENT_INVALID_ARGUMENT)
+	}
+	ctx, cancel := client.EventContext()
+	defer cancel()
+	err = client.OnCallEvent(ctx, callEvent)
+	return C.int(recorder.EventResultOf(err))
+}
+
+// LoadRecConfig reads the config files again. It returns the number of
+// recorder entries rejected for an error, or -1 if the whole config was
+// rejected and the previous one kept. GetConfigReport details the issues.
+//
+//export LoadRecConfig
+func LoadRecConfig() C.int {
+	return InstanceLoadRecConfig(0)
+}
+
+//export InstanceLoadRecConfig
+func InstanceLoadRecConfig(instanceC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return -1
+	}
+	return loadRecConfig(client)
+}
+
+func loadRecConfig(client *recorder.RecorderClient) C.int {
+	cfgReport := client.ReloadConfig()
+	if !cfgReport.Applied {
+		return -1
+	}
+	return C.int(len(cfgReport.Issues.Errors()))
+}
+
+// GetConfigReport returns a JSON document listing the issues found by the last
+// config load. The caller must free the returned string.
+//
+//export GetConfigReport
+func GetConfigReport() *C.char {
+	return InstanceGetConfigReport(0)
+}
+
+//export InstanceGetConfigReport
+func InstanceGetConfigReport(instanceC C.int) *C.char {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return nil
 	}
-	key := handlers.CallKey{
-		RecorderType: constant.RecorderType(recorderType),
+	return getConfigReport(client)
+}
+
+func getConfigReport(client *recorder.RecorderClient) *C.char {
+	data, err := json.Marshal(client.ConfigReport())
+	if err != nil {
+		client.Logger().LogError("Could not build config report", "err", err)
+		return C.CString("{}")
 	}
-	callInfo, blockState := rtspClient.GetCallInfo(key)
-	if blockState != constant.NON_BLOCK {
+	return C.CString(string(data))
+}
+
+// GetEffectiveConfig returns a JSON document describing the config in use,
+// with the source file and line of each value. The caller must free the
+// returned string.
+//
+//export GetEffectiveConfig
+func GetEffectiveConfig() *C.char {
+	return InstanceGetEffectiveConfig(0)
+}
+
+//export InstanceGetEffectiveConfig
+func InstanceGetEffectiveConfig(instanceC C.int) *C.char {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return nil
+	}
+	return getEffectiveConfig(client)
+}
+
+func getEffectiveConfig(client *recorder.RecorderClient) *C.char {
+	data, err := json.Marshal(client.EffectiveConfig())
+	if err != nil {
+		client.Logger().LogError("Could not build effective config", "err", err)
+		return C.CString("{}")
+	}
+	return C.CString(string(data))
+}
+
+// StopAllCall ends every active call. It returns EVENT_QUEUE_FULL if some calls
+// could not be stopped.
+//
+//export StopAllCall
+func StopAllCall() C.int {
+	return InstanceStopAllCall(0)
+}
+
+//export InstanceStopAllCall
+func InstanceStopAllCall(instanceC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return C.int(constant.EVENT_DROPPED_NO_INSTANCE)
+	}
+	return C.int(recorder.EventResultOf(client.StopAllCall()))
+}
+
+// RegisterStatusCallback sets the function called whenever a recorder session
+// changes state or fails. The name passed to the callback is only valid for the
+// duration of the call. Passing NULL unregisters the callback.
+//
+//export RegisterStatusCallback
+func RegisterStatusCallback(cb C.StatusCallback) {
+	InstanceRegisterStatusCallback(0, cb)
+}
+
+//export InstanceRegisterStatusCallback
+func InstanceRegisterStatusCallback(instanceC C.int, cb C.StatusCallback) {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
 		return
 	}
-	crdMsg := C.GoStringN(crdMsgC, crdMsgSize)
-	crdMsgId := C.GoStringN(crdMsgIdC, crdMsgIdSize)
-	listenPort := int(listenPortC)
-	status := int(statusC)
+	registerStatusCallback(client, cb)
+}
 
-	if listenPort != 0 && listenPort != callInfo.ListenPort {
-		callInfo.UpdatelistenPort(listenPort)
+func registerStatusCallback(client *recorder.RecorderClient, cb C.StatusCallback) {
+	if cb == nil {
+		client.SetStatusHandler(nil)
+		return
 	}
-	callInfo.HandleGroupState(constant.GroupState(status), crdMsg, crdMsgId)
+	client.SetStatusHandler(func(statusEvent recorder.StatusEvent) {
+		nameC := C.CString(statusEvent.Name)
+		defer C.free(unsafe.Pointer(nameC))
+		C.invokeStatusCallback(cb, nameC, C.int(statusEvent.RecorderType), C.int(statusEvent.Ch),
+			C.int(statusEvent.RTSPState), C.int(statusEvent.ErrCode))
+	})
 }
 
-//export OnRadioState
-func OnRadioState(sipTypeC, radioButtonStateC C.int, nameC *C.char,
-	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
-	nameSize C.int, crdMsgSize C.int, crdMsgIdSize C.int) {
-	rtspClient := handlers.GetRTSPClient()
-	if rtspClient.GetReloadState() != constant.NON_RELOAD || rtspClient.NumNonGroupCh == 0 {
+// RegisterGapCallback sets the function called whenever a reconnection closes
+// a recording gap, after the status callback got STATUS_RECONNECTED for the
+// channel. The recorder recorded nothing of the call from startMs to endMs.
+// The name passed to the callback is only valid for the duration of the call.
+// Passing NULL unregisters the callback.
+//
+//export RegisterGapCallback
+func RegisterGapCallback(cb C.GapCallback) {
+	InstanceRegisterGapCallback(0, cb)
+}
+
+//export InstanceRegisterGapCallback
+func InstanceRegisterGapCallback(instanceC C.int, cb C.GapCallback) {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
 		return
 	}
-	name := C.GoStringN(nameC, nameSize)
-	recorderType := int(sipTypeC)
-	key := handlers.CallKey{
-		Name:         name,
-		RecorderType: constant.RecorderType(recorderType),
+	if cb == nil {
+		client.SetGapHandler(nil)
+		return
+	}
+	client.SetGapHandler(func(key recorder.CallKey, ch int, gap recorder.RecordingGap) {
+		nameC := C.CString(key.Name)
+		defer C.free(unsafe.Pointer(nameC))
+		C.invokeGapCallback(cb, nameC, C.int(key.RecorderType), C.int(ch),
+			C.longlong(gap.Start.UnixMilli()), C.longlong(gap.End.UnixMilli()), C.int(gap.Attempts))
+	})
+}
+
+// SetLogCallback sends every log entry of the default client to cb instead of
+// stdout. A NULL cb restores stdout. The callback set before Init is kept by
+// Init.
+//
+//export SetLogCallback
+func SetLogCallback(cb C.LogCallback) {
+	InstanceSetLogCallback(0, cb)
+}
+
+//export InstanceSetLogCallback
+func InstanceSetLogCallback(instanceC C.int, cb C.LogCallback) {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return
 	}
-	callInfo, blockState := rtspClient.GetCallInfo(key)
-	if blockState != constant.NON_BLOCK {
+	if cb == nil {
+		client.SetLogHandler(nil)
 		return
 	}
-	radioButtonState := int(radioButtonStateC)
-	crdMsg := C.GoStringN(crdMsgC, crdMsgSize)
-	crdMsgId := C.GoStringN(crdMsgIdC, crdMsgIdSize)
-	listenPort := int(listenPortC)
+	client.SetLogHandler(func(level constant.LogLevel, message string, fields map[string]interface{}) {
+		fieldsByt, err := json.Marshal(fields)
+		if err != nil {
+			fieldsByt = []byte("{}")
+		}
+		messageC := C.CString(message)
+		defer C.free(unsafe.Pointer(messageC))
+		fieldsC := C.CString(string(fieldsByt))
+		defer C.free(unsafe.Pointer(fieldsC))
+		C.invokeLogCallback(cb, C.int(level), messageC, fieldsC)
+	})
+}
 
-	if listenPort != 0 && listenPort != callInfo.ListenPort {
-		callInfo.UpdatelistenPort(listenPort)
+// SetLogLevel changes the minimum level logged by the default client, at any
+// time. It returns 0 on success and -1 if level is unknown or there is no
+// client.
+//
+//export SetLogLevel
+func SetLogLevel(levelC C.int) C.int {
+	return InstanceSetLogLevel(0, levelC)
+}
+
+//export InstanceSetLogLevel
+func InstanceSetLogLevel(instanceC C.int, levelC C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok || !client.SetLogLevel(constant.LogLevel(levelC)) {
+		return -1
 	}
-	callInfo.HandleRadioButtonState(constant.RadioButtonState(radioButtonState), crdMsg, crdMsgId)
+	return 0
 }
 
-//export OnCallState
-func OnCallState(callStateC C.int, sipTypeC C.int, nameC *C.char,
-	crdMsgC *C.char, crdMsgIdC *C.char, listenPortC C.int,
-	nameSizeC C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) {
-	rtspClient := handlers.GetRTSPClient()
-	if rtspClient.GetReloadState() != constant.NON_RELOAD || rtspClient.NumNonGroupCh == 0 {
-		return
+// PushRTP forwards one RTP packet of an active call to its recorders. The
+// call is named by the nameSize bytes at nameC. It returns 0 when the packet
+// is queued and -1 otherwise.
+//
+//export PushRTP
+func PushRTP(nameC *C.char, recorderTypeC C.int, bufC *C.uchar, lenC C.int, nameSize C.int) C.int {
+	return InstancePushRTP(0, nameC, recorderTypeC, bufC, lenC, nameSize)
+}
+
+//export InstancePushRTP
+func InstancePushRTP(instanceC C.int, nameC *C.char, recorderTypeC C.int, bufC *C.uchar, lenC C.int, nameSize C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return -1
 	}
-	recorderType := int(sipTypeC)
-	name := C.GoStringN(nameC, nameSizeC)
-	key := handlers.CallKey{
+	return pushRTP(client, nameC, recorderTypeC, bufC, lenC, nameSize)
+}
+
+func pushRTP(client *recorder.RecorderClient, nameC *C.char, recorderTypeC C.int, bufC *C.uchar, lenC C.int, nameSize C.int) C.int {
+	if bufC == nil || lenC <= 0 {
+		return -1
+	}
+	var name string
+	if nameC != nil && nameSize > 0 {
+		name = C.GoStringN(nameC, nameSize)
+	}
+	key := recorder.CallKey{
 		Name:         name,
-		RecorderType: constant.RecorderType(recorderType),
+		RecorderType: constant.RecorderType(recorderTypeC),
 	}
-	callInfo, blockState := rtspClient.GetCallInfo(key)
-	if blockState != constant.NON_BLOCK {
-		return
+	buf := C.GoBytes(unsafe.Pointer(bufC), lenC)
+	if err := client.PushRTP(key, buf); err != nil {
+		return -1
 	}
-	callState := int(callStateC)
-	crdMsg := C.GoStringN(crdMsgC, crdMsgSizeC)
-	crdMsgId := C.GoStringN(crdMsgIdC, crdMsgIdSizeC)
-	listenPort := int(listenPortC)
+	return 0
+}
+
+// PushPCM forwards count 16-bit linear PCM samples of an active call to its
+// recorders. The call is named as for PushRTP. It returns 0 when the samples
+// are queued and -1 otherwise.
+//
+//export PushPCM
+func PushPCM(nameC *C.char, recorderTypeC C.int, samplesC *C.short, countC C.int, sampleRateC C.int, nameSize C.int) C.int {
+	return InstancePushPCM(0, nameC, recorderTypeC, samplesC, countC, sampleRateC, nameSize)
+}
 
-	if listenPort != 0 && listenPort != callInfo.ListenPort {
-		callInfo.UpdatelistenPort(listenPort)
+//export InstancePushPCM
+func InstancePushPCM(instanceC C.int, nameC *C.char, recorderTypeC C.int, samplesC *C.short, countC C.int, sampleRateC C.int, nameSize C.int) C.int {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return -1
 	}
-	callInfo.HandleCallState(constant.CallState(callState), crdMsg, crdMsgId)
+	return pushPCM(client, nameC, recorderTypeC, samplesC, countC, sampleRateC, nameSize)
 }
 
-//export OnCallMediaState
-func OnCallMediaState(mediaStateC C.int, nameC *C.char, crdMsgC *C.char, crdMsgIdC *C.char,
-	nameSize C.int, crdMsgSizeC C.int, crdMsgIdSizeC C.int) {
-	rtspClient := handlers.GetRTSPClient()
-	if rtspClient.GetReloadState() != constant.NON_RELOAD || rtspClient.NumNonGroupCh == 0 {
-		return
+func pushPCM(client *recorder.RecorderClient, nameC *C.char, recorderTypeC C.int, samplesC *C.short, countC C.int, sampleRateC C.int, nameSize C.int) C.int {
+	if samplesC == nil || countC <= 0 {
+		return -1
+	}
+	var name string
+	if nameC != nil && nameSize > 0 {
+		name = C.GoStringN(nameC, nameSize)
 	}
-	name := C.GoStringN(nameC, nameSize)
-	recorderType := constant.RET_PHONE
-	key := handlers.CallKey{
+	key := recorder.CallKey{
 		Name:         name,
-		RecorderType: recorderType,
+		RecorderType: constant.RecorderType(recorderTypeC),
 	}
-	callInfo, blockState := rtspClient.GetCallInfo(key)
-	if blockState != constant.NON_BLOCK {
-		return
+	samples := append([]int16{}, unsafe.Slice((*int16)(unsafe.Pointer(samplesC)), int(countC))...)
+	if err := client.PushPCM(key, samples, int(sampleRateC)); err != nil {
+		return -1
 	}
-	mediaState := int(mediaStateC)
-	crdMsg := C.GoStringN(crdMsgC, crdMsgSizeC)
-	crdMsgId := C.GoStringN(crdMsgIdC, crdMsgIdSizeC)
-	callInfo.HandleCallMediaState(constant.CallMediaState(mediaState), crdMsg, crdMsgId)
+	return 0
 }
 
-//export LoadRecConfig
-func LoadRecConfig() {
-	rtspClient := handlers.GetRTSPClient()
+// GetStateSnapshot returns a JSON document describing every active call and its
+// recorder sessions. The caller must free the returned string.
+//
+//export GetStateSnapshot
+func GetStateSnapshot() *C.char {
+	return InstanceGetStateSnapshot(0)
+}
 
-	// read rec.cfg
-	recData, err := os.ReadFile(string(constant.REC_CFG_FILE))
+//export InstanceGetStateSnapshot
+func InstanceGetStateSnapshot(instanceC C.int) *C.char {
+	client, ok := recorder.GetInstance(int(instanceC))
+	if !ok {
+		return nil
+	}
+	return getStateSnapshot(client)
+}
+
+func getStateSnapshot(client *recorder.RecorderClient) *C.char {
+	data, err := client.SnapshotJSON()
 	if err != nil {
-		rtspClient.LogInfo(fmt.Sprintf("Could not load %s: %v", constant.REC_CFG_FILE, err))
-		recData, err = os.ReadFile(string(constant.ALT_REC_CFG_FILE))
-		if err != nil {
-			rtspClient.LogInfo(fmt.Sprintf("Could not load %s: %v", constant.ALT_REC_CFG_FILE, err))
-		}
+		client.Logger().LogError("Could not build state snapshot", "err", err)
+		return C.CString("{}")
 	}
+	return C.CString(string(data))
+}
 
-	// read device_system.cfg
-	devSysData, err := os.ReadFile(string(constant.DEV_SYS_CFG_FILE))
+// Init creates the library state and loads rec.cfg and device_system.cfg from
+// configDir. optionsC is a JSON object, NULL or empty for the default options.
+// The client created by the functions called before Init is replaced, keeping
+// its status callback. It returns 0 on success and -1 if the options are
+// invalid or Init was already called.
+//
+//export Init
+func Init(configDirC *C.char, optionsC *C.char) C.int {
+	var configDir, optionsStr string
+	if configDirC != nil {
+		configDir = C.GoString(configDirC)
+	}
+	if optionsC != nil {
+		optionsStr = C.GoString(optionsC)
+	}
+	options, err := recorder.ParseOptions(optionsStr)
 	if err != nil {
-		rtspClient.LogInfo(fmt.Sprintf("Could not load %s: %v", constant.DEV_SYS_CFG_FILE, err))
-		devSysData, err = os.ReadFile(string(constant.ALT_DEV_SYS_CFG_FILE))
-		if err != nil {
-			rtspClient.LogInfo(fmt.Sprintf("Could not load %s: %v", constant.ALT_DEV_SYS_CFG_FILE, err))
-		}
+		return -1
 	}
-	rtspClient.ReloadMutex.Lock()
-	defer rtspClient.ReloadMutex.Unlock()
-	if rtspClient.ReloadState != constant.NON_RELOAD {
-		saveRecCfg := handlers.GetSaveCfg()
-		saveRecCfg.Reset()
-		saveRecCfg.LoadRecFileConfig(recData)
-		saveRecCfg.LoadDevSysFileConfig(devSysData)
-		saveRecCfg.CheckDupConfig()
-		rtspClient.LogInfo(saveRecCfg.String())
-	} else {
-		rtspClient.LoadRecFileConfig(recData)
-		rtspClient.LoadDevSysFileConfig(devSysData)
-		rtspClient.CheckDupConfig()
-		rtspClient.LogInfo(rtspClient.String())
+	if _, err = recorder.Init(configDir, options); err != nil {
+		return -1
 	}
-	rtspClient.LogDebug("Load rec.cfg successfully")
+	return 0
 }
 
-//export StopAllCall
-func StopAllCall() {
-	rtspClient := handlers.GetRTSPClient()
-	rtspClient.StopAllCall()
+// Shutdown ends every active call and closes every recorder session, waiting
+// at most timeoutMs for them. It returns the number of calls still active when
+// the timeout expired.
+//
+//export Shutdown
+func Shutdown(timeoutMsC C.int) C.int {
+	return C.int(recorder.Shutdown(time.Duration(timeoutMsC) * time.Millisecond))
+}
+
+// CreateInstance creates a client independent from the default one and from
+// the other instances, with its own calls, config and logger. configDirC and
+// optionsC are read as in Init. It returns the id to pass to the Instance*
+// functions, or -1 if the options are invalid.
+//
+//export CreateInstance
+func CreateInstance(configDirC *C.char, optionsC *C.char) C.int {
+	var configDir, optionsStr string
+	if configDirC != nil {
+		configDir = C.GoString(configDirC)
+	}
+	if optionsC != nil {
+		optionsStr = C.GoString(optionsC)
+	}
+	options, err := recorder.ParseOptions(optionsStr)
+	if err != nil {
+		return -1
+	}
+	return C.int(recorder.CreateInstance(configDir, options))
+}
+
+// DestroyInstance shuts an instance down as Shutdown does for the default
+// client. It returns the number of calls still active when the timeout
+// expired, or -1 if the id is unknown.
+//
+//export DestroyInstance
+func DestroyInstance(instanceC C.int, timeoutMsC C.int) C.int {
+	return C.int(recorder.DestroyInstance(int(instanceC), time.Duration(timeoutMsC)*time.Millisecond))
 }
 
 func main() {}
@@ -0,0 +1,61 @@
+// Command rec-cfg-convert converts the legacy rec.cfg and the tmcs_server
+// section of device_system.cfg to the rec.json recorder config. The recorder
+// entries with an error are reported and left out.
+//
+//	rec-cfg-convert -rec config/rec-config/rec.cfg -dev-sys config/system/device_system.cfg -o config/rec-config/rec.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"dvrs.lib/RTSPClient/handlers"
+)
+
+func readFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+func main() {
+	recPath := flag.String("rec", "", "path of rec.cfg")
+	devSysPath := flag.String("dev-sys", "", "path of device_system.cfg")
+	outPath := flag.String("o", "", "path of the rec.json written, stdout if empty")
+	flag.Parse()
+	if *recPath == "" && *devSysPath == "" {
+		fmt.Fprintln(os.Stderr, "rec-cfg-convert: -rec or -dev-sys is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	recData, err := readFile(*recPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rec-cfg-convert:", err)
+		os.Exit(1)
+	}
+	devSysData, err := readFile(*devSysPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rec-cfg-convert:", err)
+		os.Exit(1)
+	}
+	data, issues, err := handlers.ConvertLegacyConfig(recData, *recPath, devSysData, *devSysPath)
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stderr, issue.Error())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rec-cfg-convert: the converted config is invalid:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *outPath == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*outPath, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "rec-cfg-convert:", err)
+		os.Exit(1)
+	}
+}
@@ -12,6 +12,72 @@ const (
 	RTSP_STATE_DISCONNECT
 )
 
+func (s RTSPState) String() string {
+	switch s {
+	case RTSP_STATE_NULL:
+		return "NULL"
+	case RTSP_STATE_START:
+		return "START"
+	case RTSP_STATE_ANNOUNCE:
+		return "ANNOUNCE"
+	case RTSP_STATE_SETUP:
+		return "SETUP"
+	case RTSP_STATE_RECORD:
+		return "RECORD"
+	case RTSP_STATE_PAUSE:
+		return "PAUSE"
+	case RTSP_STATE_DISCONNECT:
+		return "DISCONNECT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SessionRequest is what a recorder session sends to change state
+type SessionRequest int
+
+const (
+	// The state changes without a request
+	REQUEST_NONE SessionRequest = iota
+	// The connection to the recorder is opened
+	REQUEST_CONNECT
+	REQUEST_ANNOUNCE
+	REQUEST_SETUP
+	REQUEST_RECORD
+	REQUEST_PAUSE
+	// A SET_PARAMETER with the CRD, the phone calls are held and resumed
+	// this way
+	REQUEST_SET_PARAMETER
+	REQUEST_TEARDOWN
+	// A SET_PARAMETER with the last CRD then a TEARDOWN, by ED137C
+	REQUEST_CRD_TEARDOWN
+)
+
+func (r SessionRequest) String() string {
+	switch r {
+	case REQUEST_NONE:
+		return "NONE"
+	case REQUEST_CONNECT:
+		return "CONNECT"
+	case REQUEST_ANNOUNCE:
+		return "ANNOUNCE"
+	case REQUEST_SETUP:
+		return "SETUP"
+	case REQUEST_RECORD:
+		return "RECORD"
+	case REQUEST_PAUSE:
+		return "PAUSE"
+	case REQUEST_SET_PARAMETER:
+		return "SET_PARAMETER"
+	case REQUEST_TEARDOWN:
+		return "TEARDOWN"
+	case REQUEST_CRD_TEARDOWN:
+		return "CRD_TEARDOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 type BriefState int
 
 const (
@@ -91,6 +157,29 @@ const (
 	RET_BRIEF_GROUP
 )
 
+func (t RecorderType) String() string {
+	switch t {
+	case RET_PHONE:
+		return "PHONE"
+	case RET_RADIO_TX:
+		return "RADIO_TX"
+	case RET_RADIO_RX:
+		return "RADIO_RX"
+	case RET_BRIEF:
+		return "BRIEF"
+	case RET_AMBIENT:
+		return "AMBIENT"
+	case RET_PHONE_GROUP:
+		return "PHONE_GROUP"
+	case RET_RADIO_GROUP:
+		return "RADIO_GROUP"
+	case RET_BRIEF_GROUP:
+		return "BRIEF_GROUP"
+	default:
+		return "INVALID"
+	}
+}
+
 type SetParameterMode string
 
 const (
@@ -105,3 +194,27 @@ const (
 	NON_BLOCK BlockState = iota
 	NORMAL_BLOCK
 )
+
+type CallEventType int
+
+const (
+	BRIEF_STATE_EVENT CallEventType = iota
+	GROUP_STATE_EVENT
+	RADIO_STATE_EVENT
+	CALL_STATE_EVENT
+	CALL_MEDIA_STATE_EVENT
+)
+
+// QueuePolicy is what the event queue of a call does with an event that does
+// not fit in it
+type QueuePolicy string
+
+const (
+	// The caller waits until the call takes an event, except one ending the call
+	QUEUE_BLOCK QueuePolicy = "block"
+	// The oldest event is dropped, its CRD attributes go with the next one
+	QUEUE_DROP_OLDEST QueuePolicy = "drop_oldest"
+	// Radio button toggles that cancel each other out are removed, other
+	// events are rejected
+	QUEUE_COALESCE QueuePolicy = "coalesce"
+)
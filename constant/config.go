@@ -3,10 +3,19 @@ package constant
 type CfgFile string
 
 const (
-	REC_CFG_FILE         CfgFile = "config/rec-config/rec.cfg"
-	DEV_SYS_CFG_FILE     CfgFile = "config/system/device_system.cfg"
-	ALT_REC_CFG_FILE     CfgFile = "/home/cwp/opconsole/config/rec-config/rec.cfg"
-	ALT_DEV_SYS_CFG_FILE CfgFile = "/home/cwp/opconsole/config/system/device_system.cfg"
+	REC_CFG_FILE          CfgFile = "config/rec-config/rec.cfg"
+	DEV_SYS_CFG_FILE      CfgFile = "config/system/device_system.cfg"
+	REC_JSON_CFG_FILE     CfgFile = "config/rec-config/rec.json"
+	ALT_REC_CFG_FILE      CfgFile = "/home/cwp/opconsole/config/rec-config/rec.cfg"
+	ALT_DEV_SYS_CFG_FILE  CfgFile = "/home/cwp/opconsole/config/system/device_system.cfg"
+	ALT_REC_JSON_CFG_FILE CfgFile = "/home/cwp/opconsole/config/rec-config/rec.json"
+)
+
+// Paths relative to the config directory given to Init
+const (
+	REC_CFG_NAME      CfgFile = "rec-config/rec.cfg"
+	DEV_SYS_CFG_NAME  CfgFile = "system/device_system.cfg"
+	REC_JSON_CFG_NAME CfgFile = "rec-config/rec.json"
 )
 
 type RecCfg int
@@ -28,4 +37,167 @@ type ReloadState int
 const (
 	NON_RELOAD ReloadState = iota
 	NORMAL_RELOAD
+	SHUTDOWN_RELOAD
+)
+
+// CfgSeverity tells whether a config issue rejects the entry it is found in
+type CfgSeverity int
+
+const (
+	CFG_WARNING CfgSeverity = iota
+	CFG_ERROR
+)
+
+func (s CfgSeverity) String() string {
+	switch s {
+	case CFG_WARNING:
+		return "warning"
+	case CFG_ERROR:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+type CfgIssueType int
+
+const (
+	CFG_SYNTAX_ERROR CfgIssueType = iota
+	CFG_MISSING_KEY
+	CFG_UNKNOWN_KEY
+	CFG_DUPLICATE_KEY
+	CFG_INVALID_VALUE
+	CFG_DUPLICATE_ADDRESS
+)
+
+func (s CfgSeverity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (t CfgIssueType) String() string {
+	switch t {
+	case CFG_SYNTAX_ERROR:
+		return "SYNTAX_ERROR"
+	case CFG_MISSING_KEY:
+		return "MISSING_KEY"
+	case CFG_UNKNOWN_KEY:
+		return "UNKNOWN_KEY"
+	case CFG_DUPLICATE_KEY:
+		return "DUPLICATE_KEY"
+	case CFG_INVALID_VALUE:
+		return "INVALID_VALUE"
+	case CFG_DUPLICATE_ADDRESS:
+		return "DUPLICATE_ADDRESS"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (t CfgIssueType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// MediaTransport is how the RTP packets are sent to a recorder
+type MediaTransport int
+
+const (
+	MEDIA_TRANSPORT_UDP MediaTransport = iota
+	MEDIA_TRANSPORT_TCP
 )
+
+func (t MediaTransport) String() string {
+	switch t {
+	case MEDIA_TRANSPORT_UDP:
+		return "udp"
+	case MEDIA_TRANSPORT_TCP:
+		return "tcp"
+	default:
+		return "unknown"
+	}
+}
+
+func (t MediaTransport) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+type ED137Version int
+
+const (
+	ED137A ED137Version = iota
+	ED137B
+	ED137C
+)
+
+func (v ED137Version) String() string {
+	switch v {
+	case ED137A:
+		return "ED137A"
+	case ED137B:
+		return "ED137B"
+	case ED137C:
+		return "ED137C"
+	default:
+		return "unknown"
+	}
+}
+
+func (v ED137Version) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// Codec is the audio format sent to a recorder
+type Codec int
+
+const (
+	CODEC_G711ALAW Codec = iota
+	CODEC_G711ULAW
+	// Linear PCM at 8 kHz
+	CODEC_L16
+	// Linear PCM at 16 kHz
+	CODEC_L16_16K
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CODEC_G711ALAW:
+		return "g711alaw"
+	case CODEC_G711ULAW:
+		return "g711ulaw"
+	case CODEC_L16:
+		return "l16"
+	case CODEC_L16_16K:
+		return "l16_16k"
+	default:
+		return "unknown"
+	}
+}
+
+func (c Codec) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// AuthMethod is the authentication accepted from a recorder
+type AuthMethod int
+
+const (
+	AUTH_ANY AuthMethod = iota
+	AUTH_DIGEST
+	AUTH_BASIC
+)
+
+func (a AuthMethod) String() string {
+	switch a {
+	case AUTH_ANY:
+		return "any"
+	case AUTH_DIGEST:
+		return "digest"
+	case AUTH_BASIC:
+		return "basic"
+	default:
+		return "unknown"
+	}
+}
+
+func (a AuthMethod) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
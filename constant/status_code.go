@@ -0,0 +1,57 @@
+package constant
+
+type StatusCode int
+
+const (
+	STATUS_OK StatusCode = iota
+	STATUS_ERR_START
+	STATUS_ERR_ANNOUNCE_SETUP
+	STATUS_ERR_SET_PARAMETER
+	STATUS_ERR_RECORD
+	STATUS_ERR_PAUSE
+	// The session ended without a request failing, e.g. the recorder
+	// closed the connection
+	STATUS_ERR_SESSION_LOST
+	// A new session replaced one that failed, after a recording gap
+	STATUS_RECONNECTED
+)
+
+// EventResult tells the host what became of an event it reported
+type EventResult int
+
+const (
+	EVENT_ACCEPTED EventResult = iota
+	EVENT_DROPPED_RELOADING
+	EVENT_DROPPED_BLOCKED
+	EVENT_DROPPED_NO_CHANNEL
+	EVENT_QUEUE_FULL
+	EVENT_INVALID_ARGUMENT
+	// The client was shut down
+	EVENT_DROPPED_CLOSED
+	// There is no client for the instance id, or the default client was shut
+	// down and Init not called again
+	EVENT_DROPPED_NO_INSTANCE
+)
+
+func (r EventResult) String() string {
+	switch r {
+	case EVENT_ACCEPTED:
+		return "ACCEPTED"
+	case EVENT_DROPPED_RELOADING:
+		return "DROPPED_RELOADING"
+	case EVENT_DROPPED_BLOCKED:
+		return "DROPPED_BLOCKED"
+	case EVENT_DROPPED_NO_CHANNEL:
+		return "DROPPED_NO_CHANNEL"
+	case EVENT_QUEUE_FULL:
+		return "QUEUE_FULL"
+	case EVENT_INVALID_ARGUMENT:
+		return "INVALID_ARGUMENT"
+	case EVENT_DROPPED_CLOSED:
+		return "DROPPED_CLOSED"
+	case EVENT_DROPPED_NO_INSTANCE:
+		return "DROPPED_NO_INSTANCE"
+	default:
+		return "UNKNOWN"
+	}
+}
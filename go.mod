@@ -29,5 +29,5 @@ require (
 require (
 	github.com/pion/randutil v0.1.0 // indirect
 	github.com/zaf/g711 v1.4.0
-	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/sys v0.16.0
 )
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+// CallEvent is one state change reported by the host together with the CRD
+// attributes that come with it.
+type CallEvent struct {
+	Event        constant.CallEventType
+	Name         string
+	RecorderType constant.RecorderType
+	State        int
+	ListenPort   int
+	CRD          []models.CRDField
+}
+
+// callEventDoc is the JSON form of a CallEvent, e.g.
+//
+//	{"event": "call_state", "name": "1001", "recorder_type": 0, "state": 5,
+//	 "listen_port": 40000, "crd": {"0": "cwp1", "1": "1001", "29": "a, b"}}
+//
+// where the keys of crd are the constant.Crd ids.
+type callEventDoc struct {
+	Event        string    `json:"event"`
+	Name         string    `json:"name"`
+	RecorderType *int      `json:"recorder_type"`
+	State        *int      `json:"state"`
+	ListenPort   int       `json:"listen_port"`
+	CRD          crdObject `json:"crd"`
+}
+
+// crdObject is the crd object of a callEventDoc. An id given twice is an
+// error, where a map would keep the last value.
+type crdObject []models.CRDField
+
+func (crd *crdObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("crd is not an object")
+	}
+	seen := make(map[constant.Crd]bool)
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := keyTok.(string)
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("invalid value of CRD id %q: %w", key, err)
+		}
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("invalid CRD id %q", key)
+		}
+		if seen[constant.Crd(id)] {
+			return fmt.Errorf("duplicate CRD id %q", key)
+		}
+		seen[constant.Crd(id)] = true
+		*crd = append(*crd, models.CRDField{Id: constant.Crd(id), Value: value})
+	}
+	_, err = dec.Token()
+	return err
+}
+
+var callEventNames = map[string]constant.CallEventType{
+	"brief_state":      constant.BRIEF_STATE_EVENT,
+	"group_state":      constant.GROUP_STATE_EVENT,
+	"radio_state":      constant.RADIO_STATE_EVENT,
+	"call_state":       constant.CALL_STATE_EVENT,
+	"call_media_state": constant.CALL_MEDIA_STATE_EVENT,
+}
+
+// ParseCallEvent decodes and validates a JSON call event. The CRD attributes
+// are ordered by id.
+func ParseCallEvent(data []byte) (CallEvent, error) {
+	var doc callEventDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return CallEvent{}, err
+	}
+	event, ok := callEventNames[doc.Event]
+	if !ok {
+		return CallEvent{}, fmt.Errorf("unknown event %q", doc.Event)
+	}
+	if doc.State == nil {
+		return CallEvent{}, fmt.Errorf("missing state")
+	}
+	callEvent := CallEvent{
+		Event:      event,
+		Name:       doc.Name,
+		State:      *doc.State,
+		ListenPort: doc.ListenPort,
+		CRD:        []models.CRDField{},
+	}
+	switch event {
+	case constant.BRIEF_STATE_EVENT:
+		callEvent.RecorderType = constant.RET_BRIEF
+	case constant.CALL_MEDIA_STATE_EVENT:
+		callEvent.RecorderType = constant.RET_PHONE
+	default:
+		if doc.RecorderType == nil {
+			return CallEvent{}, fmt.Errorf("missing recorder_type")
+		}
+		callEvent.RecorderType = constant.RecorderType(*doc.RecorderType)
+	}
+	callEvent.CRD = append(callEvent.CRD, doc.CRD...)
+	sort.Slice(callEvent.CRD, func(i, j int) bool {
+		return callEvent.CRD[i].Id < callEvent.CRD[j].Id
+	})
+	return callEvent, callEvent.Validate()
+}
+
+func (callEvent CallEvent) Validate() error {
+	switch callEvent.Event {
+	case constant.BRIEF_STATE_EVENT:
+		if callEvent.State < int(constant.BRIEF_FALSE) || callEvent.State > int(constant.BRIEF_TRUE) {
+			return fmt.Errorf("invalid brief state %d", callEvent.State)
+		}
+	case constant.GROUP_STATE_EVENT:
+		switch callEvent.RecorderType {
+		case constant.RET_AMBIENT, constant.RET_PHONE_GROUP, constant.RET_RADIO_GROUP, constant.RET_BRIEF_GROUP:
+		default:
+			return fmt.Errorf("invalid recorder type %d for a group event", callEvent.RecorderType)
+		}
+		if callEvent.State < int(constant.GROUP_FALSE) || callEvent.State > int(constant.GROUP_TRUE) {
+			return fmt.Errorf("invalid group state %d", callEvent.State)
+		}
+	case constant.RADIO_STATE_EVENT:
+		if callEvent.RecorderType != constant.RET_RADIO_TX && callEvent.RecorderType != constant.RET_RADIO_RX {
+			return fmt.Errorf("invalid recorder type %d for a radio event", callEvent.RecorderType)
+		}
+		if callEvent.State < int(constant.BUTTON_INVALID) || callEvent.State > int(constant.RX_BUTTON_ON) {
+			return fmt.Errorf("invalid radio button state %d", callEvent.State)
+		}
+	case constant.CALL_STATE_EVENT:
+		if callEvent.RecorderType != constant.RET_PHONE {
+			return fmt.Errorf("invalid recorder type %d for a call event", callEvent.RecorderType)
+		}
+		if callEvent.State < int(constant.PJSIP_INV_STATE_NULL) || callEvent.State > int(constant.PJSIP_INV_STATE_DISCONNECTED) {
+			return fmt.Errorf("invalid call state %d", callEvent.State)
+		}
+	case constant.CALL_MEDIA_STATE_EVENT:
+		if callEvent.State < int(constant.PJSUA_CALL_MEDIA_NONE) || callEvent.State > int(constant.PJSUA_CALL_MEDIA_ERROR) {
+			return fmt.Errorf("invalid call media state %d", callEvent.State)
+		}
+	default:
+		return fmt.Errorf("unknown event %d", callEvent.Event)
+	}
+	if callEvent.Name == "" && callEvent.Event != constant.GROUP_STATE_EVENT {
+		return fmt.Errorf("missing name")
+	}
+	if callEvent.ListenPort < 0 || callEvent.ListenPort >= (1<<16) {
+		return fmt.Errorf("invalid listen port %d", callEvent.ListenPort)
+	}
+	for _, crdField := range callEvent.CRD {
+		if crdField.Id < constant.VCS_USER_ID || crdField.Id > constant.GROUP_NAME_ID {
+			return fmt.Errorf("unknown CRD id %d", crdField.Id)
+		}
+	}
+	return nil
+}
+
+// HandleCallEvent queues the event on the call it belongs to, creating the call
+// if needed. Events are dropped while the config is reloading, when no
+// recorder can take them, or once the call is ending. With QUEUE_BLOCK it
+// waits for room until ctx is done.
+func (rtspClient *RTSPClient) HandleCallEvent(ctx context.Context, callEvent CallEvent) constant.EventResult {
+	if rtspClient.GetReloadState() != constant.NON_RELOAD {
+		return constant.EVENT_DROPPED_RELOADING
+	}
+	if !rtspClient.config().recordsAnyType(callEvent.RecorderType) {
+		return constant.EVENT_DROPPED_NO_CHANNEL
+	}
+	key := CallKey{
+		Name:         callEvent.Name,
+		RecorderType: callEvent.RecorderType,
+	}
+	if callEvent.Event == constant.GROUP_STATE_EVENT {
+		key.Name = ""
+	}
+	callInfo, blockState := rtspClient.GetCallInfo(key)
+	if blockState != constant.NON_BLOCK {
+		return constant.EVENT_DROPPED_BLOCKED
+	}
+	if callEvent.Event != constant.CALL_MEDIA_STATE_EVENT && callEvent.ListenPort != 0 && callEvent.ListenPort != callInfo.ListenPort {
+		callInfo.UpdatelistenPort(callEvent.ListenPort)
+	}
+	var result constant.EventResult
+	switch callEvent.Event {
+	case constant.BRIEF_STATE_EVENT:
+		result = callInfo.HandleBriefState(ctx, constant.BriefState(callEvent.State), callEvent.CRD)
+	case constant.GROUP_STATE_EVENT:
+		result = callInfo.HandleGroupState(ctx, constant.GroupState(callEvent.State), callEvent.CRD)
+	case constant.RADIO_STATE_EVENT:
+		result = callInfo.HandleRadioButtonState(ctx, constant.RadioButtonState(callEvent.State), callEvent.CRD)
+	case constant.CALL_STATE_EVENT:
+		result = callInfo.HandleCallState(ctx, constant.CallState(callEvent.State), callEvent.CRD)
+	case constant.CALL_MEDIA_STATE_EVENT:
+		result = callInfo.HandleCallMediaState(ctx, constant.CallMediaState(callEvent.State), callEvent.CRD)
+	default:
+		return constant.EVENT_INVALID_ARGUMENT
+	}
+	switch {
+	case result == constant.EVENT_DROPPED_BLOCKED:
+		rtspClient.LogWarn("Event dropped, the call ended", "name", key.Name, "recorderType", int(key.RecorderType))
+		return result
+	case result != constant.EVENT_ACCEPTED && ctx.Err() != nil:
+		rtspClient.LogWarn("Event not queued before its context was done", "name", key.Name, "recorderType", int(key.RecorderType), "err", ctx.Err())
+		return result
+	case result != constant.EVENT_ACCEPTED:
+		rtspClient.LogWarn("Event queue is full, event dropped", "name", key.Name, "recorderType", int(key.RecorderType), "policy", string(rtspClient.EventQueuePolicy))
+		return result
+	}
+	rtspClient.journal.claim(key)
+	return constant.EVENT_ACCEPTED
+}
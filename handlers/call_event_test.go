@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+func TestParseCallEventCRD(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		crd  string
+		// Nil when the event is rejected
+		want []models.CRDField
+	}{
+		{"none", `null`, []models.CRDField{}},
+		{"empty", `{}`, []models.CRDField{}},
+		{"ordered by id", `{"1": "1001", "0": "cwp1"}`, []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"duplicate id", `{"1": "1001", "1": "1002"}`, nil},
+		{"duplicate id spelled differently", `{"1": "1001", "01": "1002"}`, nil},
+		{"id not a number", `{"x": "1001"}`, nil},
+		{"value not a string", `{"1": 1001}`, nil},
+		{"not an object", `["cwp1"]`, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			callEvent, err := ParseCallEvent([]byte(`{"event": "call_state", "name": "1001", "recorder_type": 0, "state": 5, "crd": ` + tc.crd + `}`))
+			if tc.want == nil {
+				if err == nil {
+					t.Fatalf("accepted with CRD %v", callEvent.CRD)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(callEvent.CRD, tc.want) {
+				t.Fatalf("got CRD %v, want %v", callEvent.CRD, tc.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,8 @@
 package handlers
 
-/*
-#cgo CXXFLAGS: -std=c++11
-#include <stdio.h>
-#include <stdlib.h>
-*/
-import "C"
 import (
+	"context"
 	"encoding/xml"
-	"fmt"
 	"net"
 	"strconv"
 	"sync"
@@ -37,34 +31,14 @@ type RTPInfo struct {
 	SegPloadLength int
 }
 
-type BriefStateInfo struct {
-	state    constant.BriefState
-	crdMsg   string
-	crdMsgId string
-}
-
-type GroupStateInfo struct {
-	state    constant.GroupState
-	crdMsg   string
-	crdMsgId string
-}
-
 type RadioButtonStateInfo struct {
-	state    constant.RadioButtonState
-	crdMsg   string
-	crdMsgId string
-}
-
-type CallStateInfo struct {
-	state    constant.CallState
-	crdMsg   string
-	crdMsgId string
+	state constant.RadioButtonState
+	crd   []models.CRDField
 }
 
 type CallMediaStateInfo struct {
-	state    constant.CallMediaState
-	crdMsg   string
-	crdMsgId string
+	state constant.CallMediaState
+	crd   []models.CRDField
 }
 
 type Done struct {
@@ -79,11 +53,11 @@ func (ck CallKey) Hash() uint32 {
 }
 
 func (callInfo CallInfo) Lock() {
-	rtspClient.callModel.listCallInfo.OuterLock(callInfo.CallKey)
+	callInfo.rtspClient.callModel.listCallInfo.OuterLock(callInfo.CallKey)
 }
 
 func (callInfo CallInfo) Unlock() {
-	rtspClient.callModel.listCallInfo.OuterUnLock(callInfo.CallKey)
+	callInfo.rtspClient.callModel.listCallInfo.OuterUnLock(callInfo.CallKey)
 }
 
 func (callInfo CallInfo) getClient(ch int) Client {
@@ -91,15 +65,18 @@ func (callInfo CallInfo) getClient(ch int) Client {
 		CallKey: callInfo.CallKey,
 		ch:      ch,
 	}
-	rtspClient := GetRTSPClient()
+	rtspClient := callInfo.rtspClient
 	if rtspClient.cs.listClient.Has(key) {
 		c, _ := rtspClient.cs.listClient.Get(key)
 		return c
 	} else {
 		c := Client{
-			client:    &gortsplib.Client{},
-			rtspState: constant.RTSP_STATE_NULL,
-			ClientKey: key,
+			rtspClient: rtspClient,
+			client:     &gortsplib.Client{},
+			rtspState:  constant.RTSP_STATE_NULL,
+			ClientKey:  key,
+			stats:      &RTPStats{},
+			cfg:        callInfo.config(),
 		}
 		c.Lock()
 		rtspClient.cs.listClient.Set(key, c)
@@ -109,6 +86,7 @@ func (callInfo CallInfo) getClient(ch int) Client {
 }
 
 func (callInfo CallInfo) updateClient(ch int, c *Client) {
+	rtspClient := callInfo.rtspClient
 	key := ClientKey{
 		CallKey: callInfo.CallKey,
 		ch:      ch,
@@ -119,6 +97,7 @@ func (callInfo CallInfo) updateClient(ch int, c *Client) {
 }
 
 func (callInfo CallInfo) getClientIfExist(ch int) Client {
+	rtspClient := callInfo.rtspClient
 	key := ClientKey{
 		CallKey: callInfo.CallKey,
 		ch:      ch,
@@ -128,49 +107,68 @@ func (callInfo CallInfo) getClientIfExist(ch int) Client {
 		return c
 	}
 	return Client{
-		client:    &gortsplib.Client{},
-		rtspState: constant.RTSP_STATE_NULL,
-		ClientKey: key,
+		rtspClient: rtspClient,
+		client:     &gortsplib.Client{},
+		rtspState:  constant.RTSP_STATE_NULL,
+		ClientKey:  key,
 	}
 
 }
 
 type EventQueue struct {
-	chBriefStateInfo           chan BriefStateInfo
-	chGroupStateInfo           chan GroupStateInfo
-	chCallStateInfo            chan CallStateInfo
-	chCallMediaStateInfo       chan CallMediaStateInfo
-	chRadioButtonStateInfo     chan RadioButtonStateInfo
-	chLastCallMediaStateInfo   chan CallMediaStateInfo
-	chLastRadioButtonStateInfo chan RadioButtonStateInfo
+	// Events of the host, in order
+	events *CallEventQueue
+	// The media and radio button events debounced by handleInner before
+	// they go to events
+	debounced *CallEventQueue
+	// Sessions that failed or were lost, see handleLostSession
+	chLostSession chan *gortsplib.Client
+	// Addresses of the recorders to reconnect to, see doReconnect
+	chReconnect chan string
+	// The call read from the journal, see doRecover
+	chRecover chan *journalCall
 }
 
 type SleepHandle struct {
 	sleep   bool
 	goSleep chan bool
+	// Starts the hang time, time.After but in the tests
+	after func(time.Duration) <-chan time.Time
 }
 
 type ThreadHandle struct {
-	chDone chan bool
-	wg     *sync.WaitGroup
+	chDone   chan bool
+	doneOnce *sync.Once
+	wg       *sync.WaitGroup
 }
 
 type RTPClient struct {
 	ListenPort         int
 	chUpdateListenConn chan int
 	chRecordRTP        chan bool
+	chPushRTP          chan rtp.Packet
+	pcm                *PCMPacketizer
+	stats              *RTPStats
 }
 
 type CallInfo struct {
 	CallKey
+	rtspClient *RTSPClient
 	RTPClient
 	EventQueue
 	ThreadHandle
 	SleepHandle
+	crdHistory *CRDHistory
+	failover   *CallFailover
+	reconnect  *CallReconnect
+	cfg        *callCfg
+	// Of the config the call started with
+	timings    Timings
 	blockState constant.BlockState
 }
 
 func (callInfo CallInfo) getCRD(ch int) CRD {
+	rtspClient := callInfo.rtspClient
 	key := ClientKey{
 		CallKey: callInfo.CallKey,
 		ch:      ch,
@@ -184,6 +182,7 @@ func (callInfo CallInfo) getCRD(ch int) CRD {
 }
 
 func (callInfo CallInfo) updateCRD(ch int, crd *CRD) {
+	rtspClient := callInfo.rtspClient
 	key := ClientKey{
 		CallKey: callInfo.CallKey,
 		ch:      ch,
@@ -203,7 +202,10 @@ func (callInfo *CallInfo) doRecordRTP(flag bool) {
 }
 
 func (callInfo CallInfo) atLeastChannelRecord() bool {
-	for i := 0; i < rtspClient.MaxCh; i++ {
+	// Not renumbered meanwhile
+	callInfo.cfg.mutex.RLock()
+	defer callInfo.cfg.mutex.RUnlock()
+	for i := 0; i < callInfo.cfg.config.MaxCh; i++ {
 		c := callInfo.getClientIfExist(i)
 		if c.rtspState == constant.RTSP_STATE_RECORD || c.RecorderType == constant.RET_PHONE && c.rtspState == constant.RTSP_STATE_PAUSE {
 			return true
@@ -213,6 +215,7 @@ func (callInfo CallInfo) atLeastChannelRecord() bool {
 }
 
 func (callInfo CallInfo) UpdatelistenPort(listenPort int) {
+	rtspClient := callInfo.rtspClient
 	defer callInfo.updateListenConn(listenPort)
 	callInfo.Lock()
 	defer callInfo.Unlock()
@@ -225,6 +228,7 @@ func (callInfo CallInfo) UpdatelistenPort(listenPort int) {
 }
 
 func (callInfo CallInfo) isSleep() bool {
+	rtspClient := callInfo.rtspClient
 	oldCallInfo, ok := rtspClient.GetCallInfoIfExist(callInfo.CallKey)
 	if !ok {
 		return false
@@ -233,6 +237,7 @@ func (callInfo CallInfo) isSleep() bool {
 }
 
 func (callInfo CallInfo) setSleep(sleep bool) {
+	rtspClient := callInfo.rtspClient
 	callInfo.Lock()
 	defer callInfo.Unlock()
 	oldCallInfo, ok := rtspClient.GetCallInfoIfExist(callInfo.CallKey)
@@ -243,6 +248,7 @@ func (callInfo CallInfo) setSleep(sleep bool) {
 }
 
 func (callInfo CallInfo) setBlockState(blockState constant.BlockState) {
+	rtspClient := callInfo.rtspClient
 	callInfo.Lock()
 	defer callInfo.Unlock()
 	oldCallInfo, ok := rtspClient.GetCallInfoIfExist(callInfo.CallKey)
@@ -252,74 +258,59 @@ func (callInfo CallInfo) setBlockState(blockState constant.BlockState) {
 	}
 }
 
-func (callInfo CallInfo) HandleCallMediaState(callMediaState constant.CallMediaState, crdMsg string, crdMsgId string) {
+// The Handle* functions queue an event of the call. They return
+// EVENT_QUEUE_FULL if the event was dropped by the policy of the queue, or if
+// ctx was done while QUEUE_BLOCK waited for room, and EVENT_DROPPED_BLOCKED
+// once the call ended.
+
+func (callInfo CallInfo) HandleCallMediaState(ctx context.Context, callMediaState constant.CallMediaState, crd []models.CRDField) constant.EventResult {
 	if !callInfo.isSleep() && callMediaState == constant.PJSUA_CALL_MEDIA_ACTIVE {
-		select {
-		case callInfo.chCallMediaStateInfo <- CallMediaStateInfo{state: callMediaState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
-		default:
-		}
-	} else {
-		select {
-		case <-callInfo.chLastCallMediaStateInfo:
-		default:
-		}
-		callInfo.chLastCallMediaStateInfo <- CallMediaStateInfo{state: callMediaState, crdMsg: crdMsg, crdMsgId: crdMsgId}
+		return callInfo.events.push(ctx, queuedEvent{event: constant.CALL_MEDIA_STATE_EVENT, state: int(callMediaState), crd: crd})
 	}
+	return callInfo.debounced.push(ctx, queuedEvent{event: constant.CALL_MEDIA_STATE_EVENT, state: int(callMediaState), crd: crd})
 }
 
-func (callInfo CallInfo) HandleRadioButtonState(radioButtonState constant.RadioButtonState, crdMsg string, crdMsgId string) {
+func (callInfo CallInfo) HandleRadioButtonState(ctx context.Context, radioButtonState constant.RadioButtonState, crd []models.CRDField) constant.EventResult {
 	if radioButtonState == constant.BUTTON_INVALID {
-		select {
-		case callInfo.chRadioButtonStateInfo <- RadioButtonStateInfo{state: radioButtonState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
+		result := callInfo.events.push(ctx, queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(radioButtonState), crd: crd})
+		if result == constant.EVENT_ACCEPTED {
 			callInfo.setBlockState(constant.NORMAL_BLOCK)
-		default:
 		}
+		return result
 	} else if !callInfo.isSleep() && (radioButtonState == constant.TX_BUTTON_ON || radioButtonState == constant.RX_BUTTON_ON) {
-		select {
-		case callInfo.chRadioButtonStateInfo <- RadioButtonStateInfo{state: radioButtonState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
-		default:
-		}
-	} else {
-		select {
-		case <-callInfo.chLastRadioButtonStateInfo:
-		default:
-		}
-		callInfo.chLastRadioButtonStateInfo <- RadioButtonStateInfo{state: radioButtonState, crdMsg: crdMsg, crdMsgId: crdMsgId}
+		return callInfo.events.push(ctx, queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(radioButtonState), crd: crd})
 	}
+	return callInfo.debounced.push(ctx, queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(radioButtonState), crd: crd})
 }
 
-func (callInfo CallInfo) HandleBriefState(briefState constant.BriefState, crdMsg string, crdMsgId string) {
-	select {
-	case callInfo.chBriefStateInfo <- BriefStateInfo{state: briefState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
-		if briefState == constant.BRIEF_FALSE {
-			callInfo.setBlockState(constant.NORMAL_BLOCK)
-		}
-	default:
+func (callInfo CallInfo) HandleBriefState(ctx context.Context, briefState constant.BriefState, crd []models.CRDField) constant.EventResult {
+	result := callInfo.events.push(ctx, queuedEvent{event: constant.BRIEF_STATE_EVENT, state: int(briefState), crd: crd})
+	if result == constant.EVENT_ACCEPTED && briefState == constant.BRIEF_FALSE {
+		callInfo.setBlockState(constant.NORMAL_BLOCK)
 	}
+	return result
 }
 
-func (callInfo CallInfo) HandleGroupState(groupState constant.GroupState, crdMsg string, crdMsgId string) {
-	select {
-	case callInfo.chGroupStateInfo <- GroupStateInfo{state: groupState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
-		if groupState == constant.GROUP_FALSE {
-			callInfo.setBlockState(constant.NORMAL_BLOCK)
-		}
-	default:
+func (callInfo CallInfo) HandleGroupState(ctx context.Context, groupState constant.GroupState, crd []models.CRDField) constant.EventResult {
+	result := callInfo.events.push(ctx, queuedEvent{event: constant.GROUP_STATE_EVENT, state: int(groupState), crd: crd})
+	if result == constant.EVENT_ACCEPTED && groupState == constant.GROUP_FALSE {
+		callInfo.setBlockState(constant.NORMAL_BLOCK)
 	}
+	return result
 }
 
-func (callInfo CallInfo) HandleCallState(callState constant.CallState, crdMsg string, crdMsgId string) {
-	select {
-	case callInfo.chCallStateInfo <- CallStateInfo{state: callState, crdMsg: crdMsg, crdMsgId: crdMsgId}:
-		if callState == constant.PJSIP_INV_STATE_DISCONNECTED {
-			callInfo.setBlockState(constant.NORMAL_BLOCK)
-		}
-	default:
+func (callInfo CallInfo) HandleCallState(ctx context.Context, callState constant.CallState, crd []models.CRDField) constant.EventResult {
+	result := callInfo.events.push(ctx, queuedEvent{event: constant.CALL_STATE_EVENT, state: int(callState), crd: crd})
+	if result == constant.EVENT_ACCEPTED && callState == constant.PJSIP_INV_STATE_DISCONNECTED {
+		callInfo.setBlockState(constant.NORMAL_BLOCK)
 	}
+	return result
 }
 
-func (callInfo *CallInfo) SetCRD(crdMsg string, crdMsgId string) {
-	MaxCh := rtspClient.MaxCh
+func (callInfo *CallInfo) SetCRD(crdFields []models.CRDField) {
+	callInfo.crdHistory.add(crdFields)
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 	var wg sync.WaitGroup
 	for i := 0; i < MaxCh; i++ {
 		wg.Add(1)
@@ -331,7 +322,7 @@ func (callInfo *CallInfo) SetCRD(crdMsg string, crdMsgId string) {
 			if c.rtspState == constant.RTSP_STATE_DISCONNECT || c.rtspState == constant.RTSP_STATE_NULL {
 				crd.Value = ""
 			}
-			crd.SetCRDInner(crdMsg, crdMsgId, rtspClient.ed137Versions[i], callInfo.RecorderType)
+			crd.SetCRDInner(crdFields, cfg.ed137Versions[i], callInfo.RecorderType)
 		}(i)
 	}
 	wg.Wait()
@@ -339,11 +330,13 @@ func (callInfo *CallInfo) SetCRD(crdMsg string, crdMsgId string) {
 }
 
 func (callInfo *CallInfo) doOnBriefState(briefState constant.BriefState) {
-	MaxCh := rtspClient.MaxCh
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 	var wg sync.WaitGroup
 	once := sync.Once{}
 	for j := 0; j < MaxCh; j++ {
-		if rtspClient.recGroups[j] {
+		if !callInfo.usesChannel(j) {
 			continue
 		}
 		wg.Add(1)
@@ -362,18 +355,18 @@ func (callInfo *CallInfo) doOnBriefState(briefState constant.BriefState) {
 				crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 				u, err := c.Start(crd)
 				if err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error Starting:", err)
-					c.rtspState = constant.RTSP_STATE_DISCONNECT
+					rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 					return
 				}
 				if err = c.AnnounceSetup(u); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending Announce or SETUP request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending Announce or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 				if err = c.Record(crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending RECORD request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending RECORD request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 			} else if briefState == constant.BRIEF_FALSE && rtspState == constant.RTSP_STATE_RECORD {
@@ -389,11 +382,13 @@ func (callInfo *CallInfo) doOnBriefState(briefState constant.BriefState) {
 }
 
 func (callInfo *CallInfo) doOnGroupState(groupState constant.GroupState) {
-	MaxCh := rtspClient.MaxCh
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 	var wg sync.WaitGroup
 	once := sync.Once{}
 	for j := 0; j < MaxCh; j++ {
-		if !rtspClient.recGroups[j] && callInfo.RecorderType != constant.RET_AMBIENT {
+		if !callInfo.usesChannel(j) {
 			continue
 		}
 		wg.Add(1)
@@ -412,18 +407,18 @@ func (callInfo *CallInfo) doOnGroupState(groupState constant.GroupState) {
 				crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 				u, err := c.Start(crd)
 				if err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error Starting:", err)
-					c.rtspState = constant.RTSP_STATE_DISCONNECT
+					rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 					return
 				}
 				if err = c.AnnounceSetup(u); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending Announce or SETUP request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending Announce or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 				if err = c.Record(crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending RECORD request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending RECORD request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 			} else if groupState == constant.GROUP_FALSE && rtspState == constant.RTSP_STATE_RECORD {
@@ -440,12 +435,14 @@ func (callInfo *CallInfo) doOnGroupState(groupState constant.GroupState) {
 }
 
 func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonState) {
-	MaxCh := rtspClient.MaxCh
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 	recorderType := callInfo.RecorderType
 	var wg sync.WaitGroup
 	once := sync.Once{}
 	for j := 0; j < MaxCh; j++ {
-		if rtspClient.recGroups[j] {
+		if !callInfo.usesChannel(j) {
 			continue
 		}
 		wg.Add(1)
@@ -458,9 +455,9 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 			rtspState := c.rtspState
 			if (radioButtonState == constant.TX_BUTTON_OFF && recorderType == constant.RET_RADIO_TX || radioButtonState == constant.RX_BUTTON_OFF && recorderType == constant.RET_RADIO_RX) && (int(rtspState) <= int(constant.RTSP_STATE_START) || rtspState == constant.RTSP_STATE_DISCONNECT || c.client.IsClose()) {
 				if c.client.IsClose() {
-					fmt.Println("\n\n\n\nDebug 1000: Client is closed, need to restart\n\n\n")
+					rtspClient.LogDebug("Client is closed, need to restart", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch)
 				}
-				if rtspClient.ed137Versions[j] == "ED137C" {
+				if cfg.ed137Versions[j] == "ED137C" {
 					if recorderType == constant.RET_RADIO_TX {
 						crd.Properties.CallRef.Value += ("_PTT_" + utils.CreateRand4Digits())
 					} else {
@@ -471,18 +468,18 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 				crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 				u, err := c.Start(crd)
 				if err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", "channel:", c.ch, "Error Starting:", err)
-					c.rtspState = constant.RTSP_STATE_DISCONNECT
+					rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 					return
 				}
 				if err := c.AnnounceSetup(u); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending Announce or SETUP request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending Announce or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 				if err := c.SetParameter(u, crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending SET_PARAMETER request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending SET_PARAMETER request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 
@@ -502,8 +499,8 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 				crd.EnableConfirmRadio()
 				crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 				if err := c.Record(crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending RECORD request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending RECORD request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 
@@ -520,8 +517,8 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 				crd.Properties.DisconnectCause = models.CRDAttribute{Value: strconv.Itoa(int(GetDisconnectCause(crd.Properties.SipDisconnectCause.Value, nil)))}
 				crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 				if err := c.Pause(crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", "channel:", c.ch, "Error sending PAUSE request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending PAUSE request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 
@@ -529,7 +526,7 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 				defer once.Do(func() {
 					callInfo.doRecordRTP(true)
 				})
-				if rtspClient.ed137Versions[j] == "ED137C" {
+				if cfg.ed137Versions[j] == "ED137C" {
 					if recorderType == constant.RET_RADIO_TX {
 						crd.Properties.CallRef.Value += ("_PTT_" + utils.CreateRand4Digits())
 					} else {
@@ -542,18 +539,18 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 
 				u, err := c.Start(crd)
 				if err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error Starting:", err)
-					c.rtspState = constant.RTSP_STATE_DISCONNECT
+					rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 					return
 				}
 				if err := c.AnnounceSetup(u); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending ANNOUNCE or SETUP request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending ANNOUNCE or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 				if err := c.SetParameter(u, crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending SET_PARAMETER request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending SET_PARAMETER request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 				if recorderType == constant.RET_RADIO_TX {
@@ -569,8 +566,8 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 				crd.EnableConfirmRadio()
 				crdByt, _ = xml.MarshalIndent(crd, "", "    ")
 				if err = c.Record(crd, crdByt); err != nil {
-					rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending RECORD request:", err)
-					c.CloseByErr()
+					rtspClient.LogDebug("Error sending RECORD request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+					c.closeOnErr(err)
 					return
 				}
 
@@ -588,13 +585,14 @@ func (callInfo *CallInfo) doOnRadioState(radioButtonState constant.RadioButtonSt
 }
 
 func (callInfo *CallInfo) doOnCallState(callState constant.CallState) {
-	rtspClient := GetRTSPClient()
-	MaxCh := rtspClient.MaxCh
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 	var wg sync.WaitGroup
 	once := sync.Once{}
 	if callInfo.RecorderType == constant.RET_PHONE {
 		for j := 0; j < MaxCh; j++ {
-			if rtspClient.recGroups[j] {
+			if !callInfo.usesChannel(j) {
 				continue
 			}
 			wg.Add(1)
@@ -612,18 +610,18 @@ func (callInfo *CallInfo) doOnCallState(callState constant.CallState) {
 						crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 						u, err := c.Start(crd)
 						if err != nil {
-							rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error Starting:", err)
-							c.rtspState = constant.RTSP_STATE_DISCONNECT
+							rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+							c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 							return
 						}
 						if err := c.AnnounceSetup(u); err != nil {
-							rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending ANNOUNCE or SETUP request:", err)
-							c.CloseByErr()
+							rtspClient.LogDebug("Error sending ANNOUNCE or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+							c.closeOnErr(err)
 							return
 						}
 						if err := c.SetParameter(u, crd, crdByt); err != nil {
-							rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending SET_PARAMETER request:", err)
-							c.CloseByErr()
+							rtspClient.LogDebug("Error sending SET_PARAMETER request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+							c.closeOnErr(err)
 							return
 						}
 					}
@@ -636,8 +634,8 @@ func (callInfo *CallInfo) doOnCallState(callState constant.CallState) {
 						crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 
 						if err := c.Record(crd, crdByt); err != nil {
-							rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending Record request:", err)
-							c.CloseByErr()
+							rtspClient.LogDebug("Error sending Record request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+							c.closeOnErr(err)
 							return
 						}
 
@@ -659,13 +657,14 @@ func (callInfo *CallInfo) doOnCallState(callState constant.CallState) {
 }
 
 func (callInfo *CallInfo) doOnCallMediaState(mediaState constant.CallMediaState) {
-	rtspClient := GetRTSPClient()
-	MaxCh := rtspClient.MaxCh
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	MaxCh := cfg.MaxCh
 
 	var wg sync.WaitGroup
 	once := sync.Once{}
 	for j := 0; j < MaxCh; j++ {
-		if rtspClient.recGroups[j] {
+		if !callInfo.usesChannel(j) {
 			continue
 		}
 		wg.Add(1)
@@ -692,8 +691,8 @@ func (callInfo *CallInfo) doOnCallMediaState(mediaState constant.CallMediaState)
 					}
 					crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 					if err := c.Pause(crd, crdByt); err != nil {
-						rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending PAUSE request:", err)
-						c.CloseByErr()
+						rtspClient.LogDebug("Error sending PAUSE request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+						c.closeOnErr(err)
 						return
 					}
 				} else {
@@ -705,8 +704,8 @@ func (callInfo *CallInfo) doOnCallMediaState(mediaState constant.CallMediaState)
 					}
 					crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 					if err := c.SetParameter(nil, crd, crdByt); err != nil {
-						rtspClient.LogDebug("name", c.Name, "recorderType:", "channel:", c.ch, "Error sending SET_PARAMETER request:", err)
-						c.CloseByErr()
+						rtspClient.LogDebug("Error sending SET_PARAMETER request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+						c.closeOnErr(err)
 						return
 					}
 
@@ -722,8 +721,8 @@ func (callInfo *CallInfo) doOnCallMediaState(mediaState constant.CallMediaState)
 					crd.Operations.HOLD.Value = strconv.Itoa(int(constant.HOLD_OFF))
 					crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 					if err := c.Record(crd, crdByt); err != nil {
-						rtspClient.LogDebug("name", c.Name, "recorderType:", int(c.RecorderType), "channel:", c.ch, "Error sending Record request:", err)
-						c.CloseByErr()
+						rtspClient.LogDebug("Error sending Record request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+						c.closeOnErr(err)
 						return
 					}
 
@@ -735,12 +734,13 @@ func (callInfo *CallInfo) doOnCallMediaState(mediaState constant.CallMediaState)
 }
 
 func (callInfo *CallInfo) sendRTPInner() {
+	rtspClient := callInfo.rtspClient
 	defer callInfo.wg.Done() // Signal completion when the function exits
-	rtspClient.LogDebug("Starting sendRTPInner for name:", callInfo.Name)
+	rtspClient.LogDebug("Starting sendRTPInner", "name", callInfo.Name)
 
-	intervalDuration := 200 * time.Millisecond
+	intervalDuration := callInfo.timings.rtpPoll()
 	interval := gortsplib.EmptyTimer()
-	readTimeDuration := 30 * time.Millisecond
+	readTimeDuration := callInfo.timings.rtpRead()
 	var listenConn *net.UDPConn = nil
 	listenPort := 0
 	isRecord := false
@@ -749,14 +749,14 @@ func (callInfo *CallInfo) sendRTPInner() {
 	defer func() {
 		if listenConn != nil {
 			_ = listenConn.Close()
-			rtspClient.LogDebug("Closed listenConn for name:", callInfo.Name)
+			rtspClient.LogDebug("Closed listenConn", "name", callInfo.Name)
 		}
 	}()
 
 	for {
 		select {
 		case <-callInfo.chDone:
-			rtspClient.LogDebug("Received done signal in sendRTPInner for name:", callInfo.Name)
+			rtspClient.LogDebug("Received done signal in sendRTPInner", "name", callInfo.Name)
 			return
 
 		case newlistenPort := <-callInfo.chUpdateListenConn:
@@ -766,11 +766,11 @@ func (callInfo *CallInfo) sendRTPInner() {
 			var err error
 			listenConn, err = utils.CreateListenServer("127.0.0.1:" + strconv.Itoa(newlistenPort))
 			if err != nil {
-				rtspClient.LogDebug("Failed to create listen server for name:", callInfo.Name, "Error:", err)
+				rtspClient.LogDebug("Failed to create listen server", "name", callInfo.Name, "err", err)
 				return
 			}
 			listenPort = newlistenPort
-			rtspClient.LogDebug("Updated listen port to", listenPort, "for name:", callInfo.Name)
+			rtspClient.LogDebug("Updated listen port", "name", callInfo.Name, "port", listenPort)
 
 		case newIsRecord := <-callInfo.chRecordRTP:
 			if newIsRecord {
@@ -780,7 +780,7 @@ func (callInfo *CallInfo) sendRTPInner() {
 				interval = gortsplib.EmptyTimer()
 			}
 			isRecord = newIsRecord
-			rtspClient.LogDebug("Recording state changed to", isRecord, "for name:", callInfo.Name)
+			rtspClient.LogDebug("Recording state changed", "name", callInfo.Name, "record", isRecord)
 
 		case <-interval.C:
 			if !callInfo.atLeastChannelRecord() {
@@ -790,6 +790,14 @@ func (callInfo *CallInfo) sendRTPInner() {
 			callInfo.readRTPPacket(&listPkt, readTimeDuration, &listenConn, listenPort)
 			callInfo.sendRTPPacket(&listPkt, &rtpInfo)
 			interval = time.NewTimer(intervalDuration)
+
+		case pkt := <-callInfo.chPushRTP:
+			listPkt := []rtp.Packet{pkt}
+			callInfo.drainPushedRTP(&listPkt)
+			if !isRecord || !callInfo.atLeastChannelRecord() {
+				break
+			}
+			callInfo.sendRTPPacket(&listPkt, &rtpInfo)
 		}
 	}
 }
@@ -820,6 +828,7 @@ func (callInfo *CallInfo) readRTPPacket(listPkt *[]rtp.Packet, readTimeDuration
 		if err = pkt.Unmarshal(buf[:n]); err != nil {
 			break
 		}
+		callInfo.stats.add(n)
 		if pkt.Timestamp == uint32(0) || len(pkt.Payload) == 0 {
 			continue
 		}
@@ -827,35 +836,58 @@ func (callInfo *CallInfo) readRTPPacket(listPkt *[]rtp.Packet, readTimeDuration
 	}
 }
 
+// drainPushedRTP appends the packets already waiting in the push queue so they
+// are forwarded together.
+func (callInfo *CallInfo) drainPushedRTP(listPkt *[]rtp.Packet) {
+	for {
+		select {
+		case pkt := <-callInfo.chPushRTP:
+			*listPkt = append(*listPkt, pkt)
+		default:
+			return
+		}
+	}
+}
+
 func (callInfo *CallInfo) sendRTPPacket(listPkt *[]rtp.Packet, rtpInfo *RTPInfo) {
 	if len(*listPkt) == 0 {
 		return
 	}
-	MaxCh := rtspClient.MaxCh
-	newListPkt := []rtp.Packet{}
+	// Not renumbered meanwhile
+	callInfo.cfg.mutex.RLock()
+	defer callInfo.cfg.mutex.RUnlock()
+	MaxCh := callInfo.cfg.config.MaxCh
 
 	*listPkt = callInfo.mergePktWithSameSsrc(*listPkt, rtpInfo)
-	for _, pkt := range *listPkt {
-		if utils.ConvertCodec(&pkt, rtspClient.codec) {
-			newListPkt = append(newListPkt, pkt)
-		}
-	}
-	*listPkt = newListPkt
+	// Packets converted to each codec, so the channels sharing a codec share
+	// one conversion
+	listPktByCodec := make(map[string][]rtp.Packet)
 	var wg sync.WaitGroup
 	for j := 0; j < MaxCh; j++ {
+		c := callInfo.getClientIfExist(j)
+		if c.rtspState != constant.RTSP_STATE_RECORD && (c.RecorderType != constant.RET_PHONE || c.rtspState != constant.RTSP_STATE_PAUSE) || c.desc == nil {
+			continue
+		}
+		listCodecPkt, ok := listPktByCodec[c.codec]
+		if !ok {
+			for _, pkt := range *listPkt {
+				if utils.ConvertCodec(&pkt, c.codec) {
+					listCodecPkt = append(listCodecPkt, pkt)
+				}
+			}
+			listPktByCodec[c.codec] = listCodecPkt
+		}
 		wg.Add(1)
-		go func(j int) {
+		go func(c Client, listCodecPkt []rtp.Packet) {
 			defer wg.Done()
-			c := callInfo.getClientIfExist(j)
-			if c.rtspState != constant.RTSP_STATE_RECORD && (c.RecorderType != constant.RET_PHONE || c.rtspState != constant.RTSP_STATE_PAUSE) {
-				return
-			}
-			for _, pkt := range *listPkt {
-				if err := c.SendRTPPacket(rtspClient.desc.Medias[0], pkt); err != nil {
+			payloadType := c.desc.Medias[0].Formats[0].PayloadType()
+			for _, pkt := range listCodecPkt {
+				pkt.PayloadType = payloadType
+				if err := c.SendRTPPacket(c.desc.Medias[0], pkt); err != nil {
 					return
 				}
 			}
-		}(j)
+		}(c, listCodecPkt)
 	}
 	wg.Wait()
 }
@@ -918,12 +950,24 @@ func (callInfo *CallInfo) mergePktWithSameSsrc(listPkt []rtp.Packet, rtpInfo *RT
 }
 
 func (c *Client) SendRTPPacket(media *description.Media, pkt rtp.Packet) error {
-	return c.client.WritePacketRTP(rtspClient.desc.Medias[0], &pkt)
+	if err := c.client.WritePacketRTP(media, &pkt); err != nil {
+		c.stats.addErr()
+		return err
+	}
+	c.stats.add(pkt.MarshalSize())
+	return nil
+}
+
+// stop signals every goroutine of the call to exit.
+func (callInfo CallInfo) stop() {
+	callInfo.doneOnce.Do(func() {
+		close(callInfo.chDone)
+	})
 }
 
 func (callInfo *CallInfo) runInner() {
-	once := sync.Once{}
-	rtspClient.LogDebug("Starting runInner for call name:", callInfo.Name)
+	rtspClient := callInfo.rtspClient
+	rtspClient.LogDebug("Starting runInner", "name", callInfo.Name)
 
 	// Start handleInner and sendRTPInner
 	callInfo.wg.Add(2)
@@ -932,66 +976,106 @@ func (callInfo *CallInfo) runInner() {
 
 	// Ensure cleanup when the function exits
 	defer func() {
-		rtspClient.LogDebug("Stopping runInner for call name:", callInfo.Name)
+		rtspClient.LogDebug("Stopping runInner", "name", callInfo.Name)
 		callInfo.cleanupResources()
 	}()
 
+	// No lock is held across the requests to the recorders: a reloaded
+	// config is taken here, between two of them
 	for {
+		// A failed session moves to its standby, and a reloaded config is
+		// taken, before the next event
+		select {
+		case client := <-callInfo.chLostSession:
+			callInfo.handleLostSession(client)
+			continue
+		case <-callInfo.cfg.ready:
+			callInfo.applyConfigs()
+			continue
+		default:
+		}
 		select {
 		case <-callInfo.chDone:
-			rtspClient.LogDebug("Received done signal for call name:", callInfo.Name)
+			rtspClient.LogDebug("Received done signal", "name", callInfo.Name)
+			callInfo.closeSessions()
 			return
 
-		case callStateInfo := <-callInfo.chCallStateInfo:
-			callInfo.SetCRD(callStateInfo.crdMsg, callStateInfo.crdMsgId)
-			callInfo.doOnCallState(callStateInfo.state)
-			if callStateInfo.state == constant.PJSIP_INV_STATE_DISCONNECTED {
-				once.Do(func() {
-					close(callInfo.chDone)
-				})
-			}
-		case radioButtonStateInfo := <-callInfo.chRadioButtonStateInfo:
-			callInfo.SetCRD(radioButtonStateInfo.crdMsg, radioButtonStateInfo.crdMsgId)
-			callInfo.doOnRadioState(radioButtonStateInfo.state)
-			if radioButtonStateInfo.state == constant.BUTTON_INVALID {
-				once.Do(func() {
-					close(callInfo.chDone)
-				})
-			}
-		case mediaStateInfo := <-callInfo.chCallMediaStateInfo:
-			callInfo.SetCRD(mediaStateInfo.crdMsg, mediaStateInfo.crdMsgId)
-			callInfo.doOnCallMediaState(mediaStateInfo.state)
-		case briefStateInfo := <-callInfo.chBriefStateInfo:
-			callInfo.SetCRD(briefStateInfo.crdMsg, briefStateInfo.crdMsgId)
-			callInfo.doOnBriefState(briefStateInfo.state)
-			if briefStateInfo.state == constant.BRIEF_FALSE {
-				once.Do(func() {
-					close(callInfo.chDone)
-				})
+		case <-callInfo.events.ready:
+			event, ok := callInfo.events.pop()
+			if !ok {
+				continue
 			}
-		case groupStateInfo := <-callInfo.chGroupStateInfo:
-			callInfo.SetCRD(groupStateInfo.crdMsg, groupStateInfo.crdMsgId)
-			callInfo.doOnGroupState(groupStateInfo.state)
-			if groupStateInfo.state == constant.GROUP_FALSE {
-				once.Do(func() {
-					close(callInfo.chDone)
-				})
+			callInfo.doEvent(event)
+			if event.ends() {
+				callInfo.stop()
 			}
+		case <-callInfo.cfg.ready:
+			callInfo.applyConfigs()
+		case client := <-callInfo.chLostSession:
+			callInfo.handleLostSession(client)
+		case recAddr := <-callInfo.chReconnect:
+			callInfo.doReconnect(recAddr)
+		case call := <-callInfo.chRecover:
+			callInfo.doRecover(call)
 		}
+		callInfo.journalState()
+	}
+}
 
+// doEvent brings the sessions of the call to the state of event.
+func (callInfo *CallInfo) doEvent(event queuedEvent) {
+	failover := callInfo.failover
+	switch event.event {
+	case constant.CALL_STATE_EVENT:
+		failover.setTarget(callStateTarget(constant.CallState(event.state), failover.getTarget()))
+	case constant.RADIO_STATE_EVENT:
+		failover.setTarget(radioStateTarget(constant.RadioButtonState(event.state), failover.getTarget()))
+	case constant.CALL_MEDIA_STATE_EVENT:
+		failover.setTarget(callMediaStateTarget(constant.CallMediaState(event.state), failover.getTarget()))
+	case constant.BRIEF_STATE_EVENT:
+		failover.setTarget(briefStateTarget(constant.BriefState(event.state)))
+	case constant.GROUP_STATE_EVENT:
+		failover.setTarget(groupStateTarget(constant.GroupState(event.state)))
+	}
+	callInfo.SetCRD(event.crd)
+	switch event.event {
+	case constant.CALL_STATE_EVENT:
+		callInfo.doOnCallState(constant.CallState(event.state))
+	case constant.RADIO_STATE_EVENT:
+		callInfo.doOnRadioState(constant.RadioButtonState(event.state))
+	case constant.CALL_MEDIA_STATE_EVENT:
+		callInfo.doOnCallMediaState(constant.CallMediaState(event.state))
+	case constant.BRIEF_STATE_EVENT:
+		callInfo.doOnBriefState(constant.BriefState(event.state))
+	case constant.GROUP_STATE_EVENT:
+		callInfo.doOnGroupState(constant.GroupState(event.state))
+	}
+}
+
+// closeSessions closes the sessions a call stopped before its end event still
+// has open.
+func (callInfo *CallInfo) closeSessions() {
+	for i := 0; i < callInfo.config().MaxCh; i++ {
+		c := callInfo.getClientIfExist(i)
+		if c.rtspState == constant.RTSP_STATE_NULL || c.rtspState == constant.RTSP_STATE_DISCONNECT || c.client.IsClose() {
+			continue
+		}
+		c.CloseByErr()
+		callInfo.updateClient(i, &c)
 	}
 }
 
 func (callInfo *CallInfo) cleanupResources() {
-	rtspClient := GetRTSPClient()
+	rtspClient := callInfo.rtspClient
 
 	// Wait for handleInner and sendRTPInner to finish
-	rtspClient.LogDebug("Waiting for handleInner and sendRTPInner to finish for call name", callInfo.Name)
+	rtspClient.LogDebug("Waiting for handleInner and sendRTPInner to finish", "name", callInfo.Name)
 	callInfo.wg.Wait()
-	rtspClient.LogDebug("handleInner and sendRTPInner finished for call name:", callInfo.Name)
+	rtspClient.LogDebug("handleInner and sendRTPInner finished", "name", callInfo.Name)
+	callInfo.reconnect.stop(callInfo)
 
 	// Remove clients and CRDs
-	for i := 0; i < rtspClient.MaxCh; i++ {
+	for i := 0; i < callInfo.config().MaxCh; i++ {
 		cKey := ClientKey{
 			CallKey: callInfo.CallKey,
 			ch:      i,
@@ -1000,20 +1084,24 @@ func (callInfo *CallInfo) cleanupResources() {
 		rtspClient.crds.listCRD.Remove(cKey)
 	}
 
+	rtspClient.journal.end(callInfo.CallKey)
+
 	// Remove call info
 	callInfo.Lock()
 	rtspClient.callModel.listCallInfo.Remove(callInfo.CallKey)
 	callInfo.Unlock()
 
-	rtspClient.LogDebug("Cleanup completed for call name:", callInfo.Name)
+	rtspClient.LogDebug("Cleanup completed", "name", callInfo.Name)
+	rtspClient.calls.done()
 }
 
 func (callInfo *CallInfo) handleInner() {
 	defer callInfo.wg.Done() // Signal completion when the function exits
-	rtspClient := GetRTSPClient()
-	rtspClient.LogDebug("Starting handleInner for call name:", callInfo.Name)
+	rtspClient := callInfo.rtspClient
+	rtspClient.LogDebug("Starting handleInner", "name", callInfo.Name)
 
-	wakeupTime := time.After(1000 * time.Second)
+	// Nil, never ready, until the call goes to sleep
+	var wakeupTime <-chan time.Time
 	lastRadioButtonStateInfo := RadioButtonStateInfo{state: constant.BUTTON_INVALID}
 	lastCallMediaStateInfo := CallMediaStateInfo{state: constant.PJSUA_CALL_MEDIA_NONE}
 	lastPutRadioButtonState := constant.BUTTON_INVALID
@@ -1021,43 +1109,51 @@ func (callInfo *CallInfo) handleInner() {
 	for {
 		select {
 		case <-callInfo.chDone:
-			rtspClient.LogDebug("Received done signal in handleInner for call name:", callInfo.Name)
+			rtspClient.LogDebug("Received done signal in handleInner", "name", callInfo.Name)
 			return
 
 		case <-callInfo.goSleep:
 			switch callInfo.RecorderType {
-			case constant.RET_PHONE:
-				wakeupTime = time.After(5 * time.Second)
-			case constant.RET_RADIO_TX, constant.RET_RADIO_RX:
-				wakeupTime = time.After(5 * time.Second)
+			case constant.RET_PHONE, constant.RET_RADIO_TX, constant.RET_RADIO_RX:
+				wakeupTime = callInfo.after(callInfo.timings.hangTime())
 			}
 
 		case <-wakeupTime:
 			callInfo.setSleep(false)
 			if lastRadioButtonStateInfo.state != constant.BUTTON_INVALID {
-				callInfo.chRadioButtonStateInfo <- lastRadioButtonStateInfo
-				lastPutRadioButtonState = lastRadioButtonStateInfo.state
+				if callInfo.forwardDebounced(queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(lastRadioButtonStateInfo.state), crd: lastRadioButtonStateInfo.crd}) {
+					lastPutRadioButtonState = lastRadioButtonStateInfo.state
+				}
 				lastRadioButtonStateInfo = RadioButtonStateInfo{state: constant.BUTTON_INVALID}
 			} else if lastCallMediaStateInfo.state != constant.PJSUA_CALL_MEDIA_NONE {
-				callInfo.chCallMediaStateInfo <- lastCallMediaStateInfo
+				callInfo.forwardDebounced(queuedEvent{event: constant.CALL_MEDIA_STATE_EVENT, state: int(lastCallMediaStateInfo.state), crd: lastCallMediaStateInfo.crd})
 				lastCallMediaStateInfo = CallMediaStateInfo{state: constant.PJSUA_CALL_MEDIA_NONE}
 			}
 
-		case lastCallMediaStateInfo = <-callInfo.chLastCallMediaStateInfo:
-			if !callInfo.isSleep() && (lastCallMediaStateInfo.state == constant.PJSUA_CALL_MEDIA_LOCAL_HOLD || lastCallMediaStateInfo.state == constant.PJSUA_CALL_MEDIA_REMOTE_HOLD) {
-				select {
-				case callInfo.goSleep <- true:
-					callInfo.setSleep(true)
-				default:
-				}
+		case <-callInfo.debounced.ready:
+			event, ok := callInfo.debounced.pop()
+			if !ok {
+				continue
 			}
-
-		case lastRadioButtonStateInfo = <-callInfo.chLastRadioButtonStateInfo:
-			if !callInfo.isSleep() && (lastRadioButtonStateInfo.state == constant.TX_BUTTON_OFF || lastRadioButtonStateInfo.state == constant.RX_BUTTON_OFF) {
-				if lastPutRadioButtonState == constant.BUTTON_INVALID {
-					callInfo.chRadioButtonStateInfo <- lastRadioButtonStateInfo
-					lastPutRadioButtonState = lastRadioButtonStateInfo.state
-				} else {
+			switch event.event {
+			case constant.RADIO_STATE_EVENT:
+				lastRadioButtonStateInfo = RadioButtonStateInfo{state: constant.RadioButtonState(event.state), crd: event.crd}
+				if !callInfo.isSleep() && (lastRadioButtonStateInfo.state == constant.TX_BUTTON_OFF || lastRadioButtonStateInfo.state == constant.RX_BUTTON_OFF) {
+					if lastPutRadioButtonState == constant.BUTTON_INVALID {
+						if callInfo.forwardDebounced(event) {
+							lastPutRadioButtonState = lastRadioButtonStateInfo.state
+						}
+					} else {
+						select {
+						case callInfo.goSleep <- true:
+							callInfo.setSleep(true)
+						default:
+						}
+					}
+				}
+			case constant.CALL_MEDIA_STATE_EVENT:
+				lastCallMediaStateInfo = CallMediaStateInfo{state: constant.CallMediaState(event.state), crd: event.crd}
+				if !callInfo.isSleep() && (lastCallMediaStateInfo.state == constant.PJSUA_CALL_MEDIA_LOCAL_HOLD || lastCallMediaStateInfo.state == constant.PJSUA_CALL_MEDIA_REMOTE_HOLD) {
 					select {
 					case callInfo.goSleep <- true:
 						callInfo.setSleep(true)
@@ -1068,3 +1164,14 @@ func (callInfo *CallInfo) handleInner() {
 		}
 	}
 }
+
+// forwardDebounced queues an event that handleInner held back. It returns
+// false if the event was dropped, which the queue counts, or if the call
+// ended.
+func (callInfo *CallInfo) forwardDebounced(event queuedEvent) bool {
+	result := callInfo.events.push(context.Background(), event)
+	if result == constant.EVENT_QUEUE_FULL {
+		callInfo.rtspClient.LogWarn("Event queue is full, debounced event dropped", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "event", int(event.event), "state", event.state)
+	}
+	return result == constant.EVENT_ACCEPTED
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+const testHangTime = 100 * time.Millisecond
+
+// testDeadline bounds the waits for handleInner, far over the time it needs.
+const testDeadline = 5 * time.Second
+
+// debounceCall is a call whose hang times are started and ended by the test.
+type debounceCall struct {
+	CallInfo
+	// The hang times started by handleInner
+	timers chan chan time.Time
+}
+
+// newDebounceCall starts the handleInner of a call without its other
+// goroutines, so the events it queues stay in the queue.
+func newDebounceCall(t *testing.T, recorderType constant.RecorderType) debounceCall {
+	rtspClient := newTestRTSPClient(t)
+	key := CallKey{Name: "1001", RecorderType: recorderType}
+	call := debounceCall{
+		CallInfo: rtspClient.newCallInfo(key),
+		timers:   make(chan chan time.Time, 1),
+	}
+	call.timings.HangTimeMs = int(testHangTime / time.Millisecond)
+	call.after = func(d time.Duration) <-chan time.Time {
+		if d != testHangTime {
+			t.Errorf("hang time %v, want %v", d, testHangTime)
+		}
+		timer := make(chan time.Time, 1)
+		call.timers <- timer
+		return timer
+	}
+	rtspClient.callModel.listCallInfo.Set(key, call.CallInfo)
+	call.wg.Add(1)
+	go call.handleInner()
+	t.Cleanup(func() {
+		call.stop()
+		call.wg.Wait()
+	})
+	return call
+}
+
+// hangTimeStarted waits for the call to go to sleep, after handleInner took
+// the event that started the hang time.
+func (call debounceCall) hangTimeStarted(t *testing.T) chan time.Time {
+	t.Helper()
+	select {
+	case timer := <-call.timers:
+		if !call.isSleep() {
+			t.Fatal("the call does not sleep during the hang time")
+		}
+		return timer
+	case <-time.After(testDeadline):
+		t.Fatal("the hang time did not start")
+		return nil
+	}
+}
+
+// waitDebounced waits for handleInner to take the events held back.
+func (call debounceCall) waitDebounced(t *testing.T) {
+	t.Helper()
+	for deadline := time.Now().Add(testDeadline); call.debounced.snapshot().Queued > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the held back events were not taken")
+		}
+	}
+}
+
+// expectStates takes the states of the queued events, waiting for as many as
+// wanted.
+func (call debounceCall) expectStates(t *testing.T, step string, want ...int) {
+	t.Helper()
+	got := []int{}
+	for deadline := time.Now().Add(testDeadline); len(got) < len(want) && time.Now().Before(deadline); {
+		if event, ok := call.events.pop(); ok {
+			got = append(got, event.state)
+			continue
+		}
+		select {
+		case <-call.events.ready:
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+	for event, ok := call.events.pop(); ok; event, ok = call.events.pop() {
+		got = append(got, event.state)
+	}
+	if want == nil {
+		want = []int{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: queued states %v, want %v", step, got, want)
+	}
+}
+
+func TestFirstPTTReleaseIsNotDebounced(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_RADIO_TX)
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_ON, nil)
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_OFF, nil)
+	call.expectStates(t, "first release", int(constant.TX_BUTTON_ON), int(constant.TX_BUTTON_OFF))
+}
+
+func TestPTTReleaseWaitsForHangTime(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_RADIO_TX)
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_ON, nil)
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_OFF, nil)
+	call.expectStates(t, "first release", int(constant.TX_BUTTON_ON), int(constant.TX_BUTTON_OFF))
+
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_ON, nil)
+	call.HandleRadioButtonState(context.Background(), constant.TX_BUTTON_OFF, nil)
+	timer := call.hangTimeStarted(t)
+	call.expectStates(t, "before the hang time", int(constant.TX_BUTTON_ON))
+	timer <- time.Now()
+	call.expectStates(t, "after the hang time", int(constant.TX_BUTTON_OFF))
+	if call.isSleep() {
+		t.Fatal("the call still sleeps after the hang time")
+	}
+}
+
+func TestPTTPressDuringHangTimeCancelsRelease(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_RADIO_RX)
+	call.HandleRadioButtonState(context.Background(), constant.RX_BUTTON_ON, nil)
+	call.HandleRadioButtonState(context.Background(), constant.RX_BUTTON_OFF, nil)
+	call.expectStates(t, "first release", int(constant.RX_BUTTON_ON), int(constant.RX_BUTTON_OFF))
+
+	call.HandleRadioButtonState(context.Background(), constant.RX_BUTTON_ON, nil)
+	call.HandleRadioButtonState(context.Background(), constant.RX_BUTTON_OFF, nil)
+	timer := call.hangTimeStarted(t)
+	call.expectStates(t, "before the hang time", int(constant.RX_BUTTON_ON))
+	// Pressed again while sleeping, the press replaces the release
+	call.HandleRadioButtonState(context.Background(), constant.RX_BUTTON_ON, nil)
+	call.waitDebounced(t)
+	call.expectStates(t, "during the hang time")
+	timer <- time.Now()
+	call.expectStates(t, "after the hang time", int(constant.RX_BUTTON_ON))
+}
+
+func TestHoldWaitsForHangTime(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_PHONE)
+	call.HandleCallMediaState(context.Background(), constant.PJSUA_CALL_MEDIA_LOCAL_HOLD, nil)
+	timer := call.hangTimeStarted(t)
+	call.expectStates(t, "before the hang time")
+	timer <- time.Now()
+	call.expectStates(t, "after the hang time", int(constant.PJSUA_CALL_MEDIA_LOCAL_HOLD))
+}
+
+func TestResumeDuringHangTimeCancelsHold(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_PHONE)
+	call.HandleCallMediaState(context.Background(), constant.PJSUA_CALL_MEDIA_REMOTE_HOLD, nil)
+	timer := call.hangTimeStarted(t)
+	call.HandleCallMediaState(context.Background(), constant.PJSUA_CALL_MEDIA_ACTIVE, nil)
+	call.waitDebounced(t)
+	call.expectStates(t, "during the hang time")
+	timer <- time.Now()
+	call.expectStates(t, "after the hang time", int(constant.PJSUA_CALL_MEDIA_ACTIVE))
+}
+
+func TestActiveIsNotDebounced(t *testing.T) {
+	call := newDebounceCall(t, constant.RET_PHONE)
+	call.HandleCallMediaState(context.Background(), constant.PJSUA_CALL_MEDIA_ACTIVE, nil)
+	call.expectStates(t, "active", int(constant.PJSUA_CALL_MEDIA_ACTIVE))
+}
+
+func TestHangTimeOfRecorderType(t *testing.T) {
+	rtspClient := newTestRTSPClient(t)
+	rtspClient.Config = testCfg(t, `{
+  "timings": {
+    "hang_time_ms": 2000,
+    "recorder_types": {"radio_tx": {"hang_time_ms": 300}}
+  },
+  "recorders": [{"address": "127.0.0.1"}]
+}`)
+	for recorderType, hangTimeMs := range map[constant.RecorderType]int{constant.RET_RADIO_TX: 300, constant.RET_RADIO_RX: 2000, constant.RET_PHONE: 2000} {
+		if timings := rtspClient.newCallInfo(CallKey{Name: "1001", RecorderType: recorderType}).timings; timings.HangTimeMs != hangTimeMs {
+			t.Errorf("%s: hang time %d ms, want %d ms", recorderType, timings.HangTimeMs, hangTimeMs)
+		}
+	}
+}
+
+func TestTimingsValidatedOnLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		timings  string
+		wantPath string
+		wantMsg  string
+	}{
+		{"read as long as the poll", `{"rtp_read_ms": 200}`, "timings", "timings: rtp_read_ms (200) must be less than rtp_poll_ms (200) for phone"},
+		{"poll of one type too short", `{"recorder_types": {"phone": {"rtp_poll_ms": 20}}}`, "timings", "timings: rtp_read_ms (30) must be less than rtp_poll_ms (20) for phone"},
+		{"negative hang time", `{"hang_time_ms": -1}`, "timings.hang_time_ms", "timings.hang_time_ms: must be at least 0"},
+		{"unknown recorder type", `{"recorder_types": {"fax": {}}}`, "timings.recorder_types.fax", "timings.recorder_types.fax: unknown key"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := "{\n  \"timings\": " + tc.timings + ",\n  \"recorders\": [{\"address\": \"127.0.0.1\"}]\n}"
+			if _, err := ParseRecFile([]byte(doc)); err == nil {
+				t.Fatal("accepted")
+			}
+			_, issues := parseRecJSON([]byte(doc), "rec.json")
+			errs := issues.Errors()
+			if len(errs) == 0 {
+				t.Fatal("no error")
+			}
+			issue := errs[0]
+			if issue.File != "rec.json" || issue.Line != 2 || issue.Recorder != -1 || issue.Path != tc.wantPath {
+				t.Errorf("got %s line %d recorder %d path %q, want rec.json line 2 recorder -1 path %q", issue.File, issue.Line, issue.Recorder, issue.Path, tc.wantPath)
+			}
+			if want := "rec.json:2: error: " + tc.wantMsg; issue.Error() != want {
+				t.Errorf("got %q, want %q", issue.Error(), want)
+			}
+		})
+	}
+}
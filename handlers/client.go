@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"crypto/tls"
 	"encoding/xml"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -9,6 +11,7 @@ import (
 	"dvrs.lib/RTSPClient/constant"
 	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	cmap "github.com/orcaman/concurrent-map/v2"
 )
 
@@ -20,8 +23,22 @@ type ClientKey struct {
 
 type Client struct {
 	ClientKey
-	client    *gortsplib.Client
-	rtspState constant.RTSPState
+	rtspClient *RTSPClient
+	client     *gortsplib.Client
+	rtspState  constant.RTSPState
+	errCode    constant.StatusCode
+	url        string
+	lastCRD    string
+	stats      *RTPStats
+	// Codec and SDP announced to the recorder, whose media the RTP packets
+	// are sent on
+	codec string
+	desc  *description.Session
+	// ED-137 version of the recorder when the session was created, which
+	// selects its SessionMachine
+	ed137Version string
+	// Config of the call, where the session is channel ch
+	cfg *Config
 }
 
 func (ck ClientKey) Hash() uint32 {
@@ -33,16 +50,57 @@ func (ck ClientKey) Hash() uint32 {
 }
 
 func (c Client) Lock() {
-	rtspClient := GetRTSPClient()
+	rtspClient := c.rtspClient
 	rtspClient.cs.listClient.OuterLock(c.ClientKey)
 }
 
 func (c Client) Unlock() {
-	rtspClient := GetRTSPClient()
+	rtspClient := c.rtspClient
 	rtspClient.cs.listClient.OuterUnLock(c.ClientKey)
 }
 
-func createClient(c *Client, mediaTransport string, keepAliveTime int, ed137Version string, interleave string) {
+// setRTSPState changes the state of the recorder session if its
+// SessionMachine allows it, and reports it, together with the error that
+// caused it if any, to the status handler and the transition observer. A
+// transition the machine rejects is logged and reported by request. A session
+// disconnected by an error is reported as lost.
+func (c *Client) setRTSPState(rtspState constant.RTSPState) {
+	if c.changeRTSPState(rtspState) && rtspState == constant.RTSP_STATE_DISCONNECT && c.errCode != constant.STATUS_OK {
+		c.rtspClient.reportLostSession(c.CallKey, c.client)
+	}
+	c.errCode = constant.STATUS_OK
+}
+
+// changeRTSPState is setRTSPState without the lost session report. It
+// returns false if the state did not change.
+func (c *Client) changeRTSPState(rtspState constant.RTSPState) bool {
+	if c.rtspState == rtspState && c.errCode == constant.STATUS_OK {
+		return false
+	}
+	request, err := c.request(rtspState)
+	if err != nil {
+		return false
+	}
+	from := c.rtspState
+	c.rtspState = rtspState
+	c.rtspClient.notifyStatus(StatusEvent{
+		CallKey:   c.CallKey,
+		Ch:        c.ch,
+		RTSPState: rtspState,
+		ErrCode:   c.errCode,
+	})
+	c.rtspClient.notifyTransition(TransitionEvent{
+		CallKey: c.CallKey,
+		Ch:      c.ch,
+		From:    from,
+		To:      rtspState,
+		Request: request,
+		ErrCode: c.errCode,
+	})
+	return true
+}
+
+func createClient(c *Client, mediaTransport string, keepAliveTime int, ed137Version string, interleave string, security recorderSecurity, tlsConfig *tls.Config) {
 	var wg67Version string
 	switch ed137Version {
 	case "ED137A":
@@ -77,109 +135,196 @@ func createClient(c *Client, mediaTransport string, keepAliveTime int, ed137Vers
 			UseInterleaved:  b_interleave,
 		}
 	}
+	// The URL keeps the hostname of the recorder, for its TLS certificate,
+	// while the resolver gives the address to dial
+	c.client.DialContext = c.rtspClient.resolver.dialContext
+	c.client.TLSConfig = tlsConfig
+	c.client.AuthMethods = security.authMethods()
 }
 
 func (c *Client) CloseByNormal(crd *CRD) {
-	rtspClient := GetRTSPClient()
-	if !crd.Disabled && rtspClient.ed137Versions[c.ch] == "ED137C" {
+	c.closeWithCRD(crd)
+}
+
+// closeWithCRD sends the last CRD before the TEARDOWN if the machine of the
+// session asks for it. The session is closed even if the CRD could not be
+// sent, with STATUS_ERR_SET_PARAMETER reported.
+func (c *Client) closeWithCRD(crd *CRD) {
+	rtspClient := c.rtspClient
+	request, err := c.request(constant.RTSP_STATE_DISCONNECT)
+	if err != nil {
+		return
+	}
+	if !crd.Disabled && request == constant.REQUEST_CRD_TEARDOWN {
 		crd.Properties.DisconnectCause.Value = strconv.Itoa(int(GetDisconnectCause(crd.Properties.SipDisconnectCause.Value, nil)))
 		crdByt, _ := xml.MarshalIndent(crd, "", "    ")
 		if _, err := c.client.SetParameter(nil, crdByt); err != nil {
-			rtspClient.LogDebug("name", c.Name, "recorderType:", "channel:", c.ch, "Error sening SetParameter request:", err)
-			return
+			// The session is closed all the same
+			rtspClient.LogError("Error sending SetParameter request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+			c.errCode = constant.STATUS_ERR_SET_PARAMETER
+		} else {
+			c.lastCRD = string(crdByt)
 		}
 	}
 	c.client.Close()
-	c.rtspState = constant.RTSP_STATE_DISCONNECT
+	// Closed on purpose, the session is not lost even if the last CRD failed
+	c.changeRTSPState(constant.RTSP_STATE_DISCONNECT)
+	c.errCode = constant.STATUS_OK
 }
 
-func (c *Client) Start(crd CRD) (*base.URL, error) {
-	if c.rtspState != constant.RTSP_STATE_START || c.client.IsClose() {
-		keepAliveTime, _ := strconv.Atoi(rtspClient.keepTimeAlives[c.ch])
-		createClient(c, rtspClient.mediaTransports[c.ch], keepAliveTime, rtspClient.ed137Versions[c.ch], rtspClient.interleaves[c.ch])
-	}
-	var u *base.URL
-	var err error
+// path returns the RTSP path of the session for the call of crd, from the
+// path template of its channel.
+func (c *Client) path(crd CRD) string {
+	name := strings.ToLower(c.Name)
+	var resource string
 	switch c.RecorderType {
 	case constant.RET_PHONE:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/" + strings.ToLower(c.Name))
+		resource = name
 	case constant.RET_BRIEF:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/" + strings.ToLower(c.Name) + "_brief")
+		resource = name + "_brief"
 	case constant.RET_AMBIENT:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/ambient")
+		resource = "ambient"
 	case constant.RET_PHONE_GROUP:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/phone")
+		resource = "phone"
 	case constant.RET_RADIO_GROUP:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/radio")
+		resource = "radio"
 	case constant.RET_BRIEF_GROUP:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/brief")
+		resource = "brief"
 	case constant.RET_RADIO_TX:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/" + strings.ToLower(c.Name) + "_ptt")
+		resource = name + "_ptt"
 	default:
-		u, err = base.ParseURL("rtsp://" + rtspClient.recAddrs[c.ch] + "/" + strings.ToLower(crd.VCSUser) + "/" + strings.ToLower(c.Name) + "_squ")
+		resource = name + "_squ"
 	}
-	if err != nil {
-		return nil, err
+	return expandPathTemplate(c.cfg.pathTemplates[c.ch], map[string]string{
+		"vcs_user":     strings.ToLower(crd.VCSUser),
+		"name":         name,
+		"type":         strings.ToLower(c.RecorderType.String()),
+		"resource":     resource,
+		"frequency_id": crd.frequencyID(),
+		"ch":           strconv.Itoa(c.ch),
+		"connref":      crd.Value,
+	})
+}
+
+func (c *Client) Start(crd CRD) (*base.URL, error) {
+	switch c.rtspState {
+	case constant.RTSP_STATE_NULL, constant.RTSP_STATE_START, constant.RTSP_STATE_DISCONNECT:
+	default:
+		if c.client.IsClose() {
+			// The session was lost
+			c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
+		}
 	}
+	if c.rtspState != constant.RTSP_STATE_START || c.client.IsClose() {
+		keepAliveTime, _ := strconv.Atoi(c.cfg.keepTimeAlives[c.ch])
+		createClient(c, c.cfg.mediaTransports[c.ch], keepAliveTime, c.cfg.ed137Versions[c.ch], c.cfg.interleaves[c.ch], c.cfg.securities[c.ch], c.cfg.tlsConfigs[c.ch])
+		c.ed137Version = c.cfg.ed137Versions[c.ch]
+	}
+	path := c.path(crd)
+	// recAddrs holds the hostname or the IP address, IPv6 bracketed, and
+	// the port
+	security := c.cfg.securities[c.ch]
+	u := &base.URL{
+		Scheme: security.scheme(),
+		Host:   c.cfg.recAddrs[c.ch],
+		Path:   path,
+	}
+	if security.username != "" {
+		u.User = url.UserPassword(security.username, security.password)
+	}
+	c.url = u.CloneWithoutCredentials().String()
 	if c.rtspState != constant.RTSP_STATE_START {
-		if err = c.client.Start(u.Scheme, u.Host); err != nil {
+		if _, err := c.request(constant.RTSP_STATE_START); err != nil {
+			return nil, err
+		}
+		if err := c.client.Start(u.Scheme, u.Host); err != nil {
+			c.errCode = constant.STATUS_ERR_START
 			return nil, err
 		}
+		c.setRTSPState(constant.RTSP_STATE_START)
 	}
 	return u, nil
 }
 
 func (c *Client) AnnounceSetup(u *base.URL) error {
-	if _, err := c.client.Announce(u, &rtspClient.desc); err != nil {
+	rtspClient := c.rtspClient
+	desc := c.cfg.descs[c.ch]
+	if _, err := c.request(constant.RTSP_STATE_ANNOUNCE); err != nil {
 		return err
 	}
-	if err := c.client.SetupAll(u, rtspClient.desc.Medias); err != nil {
+	if _, err := c.client.Announce(u, desc); err != nil {
+		c.errCode = constant.STATUS_ERR_ANNOUNCE_SETUP
 		return err
 	}
-	c.rtspState = constant.RTSP_STATE_SETUP
+	c.setRTSPState(constant.RTSP_STATE_ANNOUNCE)
+	if err := c.client.SetupAll(u, desc.Medias); err != nil {
+		c.errCode = constant.STATUS_ERR_ANNOUNCE_SETUP
+		return err
+	}
+	c.codec = c.cfg.codecs[c.ch]
+	c.desc = desc
+	c.setRTSPState(constant.RTSP_STATE_SETUP)
+	rtspClient.health.markUp(c.cfg.recAddrs[c.ch])
+	go c.watchSession()
 	return nil
 }
 
 func (c *Client) SetParameter(u *base.URL, crd CRD, crdByt []byte) error {
-	if !crd.Disabled && (c.RecorderType == constant.RET_PHONE || c.RecorderType == constant.RET_BRIEF || c.RecorderType == constant.RET_AMBIENT || rtspClient.ed137Versions[c.ch] == "ED137C") {
+	if !crd.Disabled && (c.RecorderType == constant.RET_PHONE || c.RecorderType == constant.RET_BRIEF || c.RecorderType == constant.RET_AMBIENT || c.cfg.ed137Versions[c.ch] == "ED137C") {
 		if _, err := c.client.SetParameter(u, crdByt); err != nil {
+			c.errCode = constant.STATUS_ERR_SET_PARAMETER
 			return err
 		}
+		c.lastCRD = string(crdByt)
 	}
 	return nil
 }
 
 func (c *Client) Record(crd CRD, crdByt []byte) error {
-	if c.RecorderType == constant.RET_PHONE && c.rtspState == constant.RTSP_STATE_PAUSE {
-		if _, err := c.client.SetParameter(nil, crdByt); err != nil {
-			return err
-		}
-	} else {
-		if c.rtspState == constant.RTSP_STATE_SETUP || c.rtspState == constant.RTSP_STATE_PAUSE {
-			if _, err := c.client.Record(crdByt); err != nil {
-				return err
-			}
-		}
+	request, err := c.request(constant.RTSP_STATE_RECORD)
+	if err != nil {
+		return err
+	}
+	if c.rtspState == constant.RTSP_STATE_RECORD && string(crdByt) == c.lastCRD {
+		// Recording already with this CRD
+		request = constant.REQUEST_NONE
+	}
+	switch request {
+	case constant.REQUEST_SET_PARAMETER:
+		_, err = c.client.SetParameter(nil, crdByt)
+	case constant.REQUEST_RECORD:
+		_, err = c.client.Record(crdByt)
+	}
+	if err != nil {
+		c.errCode = constant.STATUS_ERR_RECORD
+		return err
 	}
-	c.rtspState = constant.RTSP_STATE_RECORD
+	c.lastCRD = string(crdByt)
+	c.setRTSPState(constant.RTSP_STATE_RECORD)
 	return nil
 }
 
 func (c *Client) Pause(crd CRD, crdByt []byte) error {
-	if c.RecorderType == constant.RET_PHONE {
-		if _, err := c.client.SetParameter(nil, crdByt); err != nil {
-			return err
-		}
-	} else {
-		if _, err := c.client.Pause(crdByt); err != nil {
-			return err
-		}
+	request, err := c.request(constant.RTSP_STATE_PAUSE)
+	if err != nil {
+		return err
+	}
+	switch request {
+	case constant.REQUEST_SET_PARAMETER:
+		_, err = c.client.SetParameter(nil, crdByt)
+	case constant.REQUEST_PAUSE:
+		_, err = c.client.Pause(crdByt)
+	}
+	if err != nil {
+		c.errCode = constant.STATUS_ERR_PAUSE
+		return err
 	}
-	c.rtspState = constant.RTSP_STATE_PAUSE
+	c.lastCRD = string(crdByt)
+	c.setRTSPState(constant.RTSP_STATE_PAUSE)
 	return nil
 }
 
 func (c *Client) CloseByErr() {
 	c.client.Close()
-	c.rtspState = constant.RTSP_STATE_DISCONNECT
+	c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
 }
@@ -1,17 +1,18 @@
 package handlers
 
 import (
+	"crypto/tls"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"dvrs.lib/RTSPClient/constant"
 	"dvrs.lib/RTSPClient/utils"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
 )
 
-var saveCfg *Config
-
 type Config struct {
 	MaxCh           int
 	NumNonGroupCh   int
@@ -22,278 +23,344 @@ type Config struct {
 	interleaves     []string
 	ed137Versions   []string
 	recGroups       []bool
-	desc            description.Session
-	codec           string
+	codecs          []string
+	payloadTypes    []uint8
+	filters         []RecorderFilter
+	securities      []recorderSecurity
+	// Nil for the recorders without rtsps
+	tlsConfigs    []*tls.Config
+	pathTemplates []string
+	// Empty for the recorders outside of any failover group
+	failoverGroups []string
+	// Rank of the recorder in its failover group, 0 for the primary
+	failoverPriorities []int
+	sources            []recorderSource
+	// SDP announced to each recorder
+	descs []*description.Session
+	// Nil for the default timings of every recorder type
+	timings map[constant.RecorderType]Timings
+	// Zero for the release_timeout_ms option
+	releaseTimeoutMs int
 }
 
 func NewCfg() *Config {
-	cfg := &Config{}
-	cfg.desc = description.Session{
-		Medias: []*description.Media{{
-			Type: description.MediaTypeAudio,
-			Formats: []format.Format{&format.G711{
-				MULaw: false,
-			},
-			},
-		}},
-	}
-	cfg.codec = "g711alaw"
-	return cfg
+	return &Config{}
+}
+
+// legacyValue is the value of one key of a legacy config file.
+type legacyValue struct {
+	value string
+	line  int
 }
 
-func GetSaveCfg() *Config {
-	if saveCfg == nil {
-		saveCfg = NewCfg()
+// legacyKeyValues returns the values of keys in the order they appear in
+// data. A key is a whole word followed by '=' or ':'. Only the first key of
+// a line is read unless allKeys is set.
+func legacyKeyValues(lines []string, keys []string, allKeys bool) map[string][]legacyValue {
+	patterns := make([]*regexp.Regexp, len(keys))
+	for i, key := range keys {
+		patterns[i] = regexp.MustCompile(`\b` + key + `\b\s*[=:]\s*(.*)`)
 	}
-	return saveCfg
+	values := make(map[string][]legacyValue)
+	for i, line := range lines {
+		for j, pattern := range patterns {
+			matches := pattern.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+			values[keys[j]] = append(values[keys[j]], legacyValue{value: matches[1], line: i + 1})
+			if !allKeys {
+				break
+			}
+		}
+	}
+	return values
 }
 
-func (cfg *Config) LoadRecFileConfig(data []byte) {
-	var reTrue = regexp.MustCompile(`(true)|(false)`)
-	var reEnable = regexp.MustCompile(`(yes)|(no)|(enable)|(disable)|(true)|(false)`)
-	var reIP = regexp.MustCompile(`([0-9]+\.){3}[0-9]+`)
-	var rePort = regexp.MustCompile(`[0-9]+`)
-	var reTime = regexp.MustCompile(`[0-9]+`)
-	var reTransport = regexp.MustCompile(`(tcp)|(udp)`)
-	var reEd = regexp.MustCompile(`ED137[A-C]{1}`)
-	var reCodec = regexp.MustCompile(`g[0-9a-z]+`)
+// ParseLegacyRecFile reads the recorders of a legacy rec.cfg. The n-th value
+// of each key belongs to the n-th recorder, and a setting given once applies
+// to every recorder. The recorders with an error are left out.
+func ParseLegacyRecFile(data []byte, file string) (RecFile, CfgIssues) {
+	var reTrue = regexp.MustCompile(`^"?(true|false)\b`)
+	var reEnable = regexp.MustCompile(`^"?(yes|no|enable|disable|true|false)\b`)
+	var reHost = regexp.MustCompile(`^"?(\[[^\]]*\]|[^\s"';,}]+)`)
+	var reNumber = regexp.MustCompile(`^"?([0-9]+)\b`)
+	var reTransport = regexp.MustCompile(`^"?(tcp|udp)\b`)
+	var reEd = regexp.MustCompile(`^"?(ED137[A-C])\b`)
+	var reCodec = regexp.MustCompile(`^"?(g711alaw|g711ulaw|l16_16k|l16)\b`)
+	var reAuth = regexp.MustCompile(`^"?(any|digest|basic)\b`)
+	var reString = regexp.MustCompile(`^(?:"([^"]*)"|([^\s"';,}]+))`)
 
-	var wEnable = regexp.MustCompile(`Enable`)
-	var wIPAddress = regexp.MustCompile(`rec_ip`)
-	var wRemoteRTSPPort = regexp.MustCompile(`rec_port`)
-	var wMediaTransport = regexp.MustCompile(`media_transport`)
-	var wInterleaved = regexp.MustCompile(`interleaved`)
-	var wKeepAliveInterval = regexp.MustCompile(`keep_alive_interval`)
-	var wEd137Version = regexp.MustCompile(`ed137_version`)
-	var wCodec = regexp.MustCompile(`codec`)
-	var wRecGroup = regexp.MustCompile(`rec_group`)
+	keys := []string{"Enable", "rec_ip", "rec_port", "media_transport", "interleaved", "keep_alive_interval", "ed137_version", "codec", "rec_group",
+		"username", "password", "auth", "rtsps", "ca_file", "cert_file", "key_file", "path_template", "failover_group"}
+	// Keys whose absence is not reported
+	optionalKeys := map[string]bool{"Enable": true, "username": true, "password": true, "auth": true, "rtsps": true, "ca_file": true, "cert_file": true, "key_file": true, "path_template": true, "failover_group": true}
+	// Keys named otherwise in rec.json
+	jsonKeys := map[string]string{"Enable": "enable", "rec_ip": "address", "rec_port": "port"}
+	lines := strings.Split(utils.RemoveComments(string(data)), "\n")
+	values := legacyKeyValues(lines, keys, false)
+	recFile := RecFile{Codec: "g711alaw", Recorders: []RecorderCfg{}}
+	var issues CfgIssues
 
-	var enables, recIPs, recPorts, mediaTransports, keepTimeAlives, ed137Versions, interleaves, recGroups []string
-	var codec string
-	dataStr := utils.RemoveComments(string(data))
-	lines := strings.Split(dataStr, "\n")
-	for _, line := range lines {
-		if wEnable.MatchString(line) {
-			// If 'true' or 'false' is explicitly mentioned, use that.
-			// Otherwise, assume 'true' (enabled) by default.
-			matches := reTrue.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				enables = append(enables, matches[0])
-			} else {
-				enables = append(enables, "true")
-			}
-		} else if wIPAddress.MatchString(line) {
-			// Extract IP address. If not found, use default "127.0.0.1"
-			matches := reIP.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				recIPs = append(recIPs, matches[0])
-			} else {
-				recIPs = append(recIPs, "127.0.0.1")
-			}
-		} else if wRemoteRTSPPort.MatchString(line) {
-			// Extract port number. If not found, use default "8554"
-			matches := rePort.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				recPorts = append(recPorts, matches[0])
-			} else {
-				recPorts = append(recPorts, "8554")
-			}
-		} else if wMediaTransport.MatchString(line) {
-			// Extract media transport. If not found, use default "udp"
-			matches := reTransport.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				mediaTransports = append(mediaTransports, matches[0])
-			} else {
-				mediaTransports = append(mediaTransports, "udp")
-			}
-		} else if wInterleaved.MatchString(line) {
-			// Extract interleaved setting. If not found, use default "enable"
-			matches := reEnable.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				interleaves = append(interleaves, matches[0])
-			} else {
-				interleaves = append(interleaves, "enable")
+	if codecs := values["codec"]; len(codecs) != 0 {
+		codec := codecs[len(codecs)-1]
+		if matches := reCodec.FindStringSubmatch(codec.value); matches != nil {
+			recFile.Codec = matches[1]
+			recFile.codecSource = CfgSource{File: file, Line: codec.line}
+		} else {
+			issues = append(issues, CfgIssue{Severity: constant.CFG_WARNING, Type: constant.CFG_INVALID_VALUE, File: file, Line: codec.line, Recorder: -1, Path: "codec",
+				Msg: fmt.Sprintf("%q is not g711alaw, g711ulaw, l16 or l16_16k, using g711alaw", strings.TrimSpace(codec.value))})
+		}
+	}
+
+	numRecorders := 0
+	for _, key := range keys {
+		if key != "codec" && len(values[key]) > numRecorders {
+			numRecorders = len(values[key])
+		}
+	}
+	for j := 0; j < numRecorders; j++ {
+		recorderCfg := RecorderCfg{file: file, index: j, sources: make(map[string]CfgSource)}
+		var recIssues CfgIssues
+		for _, key := range keys {
+			if len(values[key]) > j {
+				recorderCfg.line = values[key][j].line
+				break
 			}
-		} else if wKeepAliveInterval.MatchString(line) {
-			// Extract keep-alive interval. If not found, use default "20"
-			matches := reTime.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				keepTimeAlives = append(keepTimeAlives, matches[0])
-			} else {
-				keepTimeAlives = append(keepTimeAlives, "20")
+		}
+		// get returns the value of key for this recorder, reporting it if it
+		// is missing or does not match re.
+		get := func(key string, re *regexp.Regexp, required bool, defaultValue string) string {
+			keyValues := values[key]
+			var keyValue legacyValue
+			switch {
+			case len(keyValues) > j:
+				keyValue = keyValues[j]
+			case len(keyValues) == 1 && !required:
+				keyValue = keyValues[0]
+			default:
+				if required {
+					recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_MISSING_KEY, File: file, Line: recorderCfg.line, Recorder: j, Path: key, Msg: "missing key"})
+				} else if !optionalKeys[key] {
+					recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_WARNING, Type: constant.CFG_MISSING_KEY, File: file, Line: recorderCfg.line, Recorder: j, Path: key, Msg: "missing key, using " + defaultValue})
+				}
+				return defaultValue
 			}
-		} else if wEd137Version.MatchString(line) {
-			// Extract ED137 version. If not found, use default "ED137B"
-			matches := reEd.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				ed137Versions = append(ed137Versions, matches[0])
-			} else {
-				ed137Versions = append(ed137Versions, "ED137B")
+			jsonKey := key
+			if renamed, ok := jsonKeys[key]; ok {
+				jsonKey = renamed
 			}
-		} else if wCodec.MatchString(line) {
-			// Extract codec. If not found, use default "g711alaw"
-			matches := reCodec.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				codec = matches[0]
-			} else {
-				codec = "g711alaw"
+			recorderCfg.sources[jsonKey] = CfgSource{File: file, Line: keyValue.line}
+			matches := re.FindStringSubmatch(strings.TrimSpace(keyValue.value))
+			if matches == nil {
+				recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: file, Line: keyValue.line, Recorder: j, Path: key,
+					Msg: fmt.Sprintf("invalid value %q", strings.TrimSpace(keyValue.value))})
+				return defaultValue
 			}
-		} else if wRecGroup.MatchString(line) {
-			// Extract recording group. If not found, use default "default"
-			matches := reTrue.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				recGroups = append(recGroups, matches[0])
-			} else {
-				recGroups = append(recGroups, "false")
+			// The first group matched, reString has one for quoted values
+			for _, match := range matches[1:] {
+				if match != "" {
+					return match
+				}
 			}
+			return ""
+		}
+		recorderCfg.Enable = get("Enable", reTrue, false, "true") == "true"
+		recorderCfg.Address = get("rec_ip", reHost, true, "")
+		recorderCfg.Port, _ = strconv.Atoi(get("rec_port", reNumber, true, "0"))
+		recorderCfg.MediaTransport = get("media_transport", reTransport, false, "udp")
+		switch get("interleaved", reEnable, false, "enable") {
+		case "yes", "enable", "true":
+			recorderCfg.Interleaved = true
+		}
+		recorderCfg.KeepAliveInterval, _ = strconv.Atoi(get("keep_alive_interval", reNumber, false, "20"))
+		recorderCfg.Ed137Version = get("ed137_version", reEd, false, "ED137B")
+		recorderCfg.RecGroup = get("rec_group", reTrue, false, "false") == "true"
+		recorderCfg.Username = get("username", reString, false, "")
+		recorderCfg.Password = get("password", reString, false, "")
+		recorderCfg.Auth = get("auth", reAuth, false, "any")
+		recorderCfg.Rtsps = get("rtsps", reTrue, false, "false") == "true"
+		recorderCfg.CAFile = get("ca_file", reString, false, "")
+		recorderCfg.CertFile = get("cert_file", reString, false, "")
+		recorderCfg.KeyFile = get("key_file", reString, false, "")
+		recorderCfg.PathTemplate = get("path_template", reString, false, defaultPathTemplate)
+		recorderCfg.FailoverGroup = get("failover_group", reString, false, "")
+		if len(recIssues.Errors()) == 0 {
+			recIssues = append(recIssues, recorderCfg.check("rec_ip", "rec_port", "keep_alive_interval")...)
+			recIssues = append(recIssues, recorderCfg.checkSecurity("")...)
+			recIssues = append(recIssues, recorderCfg.checkPathTemplate("path_template")...)
+			recorderCfg.Address, _ = utils.ParseHost(recorderCfg.Address)
+		}
+		issues = append(issues, recIssues...)
+		if len(recIssues.Errors()) == 0 {
+			recFile.Recorders = append(recFile.Recorders, recorderCfg)
 		}
-		// Ignore lines that don't match any of the patterns
 	}
+	return recFile, issues
+}
 
-	if enables == nil {
-		// If 'Enable' is not specified, assume all channels are enabled
-		MaxCh := len(recIPs)
-		for j := 0; j < MaxCh; j++ {
-			cfg.recAddrs = append(cfg.recAddrs, recIPs[j]+":"+recPorts[j])
-			cfg.mediaTransports = append(cfg.mediaTransports, mediaTransports...)
-			cfg.keepTimeAlives = append(cfg.keepTimeAlives, keepTimeAlives...)
-			cfg.ed137Versions = append(cfg.ed137Versions, ed137Versions...)
-			cfg.interleaves = append(cfg.interleaves, interleaves...)
-			cfg.MaxCh++
-			if recGroups[j] == "true" {
-				cfg.recGroups = append(cfg.recGroups, true)
-				cfg.NumGroupCh++
-			} else {
-				cfg.recGroups = append(cfg.recGroups, false)
-				cfg.NumNonGroupCh++
-			}
+// ParseLegacyDevSysFile reads the recorders of the tmcs_server section of a
+// device_system.cfg. The recorders with an error are left out.
+func ParseLegacyDevSysFile(data []byte, file string) ([]RecorderCfg, CfgIssues) {
+	lines := strings.Split(utils.RemoveComments(string(data)), "\n")
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		// The section starts at "tmcs_server" and ends at the first ")"
+		if start < 0 && strings.Contains(line, "tmcs_server") {
+			start = i + 1
+		} else if start >= 0 && strings.Contains(line, ")") {
+			end = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, nil
+	}
+	section := make([]string, len(lines))
+	copy(section[start:end], lines[start:end])
+	// The ")" closing the section may follow the last value on its line
+	section[end-1] = strings.SplitN(section[end-1], ")", 2)[0]
+	values := legacyKeyValues(section, []string{"ip_address", "port"}, true)
 
+	var recorders []RecorderCfg
+	var issues CfgIssues
+	numRecorders := len(values["ip_address"])
+	if len(values["port"]) > numRecorders {
+		numRecorders = len(values["port"])
+	}
+	for j := 0; j < numRecorders; j++ {
+		recorderCfg := RecorderCfg{
+			Enable:            true,
+			MediaTransport:    "udp",
+			KeepAliveInterval: 10,
+			Ed137Version:      "ED137B",
+			Auth:              "any",
+			PathTemplate:      defaultPathTemplate,
+			file:              file,
+			index:             j,
+			sources:           make(map[string]CfgSource),
 		}
-	} else {
-		// If 'Enable' is specified, only process channels where it's 'true'
-		for j, v := range enables {
-			if v == "true" {
-				cfg.recAddrs = append(cfg.recAddrs, recIPs[j]+":"+recPorts[j])
-				cfg.mediaTransports = append(cfg.mediaTransports, mediaTransports[j])
-				cfg.keepTimeAlives = append(cfg.keepTimeAlives, keepTimeAlives[j])
-				cfg.ed137Versions = append(cfg.ed137Versions, ed137Versions[j])
-				cfg.interleaves = append(cfg.interleaves, interleaves[j])
-				cfg.MaxCh++
-				if recGroups[j] == "true" {
-					cfg.recGroups = append(cfg.recGroups, true)
-					cfg.NumGroupCh++
-				} else {
-					cfg.recGroups = append(cfg.recGroups, false)
-					cfg.NumNonGroupCh++
-				}
+		var recIssues CfgIssues
+		if len(values["ip_address"]) > j {
+			recorderCfg.line = values["ip_address"][j].line
+			recorderCfg.sources["address"] = CfgSource{File: file, Line: recorderCfg.line}
+			recorderCfg.Address = utils.ExtractHost(values["ip_address"][j].value)
+			if recorderCfg.Address == "" {
+				recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: file, Line: recorderCfg.line, Recorder: j, Path: "ip_address",
+					Msg: fmt.Sprintf("invalid value %q", strings.TrimSpace(values["ip_address"][j].value))})
+			}
+		} else {
+			recorderCfg.line = values["port"][j].line
+			recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_MISSING_KEY, File: file, Line: recorderCfg.line, Recorder: j, Path: "ip_address", Msg: "missing key"})
+		}
+		if len(values["port"]) > j {
+			port := utils.ExtractPort(values["port"][j].value)
+			if port == "" {
+				recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: file, Line: values["port"][j].line, Recorder: j, Path: "port",
+					Msg: fmt.Sprintf("invalid value %q", strings.TrimSpace(values["port"][j].value))})
 			}
+			recorderCfg.Port, _ = strconv.Atoi(port)
+			recorderCfg.sources["port"] = CfgSource{File: file, Line: values["port"][j].line}
+		} else {
+			recIssues = append(recIssues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_MISSING_KEY, File: file, Line: recorderCfg.line, Recorder: j, Path: "port", Msg: "missing key"})
+		}
+		issues = append(issues, recIssues...)
+		if len(recIssues.Errors()) == 0 {
+			recorders = append(recorders, recorderCfg)
 		}
 	}
-	cfg.codec = codec
+	return recorders, issues
+}
+
+// defaultPayloadType returns the payload type used for codec when none is
+// configured: the static one of G.711, a dynamic one for L16.
+func defaultPayloadType(codec string) uint8 {
 	switch codec {
-	case "g711alaw":
-		cfg.desc = description.Session{
-			Medias: []*description.Media{{
-				Type: description.MediaTypeAudio,
-				Formats: []format.Format{&format.G711{
-					MULaw: false,
-				},
-				},
-			}},
-		}
 	case "g711ulaw":
-		cfg.desc = description.Session{
-			Medias: []*description.Media{{
-				Type: description.MediaTypeAudio,
-				Formats: []format.Format{&format.G711{
-					MULaw: true,
-				},
-				},
-			}},
-		}
+		return 0
+	case "g711alaw":
+		return 8
 	default:
+		return 96
 	}
 }
 
-func (cfg *Config) LoadDevSysFileConfig(data []byte) {
-
-	listIp := []string{}
-	listPort := []string{}
-
-	parsingTMCSServer := false
-
-	dataStr := utils.RemoveComments(string(data))
-
-	// Split the data into lines
-	lines := strings.Split(dataStr, "\n")
-
-	for _, line := range lines {
-		// Skip empty lines
-		if line == "" {
-			continue
-		}
-
-		// Check for section start (assuming "tmcs_server {")
-		if strings.Contains(line, "tmcs_server") {
-			parsingTMCSServer = true
-			continue
-		}
+// validPayloadType tells whether payloadType can carry codec: its static
+// payload type or a dynamic one.
+func validPayloadType(codec string, payloadType int) bool {
+	return payloadType == int(defaultPayloadType(codec)) || payloadType >= 96 && payloadType <= 127
+}
 
-		if strings.Contains(line, ")") {
-			if parsingTMCSServer {
-				break
-			} else {
-				continue
+// newDesc returns the SDP announcing codec with payloadType.
+func newDesc(codec string, payloadType uint8) *description.Session {
+	var forma format.Format
+	switch codec {
+	case "g711alaw", "g711ulaw":
+		if payloadType == defaultPayloadType(codec) {
+			forma = &format.G711{
+				MULaw: codec == "g711ulaw",
+			}
+		} else {
+			rtpMap := "PCMA/8000"
+			if codec == "g711ulaw" {
+				rtpMap = "PCMU/8000"
+			}
+			forma = &format.Generic{
+				PayloadTyp: payloadType,
+				RTPMa:      rtpMap,
+				ClockRat:   8000,
 			}
 		}
-
-		if strings.Contains(line, "ip_address") {
-			ip := utils.ExtractIpAddr(line)
-			listIp = append(listIp, ip)
+	case "l16_16k":
+		forma = &format.LPCM{
+			PayloadTyp:   payloadType,
+			BitDepth:     16,
+			SampleRate:   16000,
+			ChannelCount: 1,
 		}
-
-		if strings.Contains(line, "port") {
-			port := utils.ExtractPort(line)
-			listPort = append(listPort, port)
+	default:
+		forma = &format.LPCM{
+			PayloadTyp:   payloadType,
+			BitDepth:     16,
+			SampleRate:   8000,
+			ChannelCount: 1,
 		}
 	}
-
-	if len(listIp) != len(listPort) {
-		return
-	}
-	for i := range listIp {
-		ip := listIp[i]
-		port := listPort[i]
-		if ip == "" || port == "" {
-			continue
-		}
-		cfg.MaxCh++
-		cfg.recAddrs = append(cfg.recAddrs, ip+":"+port)
-		cfg.mediaTransports = append(cfg.mediaTransports, "udp")
-		cfg.ed137Versions = append(cfg.ed137Versions, "ED137B")
-		cfg.interleaves = append(cfg.interleaves, "disable")
-		cfg.keepTimeAlives = append(cfg.keepTimeAlives, "10")
-		cfg.recGroups = append(cfg.recGroups, false)
-		cfg.NumNonGroupCh++
+	return &description.Session{
+		Medias: []*description.Media{{
+			Type:    description.MediaTypeAudio,
+			Formats: []format.Format{forma},
+		}},
 	}
 }
 
 func (cfg *Config) Copy() *Config {
 	return &Config{
-		MaxCh:           cfg.MaxCh,
-		recAddrs:        append([]string{}, cfg.recAddrs...),
-		mediaTransports: append([]string{}, cfg.mediaTransports...),
-		keepTimeAlives:  append([]string{}, cfg.keepTimeAlives...),
-		interleaves:     append([]string{}, cfg.interleaves...),
-		ed137Versions:   append([]string{}, cfg.ed137Versions...),
-		recGroups:       append([]bool{}, cfg.recGroups...),
-		NumGroupCh:      cfg.NumGroupCh,
-		NumNonGroupCh:   cfg.NumNonGroupCh,
-		desc:            cfg.desc,
-		codec:           cfg.codec,
+		MaxCh:              cfg.MaxCh,
+		recAddrs:           append([]string{}, cfg.recAddrs...),
+		mediaTransports:    append([]string{}, cfg.mediaTransports...),
+		keepTimeAlives:     append([]string{}, cfg.keepTimeAlives...),
+		interleaves:        append([]string{}, cfg.interleaves...),
+		ed137Versions:      append([]string{}, cfg.ed137Versions...),
+		recGroups:          append([]bool{}, cfg.recGroups...),
+		codecs:             append([]string{}, cfg.codecs...),
+		payloadTypes:       append([]uint8{}, cfg.payloadTypes...),
+		filters:            append([]RecorderFilter{}, cfg.filters...),
+		securities:         append([]recorderSecurity{}, cfg.securities...),
+		tlsConfigs:         append([]*tls.Config{}, cfg.tlsConfigs...),
+		pathTemplates:      append([]string{}, cfg.pathTemplates...),
+		failoverGroups:     append([]string{}, cfg.failoverGroups...),
+		failoverPriorities: append([]int{}, cfg.failoverPriorities...),
+		sources:            append([]recorderSource{}, cfg.sources...),
+		descs:              append([]*description.Session{}, cfg.descs...),
+		NumGroupCh:         cfg.NumGroupCh,
+		NumNonGroupCh:      cfg.NumNonGroupCh,
+		timings:            cfg.timings,
+		releaseTimeoutMs:   cfg.releaseTimeoutMs,
 	}
 }
 
+// Reset removes every channel. The timings are kept.
 func (cfg *Config) Reset() {
 	cfg.MaxCh = 0
 	cfg.recAddrs = []string{}
@@ -302,6 +369,16 @@ func (cfg *Config) Reset() {
 	cfg.interleaves = []string{}
 	cfg.ed137Versions = []string{}
 	cfg.recGroups = []bool{}
+	cfg.codecs = []string{}
+	cfg.payloadTypes = []uint8{}
+	cfg.filters = []RecorderFilter{}
+	cfg.securities = []recorderSecurity{}
+	cfg.tlsConfigs = []*tls.Config{}
+	cfg.pathTemplates = []string{}
+	cfg.failoverGroups = []string{}
+	cfg.failoverPriorities = []int{}
+	cfg.sources = []recorderSource{}
+	cfg.descs = []*description.Session{}
 	cfg.NumGroupCh = 0
 	cfg.NumNonGroupCh = 0
 }
@@ -311,65 +388,93 @@ func (cfg *Config) CheckDupConfig() {
 		return
 	}
 	type SubConfig struct {
-		recAddr        string
-		mediaTransport string
-		keepTimeAlive  string
-		interleave     string
-		ed137Version   string
-		recGroup       bool
+		recAddr          string
+		mediaTransport   string
+		keepTimeAlive    string
+		interleave       string
+		ed137Version     string
+		recGroup         bool
+		codec            string
+		payloadType      uint8
+		filter           RecorderFilter
+		security         recorderSecurity
+		tlsConfig        *tls.Config
+		pathTemplate     string
+		failoverGroup    string
+		failoverPriority int
+		source           recorderSource
+		desc             *description.Session
 	}
 	dupCfgAttrs := make(map[string][]SubConfig)
 	for i, addr := range cfg.recAddrs {
 		subCfgAttrs := dupCfgAttrs[addr]
 		subCfgAttrs = append(subCfgAttrs, SubConfig{
-			recAddr:        addr,
-			mediaTransport: cfg.mediaTransports[i],
-			keepTimeAlive:  cfg.keepTimeAlives[i],
-			interleave:     cfg.interleaves[i],
-			ed137Version:   cfg.ed137Versions[i],
-			recGroup:       cfg.recGroups[i],
+			recAddr:          addr,
+			mediaTransport:   cfg.mediaTransports[i],
+			keepTimeAlive:    cfg.keepTimeAlives[i],
+			interleave:       cfg.interleaves[i],
+			ed137Version:     cfg.ed137Versions[i],
+			recGroup:         cfg.recGroups[i],
+			codec:            cfg.codecs[i],
+			payloadType:      cfg.payloadTypes[i],
+			filter:           cfg.filters[i],
+			security:         cfg.securities[i],
+			tlsConfig:        cfg.tlsConfigs[i],
+			pathTemplate:     cfg.pathTemplates[i],
+			failoverGroup:    cfg.failoverGroups[i],
+			failoverPriority: cfg.failoverPriorities[i],
+			source:           cfg.sources[i],
+			desc:             cfg.descs[i],
 		})
 		dupCfgAttrs[addr] = subCfgAttrs
 	}
+	addrs := cfg.recAddrs
 	cfg.Reset()
-	for _, v := range dupCfgAttrs {
-		if len(v) != 0 {
-			added := false
-			for _, attr := range v {
-				if attr.mediaTransport == "udp" {
-					cfg.recAddrs = append(cfg.recAddrs, attr.recAddr)
-					cfg.mediaTransports = append(cfg.mediaTransports, attr.mediaTransport)
-					cfg.keepTimeAlives = append(cfg.keepTimeAlives, attr.keepTimeAlive)
-					cfg.interleaves = append(cfg.interleaves, attr.interleave)
-					cfg.ed137Versions = append(cfg.ed137Versions, attr.ed137Version)
-					cfg.MaxCh++
-					if attr.recGroup {
-						cfg.recGroups = append(cfg.recGroups, true)
-						cfg.NumGroupCh++
-					} else {
-						cfg.recGroups = append(cfg.recGroups, false)
-						cfg.NumNonGroupCh++
-					}
-					added = true
-					break
-				}
-			}
-			if !added {
-				cfg.recAddrs = append(cfg.recAddrs, v[0].recAddr)
-				cfg.mediaTransports = append(cfg.mediaTransports, v[0].mediaTransport)
-				cfg.keepTimeAlives = append(cfg.keepTimeAlives, v[0].keepTimeAlive)
-				cfg.interleaves = append(cfg.interleaves, v[0].interleave)
-				cfg.ed137Versions = append(cfg.ed137Versions, v[0].ed137Version)
-				cfg.MaxCh++
-				if v[0].recGroup {
-					cfg.recGroups = append(cfg.recGroups, true)
-					cfg.NumGroupCh++
-				} else {
-					cfg.recGroups = append(cfg.recGroups, false)
-					cfg.NumNonGroupCh++
-				}
+	addCh := func(attr SubConfig) {
+		cfg.recAddrs = append(cfg.recAddrs, attr.recAddr)
+		cfg.mediaTransports = append(cfg.mediaTransports, attr.mediaTransport)
+		cfg.keepTimeAlives = append(cfg.keepTimeAlives, attr.keepTimeAlive)
+		cfg.interleaves = append(cfg.interleaves, attr.interleave)
+		cfg.ed137Versions = append(cfg.ed137Versions, attr.ed137Version)
+		cfg.codecs = append(cfg.codecs, attr.codec)
+		cfg.payloadTypes = append(cfg.payloadTypes, attr.payloadType)
+		cfg.filters = append(cfg.filters, attr.filter)
+		cfg.securities = append(cfg.securities, attr.security)
+		cfg.tlsConfigs = append(cfg.tlsConfigs, attr.tlsConfig)
+		cfg.pathTemplates = append(cfg.pathTemplates, attr.pathTemplate)
+		cfg.failoverGroups = append(cfg.failoverGroups, attr.failoverGroup)
+		cfg.failoverPriorities = append(cfg.failoverPriorities, attr.failoverPriority)
+		cfg.sources = append(cfg.sources, attr.source)
+		cfg.descs = append(cfg.descs, attr.desc)
+		cfg.MaxCh++
+		if attr.recGroup {
+			cfg.recGroups = append(cfg.recGroups, true)
+			cfg.NumGroupCh++
+		} else {
+			cfg.recGroups = append(cfg.recGroups, false)
+			cfg.NumNonGroupCh++
+		}
+	}
+	// The channels keep the order of the files, an address taking the place
+	// of its first entry
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		v := dupCfgAttrs[addr]
+		added := false
+		for _, attr := range v {
+			if attr.mediaTransport == "udp" {
+				addCh(attr)
+				added = true
+				break
 			}
 		}
+		if !added {
+			addCh(v[0])
+		}
 	}
 }
 
@@ -384,6 +489,13 @@ func (cfg *Config) String() string {
 		str += "  Interleave: " + cfg.interleaves[i] + "\n"
 		str += "  ED137 Version: " + cfg.ed137Versions[i] + "\n"
 		str += "  Recorder Group: " + strconv.FormatBool(cfg.recGroups[i]) + "\n"
+		str += "  Codec: " + cfg.codecs[i] + " (payload type " + strconv.Itoa(int(cfg.payloadTypes[i])) + ")\n"
+		str += "  Filter: " + cfg.filters[i].String() + "\n"
+		str += "  Security: " + cfg.securities[i].String() + "\n"
+		str += "  Path Template: " + cfg.pathTemplates[i] + "\n"
+		if cfg.failoverGroups[i] != "" {
+			str += "  Failover Group: " + cfg.failoverGroups[i] + " (rank " + strconv.Itoa(cfg.failoverPriorities[i]) + ")\n"
+		}
 	}
 	str += "Number of Group Channels: " + strconv.Itoa(cfg.NumGroupCh) + "\n"
 	str += "Number of Non-Group Channels: " + strconv.Itoa(cfg.NumNonGroupCh) + "\n"
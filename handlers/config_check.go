@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// CfgIssue is a problem found in a config file. An error rejects the recorder
+// entry it is found in, or the whole file if Recorder is -1.
+type CfgIssue struct {
+	Severity constant.CfgSeverity  `json:"severity"`
+	Type     constant.CfgIssueType `json:"type"`
+	File     string                `json:"file,omitempty"`
+	Line     int                   `json:"line,omitempty"`
+	// Index of the recorder entry in its file, -1 for the whole file
+	Recorder int `json:"recorder"`
+	// Key of the value, e.g. rec_port or recorders[1].port
+	Path string `json:"path,omitempty"`
+	Msg  string `json:"msg"`
+}
+
+func (issue CfgIssue) Error() string {
+	str := ""
+	if issue.File != "" {
+		str += issue.File + ":"
+	}
+	if issue.Line != 0 {
+		str += strconv.Itoa(issue.Line) + ":"
+	}
+	if str != "" {
+		str += " "
+	}
+	str += issue.Severity.String() + ": "
+	if issue.Recorder >= 0 && !strings.HasPrefix(issue.Path, "recorders[") {
+		str += "recorder " + strconv.Itoa(issue.Recorder) + ": "
+	}
+	if issue.Path != "" {
+		str += issue.Path + ": "
+	}
+	return str + issue.Msg
+}
+
+// CfgIssues lists the issues found in the config files.
+type CfgIssues []CfgIssue
+
+func (issues CfgIssues) Error() string {
+	msgs := make([]string, len(issues))
+	for i, issue := range issues {
+		msgs[i] = issue.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (issues CfgIssues) filter(severity constant.CfgSeverity) CfgIssues {
+	var filtered CfgIssues
+	for _, issue := range issues {
+		if issue.Severity == severity {
+			filtered = append(filtered, issue)
+		}
+	}
+	return filtered
+}
+
+func (issues CfgIssues) Errors() CfgIssues {
+	return issues.filter(constant.CFG_ERROR)
+}
+
+func (issues CfgIssues) Warnings() CfgIssues {
+	return issues.filter(constant.CFG_WARNING)
+}
+
+// CfgReport is the result of loading the config files.
+type CfgReport struct {
+	// Files the recorders were read from
+	Files []string `json:"files"`
+	// False if the config was rejected and the previous one kept
+	Applied bool `json:"applied"`
+	// Number of recorder channels of the config applied
+	Channels int       `json:"channels"`
+	Issues   CfgIssues `json:"issues"`
+}
+
+// check reports the values of recorderCfg that the syntax of its file does not
+// rule out. The keys are named as in the file.
+func (recorderCfg RecorderCfg) check(addressKey string, portKey string, keepAliveKey string) CfgIssues {
+	var issues CfgIssues
+	invalid := func(key string, format string, args ...interface{}) {
+		issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: recorderCfg.file, Line: recorderCfg.line, Recorder: recorderCfg.index, Path: key, Msg: fmt.Sprintf(format, args...)})
+	}
+	if _, ok := utils.ParseHost(recorderCfg.Address); !ok {
+		invalid(addressKey, "%q is not a hostname, an IP address or a bracketed IPv6 address", recorderCfg.Address)
+	}
+	if recorderCfg.Port <= 0 || recorderCfg.Port > 65535 {
+		invalid(portKey, "port %d is not between 1 and 65535", recorderCfg.Port)
+	}
+	if recorderCfg.KeepAliveInterval <= 0 {
+		invalid(keepAliveKey, "must be at least 1")
+	}
+	return issues
+}
+
+func (recorderCfg RecorderCfg) checkPathTemplate(key string) CfgIssues {
+	if recorderCfg.PathTemplate == "" {
+		return nil
+	}
+	if err := checkPathTemplate(recorderCfg.PathTemplate); err != nil {
+		return CfgIssues{{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: recorderCfg.file, Line: recorderCfg.line, Recorder: recorderCfg.index, Path: key,
+			Msg: fmt.Sprintf("%q: %v", recorderCfg.PathTemplate, err)}}
+	}
+	return nil
+}
+
+// checkDupAddrs warns about the enabled recorders sharing an address. Only
+// one of them is used, see CheckDupConfig.
+func checkDupAddrs(recorders []RecorderCfg) CfgIssues {
+	var issues CfgIssues
+	first := make(map[string]RecorderCfg)
+	for _, recorderCfg := range recorders {
+		if !recorderCfg.Enable {
+			continue
+		}
+		addr := net.JoinHostPort(recorderCfg.Address, strconv.Itoa(recorderCfg.Port))
+		firstCfg, ok := first[addr]
+		if !ok {
+			first[addr] = recorderCfg
+			continue
+		}
+		issues = append(issues, CfgIssue{
+			Severity: constant.CFG_WARNING,
+			Type:     constant.CFG_DUPLICATE_ADDRESS,
+			File:     recorderCfg.file,
+			Line:     recorderCfg.line,
+			Recorder: recorderCfg.index,
+			Msg:      fmt.Sprintf("%s is also used at %s:%d, only one of them is used", addr, firstCfg.file, firstCfg.line),
+		})
+	}
+	return issues
+}
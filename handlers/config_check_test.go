@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestRecorderCfgCheck(t *testing.T) {
+	valid := RecorderCfg{Address: "10.0.0.1", Port: 554, KeepAliveInterval: 20, file: "rec.cfg", line: 3, index: 1}
+	for _, tc := range []struct {
+		name   string
+		change func(recorderCfg *RecorderCfg)
+		want   []string
+	}{
+		{"valid", func(recorderCfg *RecorderCfg) {}, nil},
+		{"hostname", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "rec-1.example.org" }, nil},
+		{"IPv6 address", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "fd00::1" }, nil},
+		{"bracketed IPv6 address", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "[fd00::1]" }, nil},
+		{"empty address", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "" }, []string{"rec_ip"}},
+		{"address with a port", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "10.0.0.1:554" }, []string{"rec_ip"}},
+		{"address with a space", func(recorderCfg *RecorderCfg) { recorderCfg.Address = "rec 1" }, []string{"rec_ip"}},
+		{"port zero", func(recorderCfg *RecorderCfg) { recorderCfg.Port = 0 }, []string{"rec_port"}},
+		{"negative port", func(recorderCfg *RecorderCfg) { recorderCfg.Port = -1 }, []string{"rec_port"}},
+		{"last port", func(recorderCfg *RecorderCfg) { recorderCfg.Port = 65535 }, nil},
+		{"port past the last", func(recorderCfg *RecorderCfg) { recorderCfg.Port = 65536 }, []string{"rec_port"}},
+		{"keep-alive zero", func(recorderCfg *RecorderCfg) { recorderCfg.KeepAliveInterval = 0 }, []string{"keep_alive"}},
+		{"every value invalid", func(recorderCfg *RecorderCfg) {
+			recorderCfg.Address = ""
+			recorderCfg.Port = 0
+			recorderCfg.KeepAliveInterval = -5
+		}, []string{"rec_ip", "rec_port", "keep_alive"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorderCfg := valid
+			tc.change(&recorderCfg)
+			var got []string
+			for _, issue := range recorderCfg.check("rec_ip", "rec_port", "keep_alive") {
+				if issue.Severity != constant.CFG_ERROR || issue.Type != constant.CFG_INVALID_VALUE {
+					t.Errorf("issue %v is not an invalid value error", issue)
+				}
+				if issue.File != "rec.cfg" || issue.Line != 3 || issue.Recorder != 1 {
+					t.Errorf("issue %v does not point at the recorder", issue)
+				}
+				got = append(got, issue.Path)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got issues on %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckDupAddrs(t *testing.T) {
+	recorder := func(address string, port int, enable bool, line int) RecorderCfg {
+		return RecorderCfg{Enable: enable, Address: address, Port: port, file: "rec.json", line: line, index: line - 1}
+	}
+	for _, tc := range []struct {
+		name      string
+		recorders []RecorderCfg
+		// Lines of the recorders warned about
+		want []int
+	}{
+		{"none", nil, nil},
+		{"distinct addresses", []RecorderCfg{recorder("10.0.0.1", 554, true, 1), recorder("10.0.0.2", 554, true, 2)}, nil},
+		{"same address other port", []RecorderCfg{recorder("10.0.0.1", 554, true, 1), recorder("10.0.0.1", 8554, true, 2)}, nil},
+		{"same address and port", []RecorderCfg{recorder("10.0.0.1", 554, true, 1), recorder("10.0.0.1", 554, true, 2)}, []int{2}},
+		{"three times", []RecorderCfg{recorder("10.0.0.1", 554, true, 1), recorder("10.0.0.1", 554, true, 2), recorder("10.0.0.1", 554, true, 3)}, []int{2, 3}},
+		{"disabled first", []RecorderCfg{recorder("10.0.0.1", 554, false, 1), recorder("10.0.0.1", 554, true, 2)}, nil},
+		{"disabled duplicate", []RecorderCfg{recorder("10.0.0.1", 554, true, 1), recorder("10.0.0.1", 554, false, 2)}, nil},
+		{"IPv6", []RecorderCfg{recorder("fd00::1", 554, true, 1), recorder("fd00::1", 554, true, 2)}, []int{2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []int
+			for _, issue := range checkDupAddrs(tc.recorders) {
+				if issue.Severity != constant.CFG_WARNING || issue.Type != constant.CFG_DUPLICATE_ADDRESS {
+					t.Errorf("issue %v is not a duplicate address warning", issue)
+				}
+				if issue.Recorder != issue.Line-1 {
+					t.Errorf("issue %v does not point at the recorder", issue)
+				}
+				got = append(got, issue.Line)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got warnings on lines %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCfgIssueError(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		issue CfgIssue
+		want  string
+	}{
+		{"whole file", CfgIssue{Severity: constant.CFG_ERROR, File: "rec.json", Line: 4, Recorder: -1, Msg: "bad"}, "rec.json:4: error: bad"},
+		{"no file", CfgIssue{Severity: constant.CFG_WARNING, Recorder: -1, Msg: "bad"}, "warning: bad"},
+		{"recorder key", CfgIssue{Severity: constant.CFG_ERROR, File: "rec.cfg", Line: 2, Recorder: 1, Path: "rec_port", Msg: "bad"}, "rec.cfg:2: error: recorder 1: rec_port: bad"},
+		{"recorder path", CfgIssue{Severity: constant.CFG_ERROR, File: "rec.json", Line: 7, Recorder: 1, Path: "recorders[1].port", Msg: "bad"}, "rec.json:7: error: recorders[1].port: bad"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.issue.Error(); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckDupConfigKeepsFileOrder(t *testing.T) {
+	recJSON := `{"recorders": [
+		{"address": "10.0.0.3", "media_transport": "tcp"},
+		{"address": "10.0.0.1"},
+		{"address": "10.0.0.3"},
+		{"address": "10.0.0.2"},
+		{"address": "10.0.0.1", "port": 554}
+	]}`
+	want := []string{"10.0.0.3:8554", "10.0.0.1:8554", "10.0.0.2:8554", "10.0.0.1:554"}
+	for i := 0; i < 20; i++ {
+		cfg := testCfg(t, recJSON)
+		if !reflect.DeepEqual(cfg.recAddrs, want) {
+			t.Fatalf("load %d: got channels %v, want %v", i, cfg.recAddrs, want)
+		}
+		// The udp entry of the duplicate address is kept
+		if cfg.mediaTransports[0] != "udp" {
+			t.Fatalf("load %d: got %s transport for %s, want udp", i, cfg.mediaTransports[0], cfg.recAddrs[0])
+		}
+	}
+}
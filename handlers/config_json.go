@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// recSchemaJSON is the JSON schema of rec.json. Only the keywords it uses are
+// supported: type, properties, required, additionalProperties, items, enum,
+// minimum, maximum and default.
+//
+//go:embed rec_schema.json
+var recSchemaJSON []byte
+
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Default              interface{}            `json:"default"`
+}
+
+var recSchema = func() *jsonSchema {
+	schema := &jsonSchema{}
+	if err := json.Unmarshal(recSchemaJSON, schema); err != nil {
+		panic("invalid rec_schema.json: " + err.Error())
+	}
+	return schema
+}()
+
+// RecFile is the structured recorder config, one block per recorder.
+type RecFile struct {
+	Codec string `json:"codec"`
+	// Nil for the default timings
+	Timings   *TimingsCfg   `json:"timings,omitempty"`
+	Recorders []RecorderCfg `json:"recorders"`
+	// Where the codec was read, zero if it was not given
+	codecSource CfgSource
+}
+
+type RecorderCfg struct {
+	Enable            bool   `json:"enable"`
+	Address           string `json:"address"`
+	Port              int    `json:"port"`
+	MediaTransport    string `json:"media_transport"`
+	Interleaved       bool   `json:"interleaved"`
+	KeepAliveInterval int    `json:"keep_alive_interval"`
+	Ed137Version      string `json:"ed137_version"`
+	RecGroup          bool   `json:"rec_group"`
+	// Empty for the codec of the RecFile
+	Codec string `json:"codec,omitempty"`
+	// Nil for the default payload type of the codec
+	PayloadType *int `json:"payload_type,omitempty"`
+	// Nil for every call
+	Filter   *RecorderFilter `json:"filter,omitempty"`
+	Username string          `json:"username,omitempty"`
+	Password string          `json:"password,omitempty"`
+	// any, digest or basic
+	Auth         string `json:"auth"`
+	Rtsps        bool   `json:"rtsps"`
+	CAFile       string `json:"ca_file,omitempty"`
+	CertFile     string `json:"cert_file,omitempty"`
+	KeyFile      string `json:"key_file,omitempty"`
+	PathTemplate string `json:"path_template"`
+	// Recorders sharing a failover group record a call on one of them, the
+	// first one in the file when it is up
+	FailoverGroup string `json:"failover_group,omitempty"`
+	// Read from the certificate files by loadTLSConfigs
+	tlsConfig *tls.Config
+	tlsHash   string
+	// Where the recorder is configured
+	file  string
+	line  int
+	index int
+	// Where each key given in the file was read, named as in rec.json
+	sources map[string]CfgSource
+}
+
+// jsonNode is a decoded JSON value with the line it starts on.
+type jsonNode struct {
+	line   int
+	kind   string
+	keys   []string
+	fields map[string]*jsonNode
+	items  []*jsonNode
+	value  interface{}
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func parseJSONNode(data []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := readJSONNode(dec, data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_SYNTAX_ERROR, Line: lineAt(data, dec.InputOffset()), Msg: "unexpected data after the top-level value"}
+	}
+	return node, nil
+}
+
+func readJSONNode(dec *json.Decoder, data []byte) (*jsonNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonSyntaxError(data, dec, err)
+	}
+	node := &jsonNode{line: lineAt(data, dec.InputOffset())}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			node.kind = "object"
+			node.fields = make(map[string]*jsonNode)
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, jsonSyntaxError(data, dec, err)
+				}
+				key := keyTok.(string)
+				if _, ok := node.fields[key]; ok {
+					return nil, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_DUPLICATE_KEY, Line: lineAt(data, dec.InputOffset()), Path: key, Msg: "duplicate key"}
+				}
+				field, err := readJSONNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key)
+				node.fields[key] = field
+			}
+		} else {
+			node.kind = "array"
+			for dec.More() {
+				item, err := readJSONNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, jsonSyntaxError(data, dec, err)
+		}
+	case string:
+		node.kind = "string"
+		node.value = v
+	case json.Number:
+		node.kind = "number"
+		node.value = v
+	case bool:
+		node.kind = "boolean"
+		node.value = v
+	default:
+		node.kind = "null"
+	}
+	return node, nil
+}
+
+func jsonSyntaxError(data []byte, dec *json.Decoder, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_SYNTAX_ERROR, Line: lineAt(data, syntaxErr.Offset), Msg: syntaxErr.Error()}
+	}
+	return CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_SYNTAX_ERROR, Line: lineAt(data, dec.InputOffset()), Msg: err.Error()}
+}
+
+// validate checks node against schema and returns every error found.
+func (schema *jsonSchema) validate(node *jsonNode, path string) CfgIssues {
+	fail := func(issueType constant.CfgIssueType, format string, args ...interface{}) CfgIssues {
+		return CfgIssues{{Severity: constant.CFG_ERROR, Type: issueType, Line: node.line, Path: path, Msg: fmt.Sprintf(format, args...)}}
+	}
+	switch schema.Type {
+	case "integer":
+		if node.kind != "number" {
+			return fail(constant.CFG_INVALID_VALUE, "must be an integer, not %s", node.kind)
+		}
+		if _, err := node.value.(json.Number).Int64(); err != nil {
+			return fail(constant.CFG_INVALID_VALUE, "must be an integer, not %s", node.value)
+		}
+	case "":
+	default:
+		if node.kind != schema.Type {
+			return fail(constant.CFG_INVALID_VALUE, "must be %s %s, not %s", article(schema.Type), schema.Type, node.kind)
+		}
+	}
+	var cfgErrs CfgIssues
+	if len(schema.Enum) != 0 {
+		found := false
+		allowed := make([]string, len(schema.Enum))
+		for i, v := range schema.Enum {
+			allowed[i] = fmt.Sprint(v)
+			if fmt.Sprint(v) == fmt.Sprint(node.value) {
+				found = true
+			}
+		}
+		if !found {
+			cfgErrs = append(cfgErrs, fail(constant.CFG_INVALID_VALUE, "%v is not one of %s", node.value, strings.Join(allowed, ", "))...)
+		}
+	}
+	if node.kind == "number" {
+		v, _ := node.value.(json.Number).Float64()
+		if schema.Minimum != nil && v < *schema.Minimum {
+			cfgErrs = append(cfgErrs, fail(constant.CFG_INVALID_VALUE, "must be at least %v", *schema.Minimum)...)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			cfgErrs = append(cfgErrs, fail(constant.CFG_INVALID_VALUE, "must be at most %v", *schema.Maximum)...)
+		}
+	}
+	switch node.kind {
+	case "object":
+		for _, key := range schema.Required {
+			if _, ok := node.fields[key]; !ok {
+				cfgErrs = append(cfgErrs, fail(constant.CFG_MISSING_KEY, "missing required key %q", key)...)
+			}
+		}
+		for _, key := range node.keys {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			fieldSchema, ok := schema.Properties[key]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					cfgErrs = append(cfgErrs, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_UNKNOWN_KEY, Line: node.fields[key].line, Path: fieldPath, Msg: "unknown key"})
+				}
+				continue
+			}
+			cfgErrs = append(cfgErrs, fieldSchema.validate(node.fields[key], fieldPath)...)
+		}
+	case "array":
+		if schema.Items != nil {
+			for i, item := range node.items {
+				cfgErrs = append(cfgErrs, schema.Items.validate(item, path+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	}
+	return cfgErrs
+}
+
+func article(word string) string {
+	if strings.ContainsAny(word[:1], "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+// defaults returns the JSON object made of the default values of the
+// properties of schema.
+func (schema *jsonSchema) defaults() []byte {
+	defaults := make(map[string]interface{})
+	for key, property := range schema.Properties {
+		if property.Default != nil {
+			defaults[key] = property.Default
+		}
+	}
+	data, _ := json.Marshal(defaults)
+	return data
+}
+
+// parseRecJSON decodes and validates a rec.json document. Any error rejects
+// the whole file.
+func parseRecJSON(data []byte, file string) (RecFile, CfgIssues) {
+	node, err := parseJSONNode(data)
+	if err != nil {
+		issue, ok := err.(CfgIssue)
+		if !ok {
+			issue = CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_SYNTAX_ERROR, Msg: err.Error()}
+		}
+		issue.File = file
+		issue.Recorder = -1
+		return RecFile{}, CfgIssues{issue}
+	}
+	issues := recSchema.validate(node, "")
+	if node.kind == "object" && node.fields["recorders"] != nil {
+		defaultCodec := "g711alaw"
+		if codec, ok := node.fields["codec"]; ok && codec.kind == "string" {
+			defaultCodec = codec.value.(string)
+		}
+		for i, item := range node.fields["recorders"].items {
+			if address, ok := item.fields["address"]; ok && address.kind == "string" {
+				if _, ok := utils.ParseHost(address.value.(string)); !ok {
+					issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, Line: address.line, Path: "recorders[" + strconv.Itoa(i) + "].address",
+						Msg: fmt.Sprintf("%q is not a hostname or an IP address", address.value)})
+				}
+			}
+			codec := defaultCodec
+			if itemCodec, ok := item.fields["codec"]; ok && itemCodec.kind == "string" {
+				codec = itemCodec.value.(string)
+			}
+			if payloadType, ok := item.fields["payload_type"]; ok && payloadType.kind == "number" {
+				if pt, err := payloadType.value.(json.Number).Int64(); err == nil && !validPayloadType(codec, int(pt)) {
+					issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, Line: payloadType.line, Path: "recorders[" + strconv.Itoa(i) + "].payload_type",
+						Msg: fmt.Sprintf("%d can not carry %s, use %d or a dynamic payload type (96-127)", pt, codec, defaultPayloadType(codec))})
+				}
+			}
+			if filter, ok := item.fields["filter"]; ok && filter.kind == "object" {
+				for _, key := range []string{"calling_numbers", "called_numbers", "vcs_users"} {
+					patterns, ok := filter.fields[key]
+					if !ok {
+						continue
+					}
+					for k, pattern := range patterns.items {
+						if pattern.kind != "string" {
+							continue
+						}
+						if _, err := path.Match(pattern.value.(string), ""); err != nil {
+							issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, Line: pattern.line, Path: fmt.Sprintf("recorders[%d].filter.%s[%d]", i, key, k),
+								Msg: fmt.Sprintf("%q is not a valid glob pattern", pattern.value)})
+						}
+					}
+				}
+			}
+		}
+	}
+	for i := range issues {
+		issues[i].File = file
+		issues[i].Recorder = -1
+		fmt.Sscanf(issues[i].Path, "recorders[%d]", &issues[i].Recorder)
+	}
+	if len(issues) != 0 {
+		sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+		return RecFile{}, issues
+	}
+
+	var doc struct {
+		Codec     *string           `json:"codec"`
+		Timings   *TimingsCfg       `json:"timings"`
+		Recorders []json.RawMessage `json:"recorders"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return RecFile{}, CfgIssues{{Severity: constant.CFG_ERROR, Type: constant.CFG_SYNTAX_ERROR, File: file, Recorder: -1, Msg: err.Error()}}
+	}
+	recFile := RecFile{}
+	json.Unmarshal(recSchema.defaults(), &recFile)
+	if doc.Codec != nil {
+		recFile.Codec = *doc.Codec
+		recFile.codecSource = CfgSource{File: file, Line: node.fields["codec"].line}
+	}
+	if doc.Timings != nil {
+		recFile.Timings = doc.Timings
+		recFile.Timings.line = node.fields["timings"].line
+		issues = append(issues, recFile.Timings.check(file)...)
+	}
+	recorderDefaults := recSchema.Properties["recorders"].Items.defaults()
+	for i, raw := range doc.Recorders {
+		recorderCfg := RecorderCfg{}
+		json.Unmarshal(recorderDefaults, &recorderCfg)
+		json.Unmarshal(raw, &recorderCfg)
+		recorderCfg.Address, _ = utils.ParseHost(recorderCfg.Address)
+		recorderCfg.file = file
+		item := node.fields["recorders"].items[i]
+		recorderCfg.line = item.line
+		recorderCfg.index = i
+		recorderCfg.sources = make(map[string]CfgSource)
+		for key, field := range item.fields {
+			recorderCfg.sources[key] = CfgSource{File: file, Line: field.line}
+		}
+		recFile.Recorders = append(recFile.Recorders, recorderCfg)
+	}
+	for _, recorderCfg := range recFile.Recorders {
+		prefix := fmt.Sprintf("recorders[%d].", recorderCfg.index)
+		issues = append(issues, recorderCfg.checkFilter(prefix+"filter.recorder_types")...)
+		issues = append(issues, recorderCfg.checkSecurity(prefix)...)
+		issues = append(issues, recorderCfg.checkPathTemplate(prefix+"path_template")...)
+	}
+	if len(issues.Errors()) != 0 {
+		return RecFile{}, issues
+	}
+	issues = append(issues, checkFailoverGroups(recFile.Recorders)...)
+	return recFile, append(issues, checkDupAddrs(recFile.Recorders)...)
+}
+
+// ParseRecFile decodes and validates a rec.json document. The returned error
+// is a CfgIssues listing every error found with its line number.
+func ParseRecFile(data []byte) (RecFile, error) {
+	recFile, issues := parseRecJSON(data, "")
+	if errs := issues.Errors(); len(errs) != 0 {
+		return RecFile{}, errs
+	}
+	return recFile, nil
+}
+
+// LoadRecFile fills the config with the enabled recorders of recFile.
+func (cfg *Config) LoadRecFile(recFile RecFile) {
+	if recFile.Timings != nil {
+		cfg.timings = recFile.Timings.resolve()
+		if recFile.Timings.ReleaseTimeoutMs != nil {
+			cfg.releaseTimeoutMs = *recFile.Timings.ReleaseTimeoutMs
+		}
+	}
+	// Rank of the next recorder of each failover group
+	priorities := make(map[string]int)
+	for _, recorderCfg := range recFile.Recorders {
+		if !recorderCfg.Enable {
+			continue
+		}
+		interleave := "disable"
+		if recorderCfg.Interleaved {
+			interleave = "enable"
+		}
+		cfg.recAddrs = append(cfg.recAddrs, net.JoinHostPort(recorderCfg.Address, strconv.Itoa(recorderCfg.Port)))
+		cfg.mediaTransports = append(cfg.mediaTransports, recorderCfg.MediaTransport)
+		cfg.keepTimeAlives = append(cfg.keepTimeAlives, strconv.Itoa(recorderCfg.KeepAliveInterval))
+		cfg.interleaves = append(cfg.interleaves, interleave)
+		cfg.ed137Versions = append(cfg.ed137Versions, recorderCfg.Ed137Version)
+		cfg.recGroups = append(cfg.recGroups, recorderCfg.RecGroup)
+		codec := recorderCfg.Codec
+		if codec == "" {
+			codec = recFile.Codec
+		}
+		if codec == "" {
+			codec = "g711alaw"
+		}
+		payloadType := defaultPayloadType(codec)
+		if recorderCfg.PayloadType != nil {
+			payloadType = uint8(*recorderCfg.PayloadType)
+		}
+		cfg.codecs = append(cfg.codecs, codec)
+		cfg.payloadTypes = append(cfg.payloadTypes, payloadType)
+		cfg.descs = append(cfg.descs, newDesc(codec, payloadType))
+		filter := RecorderFilter{}
+		if recorderCfg.Filter != nil {
+			filter = *recorderCfg.Filter
+		}
+		cfg.filters = append(cfg.filters, filter)
+		cfg.securities = append(cfg.securities, recorderCfg.security())
+		cfg.tlsConfigs = append(cfg.tlsConfigs, recorderCfg.tlsConfig)
+		pathTemplate := recorderCfg.PathTemplate
+		if pathTemplate == "" {
+			pathTemplate = defaultPathTemplate
+		}
+		cfg.pathTemplates = append(cfg.pathTemplates, pathTemplate)
+		cfg.failoverGroups = append(cfg.failoverGroups, recorderCfg.FailoverGroup)
+		cfg.failoverPriorities = append(cfg.failoverPriorities, priorities[recorderCfg.FailoverGroup])
+		if recorderCfg.FailoverGroup != "" {
+			priorities[recorderCfg.FailoverGroup]++
+		}
+		source := recorderSource{
+			entry:  CfgSource{File: recorderCfg.file, Line: recorderCfg.line},
+			index:  recorderCfg.index,
+			values: make(map[string]CfgSource),
+		}
+		for key, valueSource := range recorderCfg.sources {
+			source.values[key] = valueSource
+		}
+		if _, ok := source.values["codec"]; !ok && recFile.codecSource.Line != 0 {
+			source.values["codec"] = recFile.codecSource
+		}
+		cfg.sources = append(cfg.sources, source)
+		cfg.MaxCh++
+		if recorderCfg.RecGroup {
+			cfg.NumGroupCh++
+		} else {
+			cfg.NumNonGroupCh++
+		}
+	}
+}
+
+// ConvertLegacyConfig converts rec.cfg and the tmcs_server section of
+// device_system.cfg to a rec.json document. Either file may be nil, the paths
+// only name the files in the issues. The recorders with an error are left out
+// and reported with the warnings.
+func ConvertLegacyConfig(recData []byte, recPath string, devSysData []byte, devSysPath string) ([]byte, CfgIssues, error) {
+	recFile, issues := ParseLegacyRecFile(recData, recPath)
+	devSysRecorders, devSysIssues := ParseLegacyDevSysFile(devSysData, devSysPath)
+	recFile.Recorders = append(recFile.Recorders, devSysRecorders...)
+	issues = append(issues, devSysIssues...)
+	issues = append(issues, checkFailoverGroups(recFile.Recorders)...)
+	issues = append(issues, checkDupAddrs(recFile.Recorders)...)
+	data, err := json.MarshalIndent(recFile, "", "  ")
+	if err != nil {
+		return nil, issues, err
+	}
+	data = append(data, '\n')
+	if _, err := ParseRecFile(data); err != nil {
+		return nil, issues, err
+	}
+	return data, issues, nil
+}
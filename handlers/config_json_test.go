@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestParseRecJSONIssues(t *testing.T) {
+	type issue struct {
+		issueType constant.CfgIssueType
+		line      int
+		path      string
+		recorder  int
+	}
+	for _, tc := range []struct {
+		name string
+		data string
+		want []issue
+	}{
+		{"valid", `{"recorders": [{"address": "10.0.0.1"}]}`, nil},
+		{"empty document", ``, []issue{{constant.CFG_SYNTAX_ERROR, 1, "", -1}}},
+		{"unclosed object", "{\n\"recorders\": [\n{\"address\": \"10.0.0.1\"}\n]\n", []issue{{constant.CFG_SYNTAX_ERROR, 5, "", -1}}},
+		{"unclosed recorder", "{\"recorders\": [\n{\"address\": \"10.0.0.1\",\n", []issue{{constant.CFG_SYNTAX_ERROR, 3, "", -1}}},
+		{"unclosed string", "{\"recorders\": [\n{\"address\": \"10.0.0.1}]}", []issue{{constant.CFG_SYNTAX_ERROR, 2, "", -1}}},
+		{"trailing comma", "{\"recorders\": [\n{\"address\": \"10.0.0.1\"},\n]}", []issue{{constant.CFG_SYNTAX_ERROR, 2, "", -1}}},
+		{"data after the object", "{\"recorders\": []}\n{}", []issue{{constant.CFG_SYNTAX_ERROR, 2, "", -1}}},
+		{"duplicate top-level key", "{\"codec\": \"l16\",\n\"codec\": \"g711ulaw\",\n\"recorders\": []}", []issue{{constant.CFG_DUPLICATE_KEY, 2, "codec", -1}}},
+		{"duplicate recorder key", "{\"recorders\": [\n{\"address\": \"10.0.0.1\",\n\"port\": 554,\n\"port\": 8554}]}", []issue{{constant.CFG_DUPLICATE_KEY, 4, "port", -1}}},
+		{"missing recorders", `{"codec": "g711alaw"}`, []issue{{constant.CFG_MISSING_KEY, 1, "", -1}}},
+		{"missing address", "{\"recorders\": [\n{\"port\": 554}]}", []issue{{constant.CFG_MISSING_KEY, 2, "recorders[0]", 0}}},
+		{"unknown key", "{\"recorders\": [{\"address\": \"10.0.0.1\",\n\"prot\": 554}]}", []issue{{constant.CFG_UNKNOWN_KEY, 2, "recorders[0].prot", 0}}},
+		{"port out of range", "{\"recorders\": [\n{\"address\": \"10.0.0.1\"},\n{\"address\": \"10.0.0.2\", \"port\": 70000}]}", []issue{{constant.CFG_INVALID_VALUE, 3, "recorders[1].port", 1}}},
+		{"wrong type", "{\"recorders\": [{\"address\": 10}]}", []issue{{constant.CFG_INVALID_VALUE, 1, "recorders[0].address", 0}}},
+		{"errors sorted by line", "{\"recorders\": [\n{\"address\": \"10.0.0.1\", \"port\": 0},\n{\"address\": \"10.0.0.2\", \"port\": \"554\"}],\n\"codec\": 1}", []issue{
+			{constant.CFG_INVALID_VALUE, 2, "recorders[0].port", 0},
+			{constant.CFG_INVALID_VALUE, 3, "recorders[1].port", 1},
+			{constant.CFG_INVALID_VALUE, 4, "codec", -1},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, issues := parseRecJSON([]byte(tc.data), "rec.json")
+			var got []issue
+			for _, cfgIssue := range issues.Errors() {
+				if cfgIssue.File != "rec.json" {
+					t.Errorf("issue %v has file %q", cfgIssue, cfgIssue.File)
+				}
+				got = append(got, issue{cfgIssue.Type, cfgIssue.Line, cfgIssue.Path, cfgIssue.Recorder})
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got issues %v, want %v", issues, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got issues %v, want %v", issues, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -143,7 +143,7 @@ func marshalCRDAttributes(e *xml.Encoder, v reflect.Value, name string) {
 	}
 }
 
-func (crd *CRD) SetCRDInner(crdMsg string, crdMsgId string, ed137Version string, sipType constant.RecorderType) {
+func (crd *CRD) SetCRDInner(crdFields []models.CRDField, ed137Version string, sipType constant.RecorderType) {
 	// if ed137Version == "ED137B" && sipType == constant.RET_RADIO_RX {
 	// 	return
 	// }
@@ -151,14 +151,11 @@ func (crd *CRD) SetCRDInner(crdMsg string, crdMsgId string, ed137Version string,
 		crd.Operations.Enabled = true
 	}
 
-	crdPara := strings.Split(crdMsg, ",")
-	crdParaId := strings.Split(crdMsgId, ",")
-
-	for i, v := range crdParaId {
-		switch vInt, _ := strconv.Atoi(v); vInt {
-		case int(constant.VCS_USER_ID):
+	for _, crdField := range crdFields {
+		switch crdField.Id {
+		case constant.VCS_USER_ID:
 			if crd.VCSUser == "" {
-				crd.VCSUser = crdPara[i]
+				crd.VCSUser = crdField.Value
 			}
 			if crd.Properties.Vnd.Value == "" {
 				switch sipType {
@@ -174,123 +171,123 @@ func (crd *CRD) SetCRDInner(crdMsg string, crdMsgId string, ed137Version string,
 					crd.Properties.Vnd.Value += ", Radio Selection = RX"
 				}
 			}
-		case int(constant.ENDPT_ID_ID):
+		case constant.ENDPT_ID_ID:
 			if crd.Properties.Vnd.Value == "" {
-				crd.Properties.Vnd.Value += ("TGW Port = " + crdPara[i])
+				crd.Properties.Vnd.Value += ("TGW Port = " + crdField.Value)
 			} else {
-				crd.Properties.Vnd.Value += (", TGW Port = " + crdPara[i])
+				crd.Properties.Vnd.Value += (", TGW Port = " + crdField.Value)
 			}
-		case int(constant.CLIENT_TYPE_ID):
-			crd.Properties.ClientType.Value = crdPara[i]
-		case int(constant.DESC_ID):
-			if crdPara[i] == "" {
+		case constant.CLIENT_TYPE_ID:
+			crd.Properties.ClientType.Value = crdField.Value
+		case constant.DESC_ID:
+			if crdField.Value == "" {
 				continue
 			} else {
 				if crd.Properties.Vnd.Value == "" {
-					crd.Properties.Vnd.Value += crdPara[i]
+					crd.Properties.Vnd.Value += crdField.Value
 				} else if strings.Contains(crd.Properties.Vnd.Value, "desc") {
 					continue
 				} else {
-					crd.Properties.Vnd.Value += (", desc = " + crdPara[i])
+					crd.Properties.Vnd.Value += (", desc = " + crdField.Value)
 				}
 			}
-		case int(constant.GROUP_NAME_ID):
-			if crdPara[i] == "" {
+		case constant.GROUP_NAME_ID:
+			if crdField.Value == "" {
 				continue
 			} else {
 				if crd.Properties.Vnd.Value == "" {
-					crd.Properties.Vnd.Value += crdPara[i]
+					crd.Properties.Vnd.Value += crdField.Value
 				} else if strings.Contains(crd.Properties.Vnd.Value, "group name") {
 					continue
 				} else {
-					crd.Properties.Vnd.Value += (", group name = " + crdPara[i])
+					crd.Properties.Vnd.Value += (", group name = " + crdField.Value)
 				}
 			}
-		case int(constant.ALERT_NR_ID):
-			crd.Properties.AlertNr.Value = strings.TrimSuffix(crdPara[i], ";ob")
-		case int(constant.ALERT_TIME_ID):
-			crd.Properties.AlertTime.Value = crdPara[i]
-		case int(constant.CALLING_NR_ID):
-			crd.Properties.CallingNr = models.CRDAttribute{Value: strings.TrimSuffix(crdPara[i], ";ob")}
-		case int(constant.CALLED_NR_ID):
-			crd.Properties.CalledNr = models.CRDAttribute{Value: strings.TrimSuffix(crdPara[i], ";ob")}
+		case constant.ALERT_NR_ID:
+			crd.Properties.AlertNr.Value = strings.TrimSuffix(crdField.Value, ";ob")
+		case constant.ALERT_TIME_ID:
+			crd.Properties.AlertTime.Value = crdField.Value
+		case constant.CALLING_NR_ID:
+			crd.Properties.CallingNr = models.CRDAttribute{Value: strings.TrimSuffix(crdField.Value, ";ob")}
+		case constant.CALLED_NR_ID:
+			crd.Properties.CalledNr = models.CRDAttribute{Value: strings.TrimSuffix(crdField.Value, ";ob")}
 			crd.Properties.ConnectedNr = models.CRDAttribute{Value: crd.Properties.CalledNr.Value}
-		case int(constant.CLIENT_ID_ID):
-			crd.Properties.ClientId = models.CRDAttribute{Value: strings.TrimSuffix(crdPara[i], ";ob")}
+		case constant.CLIENT_ID_ID:
+			crd.Properties.ClientId = models.CRDAttribute{Value: strings.TrimSuffix(crdField.Value, ";ob")}
 			if ed137Version == "ED137C" {
 				crd.Properties.ClientType = models.CRDAttribute{Value: "CWP"}
 			}
-		case int(constant.CALL_REF_ID):
+		case constant.CALL_REF_ID:
 			if ed137Version == "ED137C" || sipType == constant.RET_PHONE {
-				crd.Properties.CallRef = models.CRDAttribute{Value: crdPara[i]}
+				crd.Properties.CallRef = models.CRDAttribute{Value: crdField.Value}
 			}
-		case int(constant.CONNECT_TIME_ID):
+		case constant.CONNECT_TIME_ID:
 			switch sipType {
 			case constant.RET_PHONE:
-				crd.Properties.ConnectTime = models.CRDAttribute{Value: crdPara[i]}
-				crd.Properties.ConnectedTime = models.CRDAttribute{Value: crdPara[i]}
+				crd.Properties.ConnectTime = models.CRDAttribute{Value: crdField.Value}
+				crd.Properties.ConnectedTime = models.CRDAttribute{Value: crdField.Value}
 			case constant.RET_RADIO_TX:
-				crd.Operations.PTT.Time = crdPara[i]
-				crd.Operations.FrequencyID.Time = crdPara[i]
+				crd.Operations.PTT.Time = crdField.Value
+				crd.Operations.FrequencyID.Time = crdField.Value
 			case constant.RET_RADIO_RX:
-				crd.Properties.ConnectTime = models.CRDAttribute{Value: crdPara[i]}
-				crd.Operations.SQU.Time = crdPara[i]
-				crd.Operations.FrequencyID.Time = crdPara[i]
+				crd.Properties.ConnectTime = models.CRDAttribute{Value: crdField.Value}
+				crd.Operations.SQU.Time = crdField.Value
+				crd.Operations.FrequencyID.Time = crdField.Value
 			default:
-				crd.Properties.ConnectTime.Value = crdPara[i]
+				crd.Properties.ConnectTime.Value = crdField.Value
 			}
-		case int(constant.SETUP_TIME_ID):
-			crd.Properties.SetupTime = models.CRDAttribute{Value: crdPara[i]}
+		case constant.SETUP_TIME_ID:
+			crd.Properties.SetupTime = models.CRDAttribute{Value: crdField.Value}
 			if sipType == constant.RET_PHONE {
 				continue
 			} else if ed137Version == "ED137C" {
-				crd.Operations.RadioAccessMode.Time = crdPara[i]
-				crd.Properties.ConnectTime.Value = crdPara[i]
-				crd.Operations.R2S.Time = crdPara[i]
-				crd.Operations.FrequencyID.Time = crdPara[i]
+				crd.Operations.RadioAccessMode.Time = crdField.Value
+				crd.Properties.ConnectTime.Value = crdField.Value
+				crd.Operations.R2S.Time = crdField.Value
+				crd.Operations.FrequencyID.Time = crdField.Value
 			}
-		case int(constant.HOLD_TIME_ID):
+		case constant.HOLD_TIME_ID:
 			switch sipType {
 			case constant.RET_PHONE:
-				crd.Operations.HOLD.Time = crdPara[i]
+				crd.Operations.HOLD.Time = crdField.Value
 			case constant.RET_RADIO_TX:
-				crd.Operations.PTT.Time = crdPara[i]
+				crd.Operations.PTT.Time = crdField.Value
 			case constant.RET_RADIO_RX:
-				crd.Operations.SQU.Time = crdPara[i]
+				crd.Operations.SQU.Time = crdField.Value
 			}
-		case int(constant.DISCONNECT_TIME_ID):
-			crd.Properties.DisconnectTime = models.CRDAttribute{Value: crdPara[i]}
-		case int(constant.CALL_TYPE_ID):
+		case constant.DISCONNECT_TIME_ID:
+			crd.Properties.DisconnectTime = models.CRDAttribute{Value: crdField.Value}
+		case constant.CALL_TYPE_ID:
 			if ed137Version == "ED137C" || sipType == constant.RET_PHONE {
-				crd.Properties.CallType = models.CRDAttribute{Value: crdPara[i]}
-				if strings.Contains(crdPara[i], "monitoring") {
+				crd.Properties.CallType = models.CRDAttribute{Value: crdField.Value}
+				if strings.Contains(crdField.Value, "monitoring") {
 					crd.Disabled = true
 				}
 			}
-		case int(constant.DIRECTION_ID):
-			crd.Properties.Direction = models.CRDAttribute{Value: crdPara[i]}
-		case int(constant.SIP_DISCONNECT_CAUSE_ID):
-			crd.Properties.SipDisconnectCause = models.CRDAttribute{Value: crdPara[i]}
-		case int(constant.PRIORITY_ID):
-			if strings.ToUpper(crdPara[i]) == "NORMAL" {
+		case constant.DIRECTION_ID:
+			crd.Properties.Direction = models.CRDAttribute{Value: crdField.Value}
+		case constant.SIP_DISCONNECT_CAUSE_ID:
+			crd.Properties.SipDisconnectCause = models.CRDAttribute{Value: crdField.Value}
+		case constant.PRIORITY_ID:
+			if strings.ToUpper(crdField.Value) == "NORMAL" {
 				crd.Properties.Priority = models.CRDAttribute{Value: "3"}
-			} else if strings.ToUpper(crdPara[i]) == "EMERGENCY" {
+			} else if strings.ToUpper(crdField.Value) == "EMERGENCY" {
 				crd.Properties.Priority = models.CRDAttribute{Value: "1"}
-			} else if strings.ToUpper(crdPara[i]) == "URGENT" {
+			} else if strings.ToUpper(crdField.Value) == "URGENT" {
 				crd.Properties.Priority = models.CRDAttribute{Value: "2"}
 			} else {
 				crd.Properties.Priority = models.CRDAttribute{Value: "4"}
 			}
-		case int(constant.FREQUENCY_ID_ID):
+		case constant.FREQUENCY_ID_ID:
 			switch ed137Version {
 			case "ED137B":
-				crd.Properties.FrequencyID.Value = crdPara[i]
+				crd.Properties.FrequencyID.Value = crdField.Value
 			case "ED137C":
-				crd.Operations.FrequencyID.Value = crdPara[i]
+				crd.Operations.FrequencyID.Value = crdField.Value
 			}
-		case int(constant.RADIO_ACCESS_MODE_ID):
+		case constant.RADIO_ACCESS_MODE_ID:
 			if sipType == constant.RET_RADIO_RX {
-				switch paraInt, _ := strconv.Atoi(crdPara[i]); paraInt {
+				switch paraInt, _ := strconv.Atoi(crdField.Value); paraInt {
 				case 1:
 					crd.Operations.RadioAccessMode.Value = "1"
 				case 2:
@@ -301,15 +298,15 @@ func (crd *CRD) SetCRDInner(crdMsg string, crdMsgId string, ed137Version string,
 					crd.Operations.RadioAccessMode.Value = "0"
 				}
 			}
-		case int(constant.R2S_ID):
+		case constant.R2S_ID:
 			if ed137Version == "ED137B" || sipType == constant.RET_RADIO_TX {
 				continue
 			} else {
-				crd.Operations.R2S.Value = "Rx=" + crdPara[i]
+				crd.Operations.R2S.Value = "Rx=" + crdField.Value
 			}
-		case int(constant.PTT_TYPE_ID):
+		case constant.PTT_TYPE_ID:
 			if sipType == constant.RET_RADIO_TX {
-				crd.Operations.PTT_Type = crdPara[i]
+				crd.Operations.PTT_Type = crdField.Value
 			}
 		}
 	}
@@ -335,6 +332,23 @@ func (crd *CRD) SetCRDInner(crdMsg string, crdMsgId string, ed137Version string,
 	}
 }
 
+// ParseCRDPairs is the adapter for the legacy exports, which pass the CRD as
+// comma-separated values and ids paired by index. Ids that are unknown or have
+// no value at their index are skipped; an empty value is kept.
+func ParseCRDPairs(crdMsg string, crdMsgId string) []models.CRDField {
+	crdPara := strings.Split(crdMsg, ",")
+	crdParaId := strings.Split(crdMsgId, ",")
+	crdFields := []models.CRDField{}
+	for i, v := range crdParaId {
+		vInt, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || i >= len(crdPara) || vInt < int(constant.VCS_USER_ID) || vInt > int(constant.GROUP_NAME_ID) {
+			continue
+		}
+		crdFields = append(crdFields, models.CRDField{Id: constant.Crd(vInt), Value: crdPara[i]})
+	}
+	return crdFields
+}
+
 func GetDisconnectCause(sipDisconnectCause string, clientErr error) constant.Q931Cause {
 	if vInt, _ := strconv.Atoi(sipDisconnectCause); sipDisconnectCause == "" || vInt == int(constant.PJSIP_SC_OK) {
 		if clientErr == nil {
@@ -381,6 +395,15 @@ func GetDisconnectCause(sipDisconnectCause string, clientErr error) constant.Q93
 	}
 }
 
+// frequencyID returns the frequency id of a radio call, set in the
+// properties or the operations depending on the ED137 version.
+func (crd CRD) frequencyID() string {
+	if crd.Properties.FrequencyID.Value != "" {
+		return crd.Properties.FrequencyID.Value
+	}
+	return crd.Operations.FrequencyID.Value
+}
+
 func (crd *CRD) EnableDisconnectPhone() {
 	crd.DisableAllProperty()
 	crd.Properties.Vnd.Disabled = false
@@ -550,3 +573,19 @@ func (crd *CRD) EnableDisconnectGroup() {
 	crd.Properties.DisconnectTime.Disabled = false
 	crd.Properties.DisconnectCause.Disabled = false
 }
+
+// EnableDisconnect selects the properties sent when the session of a call of
+// recorderType is torn down.
+func (crd *CRD) EnableDisconnect(recorderType constant.RecorderType) {
+	switch recorderType {
+	case constant.RET_PHONE:
+		crd.Operations.Enabled = false
+		crd.EnableDisconnectPhone()
+	case constant.RET_RADIO_TX, constant.RET_RADIO_RX:
+		crd.EnableDisconnectRadio()
+	case constant.RET_BRIEF:
+		crd.EnableDisconnectBrief()
+	default:
+		crd.EnableDisconnectGroup()
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+func TestParseCRDPairs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		crdMsg   string
+		crdMsgId string
+		want     []models.CRDField
+	}{
+		{"empty", "", "", []models.CRDField{}},
+		{"pairs by index", "cwp1,1001", "0,1", []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"spaces around the ids", "cwp1,1001", " 0 , 1", []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"empty value kept", ",1001", "0,1", []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: ""},
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"id without a value", "cwp1", "0,1", []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+		}},
+		{"value without an id", "cwp1,1001", "0", []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+		}},
+		{"id not a number", "cwp1,1001", "x,1", []models.CRDField{
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"empty id", "cwp1,1001", ",1", []models.CRDField{
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"negative id", "cwp1,1001", "-1,1", []models.CRDField{
+			{Id: constant.CALLING_NR_ID, Value: "1001"},
+		}},
+		{"id past the last", "cwp1,g", "0," + strconv.Itoa(int(constant.GROUP_NAME_ID)+1), []models.CRDField{
+			{Id: constant.VCS_USER_ID, Value: "cwp1"},
+		}},
+		{"last id", "g", strconv.Itoa(int(constant.GROUP_NAME_ID)), []models.CRDField{
+			{Id: constant.GROUP_NAME_ID, Value: "g"},
+		}},
+		{"ids only separators", "a,b", ",,", []models.CRDField{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ParseCRDPairs(tc.crdMsg, tc.crdMsgId); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("ParseCRDPairs(%q, %q) = %v, want %v", tc.crdMsg, tc.crdMsgId, got, tc.want)
+			}
+		})
+	}
+}
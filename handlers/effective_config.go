@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+// CfgSource is where a config value was read from. Default is set for the
+// values not given in the files.
+type CfgSource struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Default bool   `json:"default,omitempty"`
+}
+
+// recorderSource is where the settings of a channel were read from.
+type recorderSource struct {
+	// The recorder entry
+	entry CfgSource
+	// Index of the recorder entry in its file
+	index int
+	// The values given in the files, by rec.json key
+	values map[string]CfgSource
+}
+
+// EffectiveRecorder is the config a recorder channel runs with, once the
+// defaults are applied and the duplicate addresses merged. The keys are
+// those of rec.json.
+type EffectiveRecorder struct {
+	Channel           int                     `json:"channel"`
+	Address           string                  `json:"address"`
+	Port              int                     `json:"port"`
+	MediaTransport    constant.MediaTransport `json:"media_transport"`
+	Interleaved       bool                    `json:"interleaved"`
+	KeepAliveInterval int                     `json:"keep_alive_interval"`
+	Ed137Version      constant.ED137Version   `json:"ed137_version"`
+	RecGroup          bool                    `json:"rec_group"`
+	Codec             constant.Codec          `json:"codec"`
+	PayloadType       int                     `json:"payload_type"`
+	// Nil for every call
+	Filter   *RecorderFilter     `json:"filter,omitempty"`
+	Username string              `json:"username,omitempty"`
+	Auth     constant.AuthMethod `json:"auth"`
+	Rtsps    bool                `json:"rtsps"`
+	// Resolved from the directory of the config file
+	CAFile        string `json:"ca_file,omitempty"`
+	CertFile      string `json:"cert_file,omitempty"`
+	KeyFile       string `json:"key_file,omitempty"`
+	PathTemplate  string `json:"path_template"`
+	FailoverGroup string `json:"failover_group,omitempty"`
+	// Rank in the failover group, 0 for the primary
+	FailoverRank int `json:"failover_rank,omitempty"`
+	// The recorder entry the channel comes from
+	Source   CfgSource `json:"source"`
+	Recorder int       `json:"recorder"`
+	// Where each value comes from, by key
+	Sources map[string]CfgSource `json:"sources"`
+}
+
+// EffectiveConfig is the config the client runs with.
+type EffectiveConfig struct {
+	Recorders     []EffectiveRecorder `json:"recorders"`
+	NumGroupCh    int                 `json:"group_channels"`
+	NumNonGroupCh int                 `json:"non_group_channels"`
+	// Timings of the calls by lower case recorder type, e.g. radio_tx
+	Timings map[string]Timings `json:"timings"`
+	// Zero when the release_timeout_ms option applies
+	ReleaseTimeoutMs int `json:"release_timeout_ms,omitempty"`
+}
+
+// effectiveKeys are the keys whose source EffectiveRecorder reports.
+var effectiveKeys = []string{"address", "port", "media_transport", "interleaved", "keep_alive_interval", "ed137_version", "rec_group", "codec", "payload_type",
+	"filter", "username", "auth", "rtsps", "ca_file", "cert_file", "key_file", "path_template", "failover_group"}
+
+func mediaTransportOf(name string) constant.MediaTransport {
+	if name == constant.MEDIA_TRANSPORT_TCP.String() {
+		return constant.MEDIA_TRANSPORT_TCP
+	}
+	return constant.MEDIA_TRANSPORT_UDP
+}
+
+func ed137VersionOf(name string) constant.ED137Version {
+	for _, version := range []constant.ED137Version{constant.ED137A, constant.ED137C} {
+		if name == version.String() {
+			return version
+		}
+	}
+	return constant.ED137B
+}
+
+func codecOf(name string) constant.Codec {
+	for _, codec := range []constant.Codec{constant.CODEC_G711ULAW, constant.CODEC_L16, constant.CODEC_L16_16K} {
+		if name == codec.String() {
+			return codec
+		}
+	}
+	return constant.CODEC_G711ALAW
+}
+
+func authMethodOf(name string) constant.AuthMethod {
+	for _, auth := range []constant.AuthMethod{constant.AUTH_DIGEST, constant.AUTH_BASIC} {
+		if name == auth.String() {
+			return auth
+		}
+	}
+	return constant.AUTH_ANY
+}
+
+// Effective returns the typed view of the config.
+func (cfg *Config) Effective() EffectiveConfig {
+	effective := EffectiveConfig{
+		Recorders:        []EffectiveRecorder{},
+		NumGroupCh:       cfg.NumGroupCh,
+		NumNonGroupCh:    cfg.NumNonGroupCh,
+		Timings:          make(map[string]Timings),
+		ReleaseTimeoutMs: cfg.releaseTimeoutMs,
+	}
+	for _, recorderType := range timingsRecorderTypes {
+		effective.Timings[strings.ToLower(recorderType.String())] = cfg.timingsOf(recorderType)
+	}
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		host, portStr, _ := net.SplitHostPort(cfg.recAddrs[ch])
+		port, _ := strconv.Atoi(portStr)
+		keepAlive, _ := strconv.Atoi(cfg.keepTimeAlives[ch])
+		security := cfg.securities[ch]
+		recorder := EffectiveRecorder{
+			Channel:           ch,
+			Address:           host,
+			Port:              port,
+			MediaTransport:    mediaTransportOf(cfg.mediaTransports[ch]),
+			Interleaved:       cfg.interleaves[ch] == "enable",
+			KeepAliveInterval: keepAlive,
+			Ed137Version:      ed137VersionOf(cfg.ed137Versions[ch]),
+			RecGroup:          cfg.recGroups[ch],
+			Codec:             codecOf(cfg.codecs[ch]),
+			PayloadType:       int(cfg.payloadTypes[ch]),
+			Username:          security.username,
+			Auth:              authMethodOf(security.auth),
+			Rtsps:             security.rtsps,
+			CAFile:            security.caFile,
+			CertFile:          security.certFile,
+			KeyFile:           security.keyFile,
+			PathTemplate:      cfg.pathTemplates[ch],
+			FailoverGroup:     cfg.failoverGroups[ch],
+			FailoverRank:      cfg.failoverPriorities[ch],
+			Sources:           make(map[string]CfgSource),
+		}
+		if !cfg.filters[ch].isEmpty() {
+			filter := cfg.filters[ch]
+			recorder.Filter = &filter
+		}
+		source := cfg.sources[ch]
+		recorder.Source = source.entry
+		recorder.Recorder = source.index
+		for _, key := range effectiveKeys {
+			valueSource, ok := source.values[key]
+			if !ok {
+				valueSource = CfgSource{Default: true}
+			}
+			recorder.Sources[key] = valueSource
+		}
+		effective.Recorders = append(effective.Recorders, recorder)
+	}
+	return effective
+}
+
+// EffectiveConfig returns the config the client runs with. During a reload
+// waiting for the calls to end, it is the config being replaced.
+func (rtspClient *RTSPClient) EffectiveConfig() EffectiveConfig {
+	rtspClient.cfgMutex.RLock()
+	defer rtspClient.cfgMutex.RUnlock()
+	return rtspClient.Config.Effective()
+}
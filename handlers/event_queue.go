@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+// debouncedQueueSize is the size of the queue of the events held back by
+// handleInner. It reads them as they come, so the queue blocks rather than
+// dropping any.
+const debouncedQueueSize = 64
+
+// queuedEvent is an event of the host waiting for its call.
+type queuedEvent struct {
+	event constant.CallEventType
+	state int
+	crd   []models.CRDField
+}
+
+// ends tells whether the event ends the call. Those events are never dropped,
+// or the call would never be released.
+func (event queuedEvent) ends() bool {
+	switch event.event {
+	case constant.CALL_STATE_EVENT:
+		return constant.CallState(event.state) == constant.PJSIP_INV_STATE_DISCONNECTED
+	case constant.RADIO_STATE_EVENT:
+		return constant.RadioButtonState(event.state) == constant.BUTTON_INVALID
+	case constant.BRIEF_STATE_EVENT:
+		return constant.BriefState(event.state) == constant.BRIEF_FALSE
+	case constant.GROUP_STATE_EVENT:
+		return constant.GroupState(event.state) == constant.GROUP_FALSE
+	}
+	return false
+}
+
+// buttonOn returns whether a radio button event presses or releases the
+// button, and false for the other events.
+func (event queuedEvent) buttonOn() (on bool, ok bool) {
+	if event.event != constant.RADIO_STATE_EVENT {
+		return false, false
+	}
+	switch constant.RadioButtonState(event.state) {
+	case constant.TX_BUTTON_ON, constant.RX_BUTTON_ON:
+		return true, true
+	case constant.TX_BUTTON_OFF, constant.RX_BUTTON_OFF:
+		return false, true
+	}
+	return false, false
+}
+
+// eventCounts counts the events dropped and coalesced by the queues of every
+// call since the client started.
+type eventCounts struct {
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// CallEventQueue keeps the events of a call in the order the host reported
+// them, up to a size over which its policy applies. It is shared by pointer
+// between the copies of the CallInfo.
+type CallEventQueue struct {
+	mutex  *sync.Mutex
+	events []queuedEvent
+	size   int
+	policy constant.QueuePolicy
+	// Signalled when events are waiting
+	ready chan struct{}
+	// Signalled when an event was taken, for the callers blocked by
+	// QUEUE_BLOCK
+	space chan struct{}
+	// Closed when the call ends
+	done      chan bool
+	dropped   uint64
+	coalesced uint64
+	// Counts of the client
+	totals *eventCounts
+}
+
+func NewCallEventQueue(size int, policy constant.QueuePolicy, done chan bool, totals *eventCounts) *CallEventQueue {
+	return &CallEventQueue{
+		mutex:  &sync.Mutex{},
+		size:   size,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		done:   done,
+		totals: totals,
+	}
+}
+
+func (queue *CallEventQueue) countDropped() {
+	queue.dropped++
+	queue.totals.dropped.Add(1)
+}
+
+func (queue *CallEventQueue) countCoalesced(n uint64) {
+	queue.coalesced += n
+	queue.totals.coalesced.Add(n)
+}
+
+// push queues event. It returns EVENT_DROPPED_BLOCKED once the call ended,
+// and EVENT_QUEUE_FULL if the event was dropped by the policy or ctx was done
+// while QUEUE_BLOCK waited for room.
+func (queue *CallEventQueue) push(ctx context.Context, event queuedEvent) constant.EventResult {
+	queue.mutex.Lock()
+	for {
+		if queue.ended() {
+			queue.mutex.Unlock()
+			return constant.EVENT_DROPPED_BLOCKED
+		}
+		// An event ending the call does not wait, it goes over the size of
+		// the queue
+		if queue.policy != constant.QUEUE_BLOCK || len(queue.events) < queue.size || event.ends() {
+			break
+		}
+		queue.mutex.Unlock()
+		select {
+		case <-queue.space:
+		case <-queue.done:
+			return constant.EVENT_DROPPED_BLOCKED
+		case <-ctx.Done():
+			return constant.EVENT_QUEUE_FULL
+		}
+		queue.mutex.Lock()
+	}
+	queue.events = append(queue.events, event)
+	for queue.policy != constant.QUEUE_BLOCK && len(queue.events) > queue.size {
+		if queue.policy == constant.QUEUE_COALESCE && queue.coalesce() {
+			continue
+		}
+		if queue.policy == constant.QUEUE_COALESCE && !event.ends() || !queue.dropOldest() {
+			// Rejected, the event is the last one
+			queue.events = queue.events[:len(queue.events)-1]
+			queue.countDropped()
+			queue.mutex.Unlock()
+			return constant.EVENT_QUEUE_FULL
+		}
+	}
+	queue.mutex.Unlock()
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+	return constant.EVENT_ACCEPTED
+}
+
+// ended tells whether the call ended, after which nothing reads the queue.
+func (queue *CallEventQueue) ended() bool {
+	select {
+	case <-queue.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// pop takes the oldest event, and returns false if there is none.
+func (queue *CallEventQueue) pop() (queuedEvent, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if len(queue.events) == 0 {
+		return queuedEvent{}, false
+	}
+	event := queue.events[0]
+	queue.events = queue.events[1:]
+	if len(queue.events) > 0 {
+		select {
+		case queue.ready <- struct{}{}:
+		default:
+		}
+	}
+	select {
+	case queue.space <- struct{}{}:
+	default:
+	}
+	return event, true
+}
+
+// remove takes out the events from i to j excluded. Their CRD attributes are
+// given to the event that follows, as the call would have got them.
+func (queue *CallEventQueue) remove(i int, j int) {
+	if j < len(queue.events) {
+		var crd []models.CRDField
+		for _, event := range queue.events[i:j] {
+			crd = append(crd, event.crd...)
+		}
+		queue.events[j].crd = append(crd, queue.events[j].crd...)
+	}
+	queue.events = append(queue.events[:i], queue.events[j:]...)
+}
+
+// dropOldest drops the oldest event that does not end the call, unless it is
+// the last one. It returns false if none could be dropped.
+func (queue *CallEventQueue) dropOldest() bool {
+	for i := 0; i < len(queue.events)-1; i++ {
+		if !queue.events[i].ends() {
+			queue.remove(i, i+1)
+			queue.countDropped()
+			return true
+		}
+	}
+	return false
+}
+
+// coalesce removes the oldest redundant radio button events: a press and a
+// release following each other, which leave the button as it was, or an event
+// repeated by the next one. A press and a release that are the last events are
+// kept, the call gets the state last reported with its CRD. It returns false
+// if there are none.
+func (queue *CallEventQueue) coalesce() bool {
+	for i := 0; i+1 < len(queue.events); i++ {
+		on, ok := queue.events[i].buttonOn()
+		nextOn, nextOk := queue.events[i+1].buttonOn()
+		if !ok || !nextOk {
+			continue
+		}
+		if on == nextOn {
+			queue.remove(i, i+1)
+			queue.countCoalesced(1)
+			return true
+		}
+		if i+2 < len(queue.events) {
+			queue.remove(i, i+2)
+			queue.countCoalesced(2)
+			return true
+		}
+	}
+	return false
+}
+
+type EventQueueSnapshot struct {
+	Queued    int    `json:"queued"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+func (queue *CallEventQueue) snapshot() EventQueueSnapshot {
+	if queue == nil {
+		return EventQueueSnapshot{}
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return EventQueueSnapshot{
+		Queued:    len(queue.events),
+		Dropped:   queue.dropped,
+		Coalesced: queue.coalesced,
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+func TestPushResult(t *testing.T) {
+	press := queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(constant.TX_BUTTON_ON)}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		name   string
+		policy constant.QueuePolicy
+		full   bool
+		ended  bool
+		ctx    context.Context
+		want   constant.EventResult
+	}{
+		{"block, room left", constant.QUEUE_BLOCK, false, false, context.Background(), constant.EVENT_ACCEPTED},
+		{"block, full until ctx is done", constant.QUEUE_BLOCK, true, false, cancelled, constant.EVENT_QUEUE_FULL},
+		{"block, call ended", constant.QUEUE_BLOCK, false, true, context.Background(), constant.EVENT_DROPPED_BLOCKED},
+		{"drop oldest, call ended", constant.QUEUE_DROP_OLDEST, false, true, context.Background(), constant.EVENT_DROPPED_BLOCKED},
+		{"coalesce, call ended", constant.QUEUE_COALESCE, false, true, context.Background(), constant.EVENT_DROPPED_BLOCKED},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			done := make(chan bool)
+			queue := NewCallEventQueue(1, tc.policy, done, &eventCounts{})
+			if tc.full {
+				queue.push(context.Background(), press)
+			}
+			if tc.ended {
+				close(done)
+			}
+			if got := queue.push(tc.ctx, press); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// tagged returns event with a CRD attribute telling it apart.
+func tagged(event queuedEvent, tag string) queuedEvent {
+	event.crd = []models.CRDField{{Id: constant.CALLING_NR_ID, Value: tag}}
+	return event
+}
+
+// carrying returns event with the CRD attributes of tags, in order.
+func carrying(event queuedEvent, tags ...string) queuedEvent {
+	event.crd = nil
+	for _, tag := range tags {
+		event.crd = append(event.crd, models.CRDField{Id: constant.CALLING_NR_ID, Value: tag})
+	}
+	return event
+}
+
+func TestQueuePolicy(t *testing.T) {
+	press := queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(constant.TX_BUTTON_ON)}
+	release := queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(constant.TX_BUTTON_OFF)}
+	media := queuedEvent{event: constant.CALL_MEDIA_STATE_EVENT, state: int(constant.PJSUA_CALL_MEDIA_ACTIVE)}
+	hangUp := queuedEvent{event: constant.CALL_STATE_EVENT, state: int(constant.PJSIP_INV_STATE_DISCONNECTED)}
+	invalid := queuedEvent{event: constant.RADIO_STATE_EVENT, state: int(constant.BUTTON_INVALID)}
+	for _, tc := range []struct {
+		name          string
+		policy        constant.QueuePolicy
+		size          int
+		pushed        []queuedEvent
+		want          []queuedEvent
+		wantLast      constant.EventResult
+		wantDropped   uint64
+		wantCoalesced uint64
+	}{
+		{
+			"drop oldest, room left", constant.QUEUE_DROP_OLDEST, 3,
+			[]queuedEvent{tagged(press, "a"), tagged(release, "b")},
+			[]queuedEvent{tagged(press, "a"), tagged(release, "b")},
+			constant.EVENT_ACCEPTED, 0, 0,
+		},
+		{
+			"drop oldest carries the CRD to the next event", constant.QUEUE_DROP_OLDEST, 2,
+			[]queuedEvent{tagged(press, "a"), tagged(release, "b"), tagged(press, "c")},
+			[]queuedEvent{carrying(release, "a", "b"), tagged(press, "c")},
+			constant.EVENT_ACCEPTED, 1, 0,
+		},
+		{
+			"drop oldest skips the events ending the call", constant.QUEUE_DROP_OLDEST, 2,
+			[]queuedEvent{tagged(invalid, "a"), tagged(media, "b"), tagged(hangUp, "c")},
+			[]queuedEvent{tagged(invalid, "a"), carrying(hangUp, "b", "c")},
+			constant.EVENT_ACCEPTED, 1, 0,
+		},
+		{
+			"drop oldest rejects the event once only ending events are left", constant.QUEUE_DROP_OLDEST, 2,
+			[]queuedEvent{tagged(invalid, "a"), tagged(hangUp, "b"), tagged(press, "c")},
+			[]queuedEvent{tagged(invalid, "a"), tagged(hangUp, "b")},
+			constant.EVENT_QUEUE_FULL, 1, 0,
+		},
+		{
+			"coalesce removes a press and a release as one", constant.QUEUE_COALESCE, 2,
+			[]queuedEvent{tagged(press, "a"), tagged(release, "b"), tagged(press, "c")},
+			[]queuedEvent{carrying(press, "a", "b", "c")},
+			constant.EVENT_ACCEPTED, 0, 2,
+		},
+		{
+			"coalesce removes a repeated event", constant.QUEUE_COALESCE, 2,
+			[]queuedEvent{tagged(press, "a"), tagged(press, "b"), tagged(release, "c")},
+			[]queuedEvent{carrying(press, "a", "b"), tagged(release, "c")},
+			constant.EVENT_ACCEPTED, 0, 1,
+		},
+		{
+			"coalesce keeps the last press and release", constant.QUEUE_COALESCE, 1,
+			[]queuedEvent{tagged(press, "a"), tagged(release, "b")},
+			[]queuedEvent{tagged(press, "a")},
+			constant.EVENT_QUEUE_FULL, 1, 0,
+		},
+		{
+			"coalesce rejects an event once it cannot make room", constant.QUEUE_COALESCE, 2,
+			[]queuedEvent{tagged(media, "a"), tagged(media, "b"), tagged(press, "c")},
+			[]queuedEvent{tagged(media, "a"), tagged(media, "b")},
+			constant.EVENT_QUEUE_FULL, 1, 0,
+		},
+		{
+			"coalesce drops the oldest for an event ending the call", constant.QUEUE_COALESCE, 2,
+			[]queuedEvent{tagged(media, "a"), tagged(media, "b"), tagged(hangUp, "c")},
+			[]queuedEvent{carrying(media, "a", "b"), tagged(hangUp, "c")},
+			constant.EVENT_ACCEPTED, 1, 0,
+		},
+		{
+			"coalesce before dropping", constant.QUEUE_COALESCE, 3,
+			[]queuedEvent{tagged(media, "a"), tagged(press, "b"), tagged(release, "c"), tagged(invalid, "d")},
+			[]queuedEvent{tagged(media, "a"), carrying(invalid, "b", "c", "d")},
+			constant.EVENT_ACCEPTED, 0, 2,
+		},
+		{
+			"block rejects an event once the context is done", constant.QUEUE_BLOCK, 1,
+			[]queuedEvent{tagged(media, "a"), tagged(press, "b")},
+			[]queuedEvent{tagged(media, "a")},
+			constant.EVENT_QUEUE_FULL, 0, 0,
+		},
+		{
+			"block does not wait with an event ending the call", constant.QUEUE_BLOCK, 1,
+			[]queuedEvent{tagged(media, "a"), tagged(hangUp, "b")},
+			[]queuedEvent{tagged(media, "a"), tagged(hangUp, "b")},
+			constant.EVENT_ACCEPTED, 0, 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			totals := &eventCounts{}
+			queue := NewCallEventQueue(tc.size, tc.policy, make(chan bool), totals)
+			// Only the block policy waits, on a full queue, until the
+			// context is done
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			var last constant.EventResult
+			for _, event := range tc.pushed {
+				last = queue.push(ctx, event)
+			}
+			if last != tc.wantLast {
+				t.Errorf("last push got %s, want %s", last, tc.wantLast)
+			}
+			if !reflect.DeepEqual(queue.events, tc.want) {
+				t.Errorf("events got %+v, want %+v", queue.events, tc.want)
+			}
+			if queue.dropped != tc.wantDropped || totals.dropped.Load() != tc.wantDropped {
+				t.Errorf("dropped got %d (total %d), want %d", queue.dropped, totals.dropped.Load(), tc.wantDropped)
+			}
+			if queue.coalesced != tc.wantCoalesced || totals.coalesced.Load() != tc.wantCoalesced {
+				t.Errorf("coalesced got %d (total %d), want %d", queue.coalesced, totals.coalesced.Load(), tc.wantCoalesced)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/utils"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+)
+
+const (
+	healthProbeInterval = 10 * time.Second
+	healthProbeTimeout  = 3 * time.Second
+)
+
+// RecorderHealth keeps the recorders of the failover groups whose session
+// failed. They are probed periodically and are up again once they accept a
+// TCP connection, so the new calls go back to them.
+type RecorderHealth struct {
+	mutex *sync.Mutex
+	// Addresses of the recorders down, with the time they went down
+	down        map[string]time.Time
+	dialContext func(ctx context.Context, network string, addr string) (net.Conn, error)
+	chStop      chan struct{}
+	stopOnce    *sync.Once
+	utils.Logger
+}
+
+func NewRecorderHealth(dialContext func(ctx context.Context, network string, addr string) (net.Conn, error), logger utils.Logger) *RecorderHealth {
+	return &RecorderHealth{
+		mutex:       &sync.Mutex{},
+		down:        make(map[string]time.Time),
+		dialContext: dialContext,
+		chStop:      make(chan struct{}),
+		stopOnce:    &sync.Once{},
+		Logger:      logger,
+	}
+}
+
+func (health *RecorderHealth) markDown(recAddr string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if _, ok := health.down[recAddr]; !ok {
+		health.down[recAddr] = time.Now()
+		health.LogWarn("Recorder is down", "recorder", recAddr)
+	}
+}
+
+func (health *RecorderHealth) markUp(recAddr string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if since, ok := health.down[recAddr]; ok {
+		delete(health.down, recAddr)
+		health.LogInfo("Recorder is up again", "recorder", recAddr, "down", time.Since(since).Round(time.Second))
+	}
+}
+
+func (health *RecorderHealth) isUp(recAddr string) bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	_, ok := health.down[recAddr]
+	return !ok
+}
+
+// probe tries to connect to each recorder down.
+func (health *RecorderHealth) probe() {
+	health.mutex.Lock()
+	recAddrs := []string{}
+	for recAddr := range health.down {
+		recAddrs = append(recAddrs, recAddr)
+	}
+	health.mutex.Unlock()
+
+	for _, recAddr := range recAddrs {
+		ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+		conn, err := health.dialContext(ctx, "tcp", recAddr)
+		cancel()
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		health.markUp(recAddr)
+	}
+}
+
+func (health *RecorderHealth) run() {
+	ticker := time.NewTicker(healthProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			health.probe()
+		case <-health.chStop:
+			return
+		}
+	}
+}
+
+func (health *RecorderHealth) stop() {
+	health.stopOnce.Do(func() {
+		close(health.chStop)
+	})
+}
+
+// CallFailover is the recorder each failover group records a call on.
+type CallFailover struct {
+	mutex *sync.Mutex
+	// Address of the recorder of each group, chosen when the call first
+	// uses the group
+	active map[string]string
+	// Addresses of the recorders that failed during the call
+	failed map[string]bool
+	// State the last event drives the sessions of the call to, which a
+	// standby catches up with
+	target constant.RTSPState
+}
+
+func NewCallFailover() *CallFailover {
+	return &CallFailover{
+		mutex:  &sync.Mutex{},
+		active: make(map[string]string),
+		failed: make(map[string]bool),
+		target: constant.RTSP_STATE_NULL,
+	}
+}
+
+func (failover *CallFailover) getTarget() constant.RTSPState {
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	return failover.target
+}
+
+func (failover *CallFailover) setTarget(target constant.RTSPState) {
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	failover.target = target
+}
+
+// The *Target functions give the state an event drives the sessions of a call
+// to, target being the one of the previous events.
+
+func callStateTarget(callState constant.CallState, target constant.RTSPState) constant.RTSPState {
+	switch callState {
+	case constant.PJSIP_INV_STATE_CALLING, constant.PJSIP_INV_STATE_INCOMING:
+		return constant.RTSP_STATE_SETUP
+	case constant.PJSIP_INV_STATE_CONFIRMED:
+		return constant.RTSP_STATE_RECORD
+	case constant.PJSIP_INV_STATE_DISCONNECTED:
+		return constant.RTSP_STATE_NULL
+	}
+	return target
+}
+
+func callMediaStateTarget(mediaState constant.CallMediaState, target constant.RTSPState) constant.RTSPState {
+	switch mediaState {
+	case constant.PJSUA_CALL_MEDIA_LOCAL_HOLD, constant.PJSUA_CALL_MEDIA_REMOTE_HOLD:
+		if target == constant.RTSP_STATE_RECORD {
+			return constant.RTSP_STATE_PAUSE
+		}
+	case constant.PJSUA_CALL_MEDIA_ACTIVE:
+		if target == constant.RTSP_STATE_PAUSE {
+			return constant.RTSP_STATE_RECORD
+		}
+	}
+	return target
+}
+
+func radioStateTarget(radioButtonState constant.RadioButtonState, target constant.RTSPState) constant.RTSPState {
+	switch radioButtonState {
+	case constant.TX_BUTTON_ON, constant.RX_BUTTON_ON:
+		return constant.RTSP_STATE_RECORD
+	case constant.TX_BUTTON_OFF, constant.RX_BUTTON_OFF:
+		return constant.RTSP_STATE_SETUP
+	case constant.BUTTON_INVALID:
+		return constant.RTSP_STATE_NULL
+	}
+	return target
+}
+
+func briefStateTarget(briefState constant.BriefState) constant.RTSPState {
+	if briefState == constant.BRIEF_TRUE {
+		return constant.RTSP_STATE_RECORD
+	}
+	return constant.RTSP_STATE_NULL
+}
+
+func groupStateTarget(groupState constant.GroupState) constant.RTSPState {
+	if groupState == constant.GROUP_TRUE {
+		return constant.RTSP_STATE_RECORD
+	}
+	return constant.RTSP_STATE_NULL
+}
+
+// failoverChs returns the channels of the failover group, primary first.
+func (cfg *Config) failoverChs(group string) []int {
+	chs := []int{}
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		if cfg.failoverGroups[ch] == group {
+			chs = append(chs, ch)
+		}
+	}
+	sort.SliceStable(chs, func(i, j int) bool {
+		return cfg.failoverPriorities[chs[i]] < cfg.failoverPriorities[chs[j]]
+	})
+	return chs
+}
+
+// nextRecorder returns the address of the first recorder of chs in cfg that may
+// record the calls of recorderType and has not failed, preferring those that
+// are up. It returns "" if there is none.
+func (rtspClient *RTSPClient) nextRecorder(cfg *Config, chs []int, recorderType constant.RecorderType, failed map[string]bool) string {
+	fallback := ""
+	for _, ch := range chs {
+		recAddr := cfg.recAddrs[ch]
+		if failed[recAddr] || !cfg.recordsType(ch, recorderType) {
+			continue
+		}
+		if rtspClient.health.isUp(recAddr) {
+			return recAddr
+		}
+		if fallback == "" {
+			fallback = recAddr
+		}
+	}
+	return fallback
+}
+
+// failoverActive tells whether ch is the recorder its failover group records
+// the call on. It is always true outside of the failover groups.
+func (callInfo CallInfo) failoverActive(ch int) bool {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	group := cfg.failoverGroups[ch]
+	if group == "" {
+		return true
+	}
+	chs := cfg.failoverChs(group)
+	failover := callInfo.failover
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	recAddr, ok := failover.active[group]
+	if ok && recAddr != "" {
+		// The recorder may have left the group in a reload
+		found := false
+		for _, groupCh := range chs {
+			found = found || cfg.recAddrs[groupCh] == recAddr
+		}
+		ok = found
+	}
+	if !ok {
+		recAddr = rtspClient.nextRecorder(cfg, chs, callInfo.RecorderType, failover.failed)
+		failover.active[group] = recAddr
+	}
+	return recAddr == cfg.recAddrs[ch]
+}
+
+// watchSession waits for the session to end and reports it to the call if it
+// was not closed on purpose.
+func (c Client) watchSession() {
+	err := c.client.Wait()
+	var terminated liberrors.ErrClientTerminated
+	if errors.As(err, &terminated) {
+		return
+	}
+	c.rtspClient.LogWarn("Recorder session lost", "name", c.Name, "recorderType", int(c.RecorderType), "err", err)
+	c.rtspClient.reportLostSession(c.CallKey, c.client)
+}
+
+// doFailover moves the call to the next standby of the failover group of
+// channel j, whose session failed. The standby catches up with the state of the
+// call, with the connref and times of the CRD sent to the failed recorder. The
+// failed recorder is reconnected if the group has no standby left.
+func (callInfo *CallInfo) doFailover(j int) {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	group := cfg.failoverGroups[j]
+	recAddr := cfg.recAddrs[j]
+	failover := callInfo.failover
+	failover.mutex.Lock()
+	if failover.active[group] != recAddr {
+		// Already moved away from the recorder
+		failover.mutex.Unlock()
+		return
+	}
+	reconnecting := failover.failed[recAddr]
+	failover.failed[recAddr] = true
+	next := rtspClient.nextRecorder(cfg, cfg.failoverChs(group), callInfo.RecorderType, failover.failed)
+	if next != "" {
+		failover.active[group] = next
+	}
+	target := failover.target
+	failover.mutex.Unlock()
+
+	rtspClient.health.markDown(recAddr)
+	if next == "" {
+		if reconnecting {
+			callInfo.scheduleReconnect(j)
+			return
+		}
+		rtspClient.LogWarn("Recorder failed and its failover group has no standby left, reconnecting", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "recorder", recAddr, "group", group)
+		callInfo.scheduleReconnect(j)
+		return
+	}
+	rtspClient.LogInfo("Recorder failed, moving the call to its standby", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "recorder", recAddr, "standby", next)
+	if target == constant.RTSP_STATE_NULL {
+		return
+	}
+	k := -1
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		if cfg.recAddrs[ch] == next {
+			k = ch
+		}
+	}
+	callInfo.joinChannel(k, target, resumeCRD(callInfo.getCRD(j), callInfo.crdHistory, cfg.ed137Versions[k], callInfo.RecorderType))
+}
+
+// resumeCRD returns the CRD of a new session continuing the one of from: the
+// CRD of the call with the connref, setup and connect times of from.
+func resumeCRD(from CRD, crdHistory *CRDHistory, ed137Version string, recorderType constant.RecorderType) CRD {
+	crd := CRD{}
+	crd.SetCRDInner(crdHistory.get(), ed137Version, recorderType)
+	if from.Value != "" {
+		crd.Value = from.Value
+	}
+	if from.Properties.SetupTime.Value != "" {
+		crd.Properties.SetupTime.Value = from.Properties.SetupTime.Value
+	}
+	if from.Properties.ConnectTime.Value != "" {
+		crd.Properties.ConnectTime.Value = from.Properties.ConnectTime.Value
+	}
+	return crd
+}
+
+// checkFailoverGroups warns about the failover groups without standby and
+// those whose recorders do not record the same calls.
+func checkFailoverGroups(recorders []RecorderCfg) CfgIssues {
+	var issues CfgIssues
+	groups := make(map[string][]RecorderCfg)
+	names := []string{}
+	for _, recorderCfg := range recorders {
+		if !recorderCfg.Enable || recorderCfg.FailoverGroup == "" {
+			continue
+		}
+		if _, ok := groups[recorderCfg.FailoverGroup]; !ok {
+			names = append(names, recorderCfg.FailoverGroup)
+		}
+		groups[recorderCfg.FailoverGroup] = append(groups[recorderCfg.FailoverGroup], recorderCfg)
+	}
+	for _, name := range names {
+		members := groups[name]
+		primary := members[0]
+		if len(members) == 1 {
+			issues = append(issues, CfgIssue{Severity: constant.CFG_WARNING, Type: constant.CFG_INVALID_VALUE, File: primary.file, Line: primary.line, Recorder: primary.index, Path: "failover_group",
+				Msg: fmt.Sprintf("failover group %q has no standby", name)})
+			continue
+		}
+		primaryFilter := RecorderFilter{}
+		if primary.Filter != nil {
+			primaryFilter = *primary.Filter
+		}
+		for _, standby := range members[1:] {
+			filter := RecorderFilter{}
+			if standby.Filter != nil {
+				filter = *standby.Filter
+			}
+			if standby.RecGroup != primary.RecGroup || filter.String() != primaryFilter.String() {
+				issues = append(issues, CfgIssue{Severity: constant.CFG_WARNING, Type: constant.CFG_INVALID_VALUE, File: standby.file, Line: standby.line, Recorder: standby.index, Path: "failover_group",
+					Msg: fmt.Sprintf("rec_group or filter differs from the primary of failover group %q at %s:%d, some calls can not fail over", name, primary.file, primary.line)})
+			}
+		}
+	}
+	return issues
+}
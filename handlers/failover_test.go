@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+// failoverRecJSON has the group a of 10.0.0.1, 10.0.0.3, 10.0.0.6 and 10.0.0.5,
+// in this order, and the group b of 10.0.0.4. 10.0.0.5 does not record the
+// phone calls.
+const failoverRecJSON = `{"recorders": [
+	{"address": "10.0.0.1", "failover_group": "a"},
+	{"address": "10.0.0.2"},
+	{"address": "10.0.0.3", "failover_group": "a"},
+	{"address": "10.0.0.4", "failover_group": "b"},
+	{"address": "10.0.0.6", "failover_group": "a"},
+	{"address": "10.0.0.5", "failover_group": "a", "filter": {"recorder_types": ["radio_tx"]}}
+]}`
+
+func TestFailoverChs(t *testing.T) {
+	cfg := testCfg(t, failoverRecJSON)
+	if want := []int{0, 0, 1, 0, 2, 3}; !reflect.DeepEqual(cfg.failoverPriorities, want) {
+		t.Fatalf("got ranks %v, want %v", cfg.failoverPriorities, want)
+	}
+	for _, tc := range []struct {
+		group string
+		want  []int
+	}{
+		{"a", []int{0, 2, 4, 5}},
+		{"b", []int{3}},
+		{"c", []int{}},
+	} {
+		if got := cfg.failoverChs(tc.group); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("group %s: got channels %v, want %v", tc.group, got, tc.want)
+		}
+	}
+}
+
+func TestNextRecorder(t *testing.T) {
+	cfg := testCfg(t, failoverRecJSON)
+	chs := cfg.failoverChs("a")
+	for _, tc := range []struct {
+		name         string
+		recorderType constant.RecorderType
+		down         []string
+		failed       []string
+		want         string
+	}{
+		{"primary first", constant.RET_PHONE, nil, nil, "10.0.0.1:8554"},
+		{"primary down", constant.RET_PHONE, []string{"10.0.0.1:8554"}, nil, "10.0.0.3:8554"},
+		{"primary failed", constant.RET_PHONE, nil, []string{"10.0.0.1:8554"}, "10.0.0.3:8554"},
+		{"standby down", constant.RET_PHONE, []string{"10.0.0.3:8554"}, []string{"10.0.0.1:8554"}, "10.0.0.6:8554"},
+		{"every recorder down", constant.RET_PHONE, []string{"10.0.0.1:8554", "10.0.0.3:8554", "10.0.0.6:8554"}, nil, "10.0.0.1:8554"},
+		{"every recorder down, primary failed", constant.RET_PHONE, []string{"10.0.0.1:8554", "10.0.0.3:8554", "10.0.0.6:8554"}, []string{"10.0.0.1:8554"}, "10.0.0.3:8554"},
+		{"every recorder failed", constant.RET_PHONE, nil, []string{"10.0.0.1:8554", "10.0.0.3:8554", "10.0.0.6:8554"}, ""},
+		{"recorder type of the last standby only", constant.RET_RADIO_TX, nil, []string{"10.0.0.1:8554", "10.0.0.3:8554", "10.0.0.6:8554"}, "10.0.0.5:8554"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rtspClient := newTestRTSPClient(t)
+			for _, recAddr := range tc.down {
+				rtspClient.health.markDown(recAddr)
+			}
+			failed := make(map[string]bool)
+			for _, recAddr := range tc.failed {
+				failed[recAddr] = true
+			}
+			if got := rtspClient.nextRecorder(cfg, chs, tc.recorderType, failed); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecorderHealth(t *testing.T) {
+	rtspClient := newTestRTSPClient(t)
+	health := rtspClient.health
+	health.markDown("10.0.0.1:8554")
+	health.markDown("10.0.0.1:8554")
+	if health.isUp("10.0.0.1:8554") || !health.isUp("10.0.0.3:8554") {
+		t.Fatal("only 10.0.0.1 should be down")
+	}
+	health.markUp("10.0.0.1:8554")
+	if !health.isUp("10.0.0.1:8554") {
+		t.Fatal("10.0.0.1 still down")
+	}
+}
+
+func TestDoFailover(t *testing.T) {
+	rtspClient := newTestRTSPClient(t)
+	rtspClient.Config = testCfg(t, failoverRecJSON)
+	callInfo := rtspClient.newCallInfo(CallKey{Name: "1001", RecorderType: constant.RET_PHONE})
+	t.Cleanup(callInfo.stop)
+	// Active channel of the group a after each failure of the active one,
+	// reconnected once the group has no standby left
+	for _, want := range []int{0, 2, 4, 4} {
+		for _, ch := range []int{0, 2, 4, 5} {
+			if got := callInfo.failoverActive(ch); got != (ch == want) {
+				t.Fatalf("channel %d active %v, want channel %d only", ch, got, want)
+			}
+		}
+		if !callInfo.failoverActive(1) {
+			t.Fatal("channel outside of the groups not active")
+		}
+		// The call reached no state yet, no session is started on the standby
+		callInfo.doFailover(want)
+		if rtspClient.health.isUp(rtspClient.Config.recAddrs[want]) {
+			t.Fatalf("failed recorder %s not down", rtspClient.Config.recAddrs[want])
+		}
+	}
+	// A recorder the call moved away from does not move it again
+	callInfo.failover.mutex.Lock()
+	callInfo.failover.active["a"] = "10.0.0.3:8554"
+	callInfo.failover.mutex.Unlock()
+	callInfo.doFailover(0)
+	if !callInfo.failoverActive(2) {
+		t.Fatal("failure of a recorder left moved the call")
+	}
+}
+
+func TestResumeCRD(t *testing.T) {
+	crdHistory := NewCRDHistory()
+	crdHistory.add([]models.CRDField{
+		{Id: constant.CALLING_NR_ID, Value: "1001"},
+		{Id: constant.SETUP_TIME_ID, Value: "t2"},
+		{Id: constant.CONNECT_TIME_ID, Value: "t3"},
+	})
+	from := CRD{Value: "ref1"}
+	from.Properties.SetupTime.Value = "t0"
+	from.Properties.ConnectTime.Value = "t1"
+
+	crd := resumeCRD(from, crdHistory, "ED137B", constant.RET_PHONE)
+	if crd.Value != "ref1" || crd.Properties.SetupTime.Value != "t0" || crd.Properties.ConnectTime.Value != "t1" {
+		t.Fatalf("got connref %s, setup time %s and connect time %s, want ref1, t0 and t1", crd.Value, crd.Properties.SetupTime.Value, crd.Properties.ConnectTime.Value)
+	}
+	if crd.Properties.CallingNr.Value != "1001" {
+		t.Fatalf("got calling number %s, want the one of the call", crd.Properties.CallingNr.Value)
+	}
+
+	// Without a session before, the CRD is the one of the call
+	crd = resumeCRD(CRD{}, crdHistory, "ED137B", constant.RET_PHONE)
+	if crd.Value == "" || crd.Properties.SetupTime.Value != "t2" || crd.Properties.ConnectTime.Value != "t3" {
+		t.Fatalf("got connref %q, setup time %s and connect time %s, want a new connref, t2 and t3", crd.Value, crd.Properties.SetupTime.Value, crd.Properties.ConnectTime.Value)
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+// RecorderFilter selects the calls a recorder records. A call must match
+// every list that is not empty, and one value of each of them. The numbers
+// and VCS users are glob patterns, see path.Match.
+type RecorderFilter struct {
+	// Lower case names of constant.RecorderType, e.g. phone or radio_tx
+	RecorderTypes  []string `json:"recorder_types,omitempty"`
+	CallingNumbers []string `json:"calling_numbers,omitempty"`
+	CalledNumbers  []string `json:"called_numbers,omitempty"`
+	VCSUsers       []string `json:"vcs_users,omitempty"`
+}
+
+func (filter RecorderFilter) isEmpty() bool {
+	return len(filter.RecorderTypes) == 0 && len(filter.CallingNumbers) == 0 && len(filter.CalledNumbers) == 0 && len(filter.VCSUsers) == 0
+}
+
+func (filter RecorderFilter) String() string {
+	if filter.isEmpty() {
+		return "all calls"
+	}
+	var strs []string
+	add := func(name string, values []string) {
+		if len(values) != 0 {
+			strs = append(strs, name+" "+strings.Join(values, "|"))
+		}
+	}
+	add("types", filter.RecorderTypes)
+	add("calling", filter.CallingNumbers)
+	add("called", filter.CalledNumbers)
+	add("vcs users", filter.VCSUsers)
+	return strings.Join(strs, ", ")
+}
+
+// matchesType tells whether the filter lets the calls of recorderType through,
+// whatever their numbers.
+func (filter RecorderFilter) matchesType(recorderType constant.RecorderType) bool {
+	if len(filter.RecorderTypes) == 0 {
+		return true
+	}
+	for _, name := range filter.RecorderTypes {
+		if strings.EqualFold(name, recorderType.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPatterns(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches tells whether the filter lets through a call of recorderType with
+// the CRD values received so far.
+func (filter RecorderFilter) matches(recorderType constant.RecorderType, crdHistory *CRDHistory) bool {
+	return filter.matchesType(recorderType) &&
+		matchPatterns(filter.CallingNumbers, crdHistory.value(constant.CALLING_NR_ID)) &&
+		matchPatterns(filter.CalledNumbers, crdHistory.value(constant.CALLED_NR_ID)) &&
+		matchPatterns(filter.VCSUsers, crdHistory.value(constant.VCS_USER_ID))
+}
+
+// recordsType tells whether the recorder channel ch may record the calls of
+// recorderType. The group calls only go to the rec_group recorders, the
+// ambient ones to every recorder and the others to the non-group recorders.
+func (cfg *Config) recordsType(ch int, recorderType constant.RecorderType) bool {
+	switch recorderType {
+	case constant.RET_AMBIENT:
+	case constant.RET_PHONE_GROUP, constant.RET_RADIO_GROUP, constant.RET_BRIEF_GROUP:
+		if !cfg.recGroups[ch] {
+			return false
+		}
+	default:
+		if cfg.recGroups[ch] {
+			return false
+		}
+	}
+	return cfg.filters[ch].matchesType(recorderType)
+}
+
+// recordsAnyType tells whether a recorder channel may record the calls of
+// recorderType.
+func (cfg *Config) recordsAnyType(recorderType constant.RecorderType) bool {
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		if cfg.recordsType(ch, recorderType) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesChannel tells whether the recorder channel ch records this call. A
+// channel whose session is started keeps recording the call even if a later
+// CRD value does not match its filter anymore. In a failover group, only the
+// recorder chosen for the call records it.
+func (callInfo CallInfo) usesChannel(ch int) bool {
+	cfg := callInfo.config()
+	if !cfg.recordsType(ch, callInfo.RecorderType) {
+		return false
+	}
+	if c := callInfo.getClientIfExist(ch); c.rtspState != constant.RTSP_STATE_NULL && c.rtspState != constant.RTSP_STATE_DISCONNECT {
+		return true
+	}
+	if !callInfo.failoverActive(ch) {
+		return false
+	}
+	return cfg.filters[ch].matches(callInfo.RecorderType, callInfo.crdHistory)
+}
+
+// checkFilter warns about the recorder types of the filter that the
+// rec_group setting of the recorder never lets through.
+func (recorderCfg RecorderCfg) checkFilter(key string) CfgIssues {
+	var issues CfgIssues
+	if recorderCfg.Filter == nil {
+		return nil
+	}
+	for _, name := range recorderCfg.Filter.RecorderTypes {
+		group := strings.HasSuffix(name, "_group")
+		if name == "ambient" || group == recorderCfg.RecGroup {
+			continue
+		}
+		msg := fmt.Sprintf("%s calls only go to the recorders with rec_group set", name)
+		if !group {
+			msg = fmt.Sprintf("%s calls never go to the recorders with rec_group set", name)
+		}
+		issues = append(issues, CfgIssue{Severity: constant.CFG_WARNING, Type: constant.CFG_INVALID_VALUE, File: recorderCfg.file, Line: recorderCfg.line, Recorder: recorderCfg.index, Path: key, Msg: msg})
+	}
+	return issues
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+)
+
+func TestFilterMatches(t *testing.T) {
+	crd := []models.CRDField{
+		{Id: constant.CALLING_NR_ID, Value: "sip:1001@10.0.0.9"},
+		{Id: constant.CALLED_NR_ID, Value: "sip:2002@10.0.0.9"},
+		{Id: constant.VCS_USER_ID, Value: "cwp12"},
+	}
+	for _, tc := range []struct {
+		name         string
+		filter       RecorderFilter
+		recorderType constant.RecorderType
+		want         bool
+	}{
+		{"empty", RecorderFilter{}, constant.RET_PHONE, true},
+		{"recorder type", RecorderFilter{RecorderTypes: []string{"radio_tx", "phone"}}, constant.RET_PHONE, true},
+		{"other recorder type", RecorderFilter{RecorderTypes: []string{"radio_tx"}}, constant.RET_PHONE, false},
+		{"calling number glob", RecorderFilter{CallingNumbers: []string{"sip:10*"}}, constant.RET_PHONE, true},
+		{"calling number other glob", RecorderFilter{CallingNumbers: []string{"sip:20*"}}, constant.RET_PHONE, false},
+		{"one of the patterns", RecorderFilter{CallingNumbers: []string{"sip:20*", "sip:1001@*"}}, constant.RET_PHONE, true},
+		{"called number single character", RecorderFilter{CalledNumbers: []string{"sip:200?@10.0.0.9"}}, constant.RET_PHONE, true},
+		{"called number character class", RecorderFilter{CalledNumbers: []string{"sip:[13]*"}}, constant.RET_PHONE, false},
+		{"VCS user glob", RecorderFilter{VCSUsers: []string{"cwp1*"}}, constant.RET_PHONE, true},
+		{"other VCS user", RecorderFilter{VCSUsers: []string{"cwp2*"}}, constant.RET_PHONE, false},
+		{"every list matching", RecorderFilter{RecorderTypes: []string{"phone"}, CallingNumbers: []string{"*1001*"}, VCSUsers: []string{"cwp12"}}, constant.RET_PHONE, true},
+		{"one list not matching", RecorderFilter{RecorderTypes: []string{"phone"}, CallingNumbers: []string{"*1001*"}, VCSUsers: []string{"cwp3"}}, constant.RET_PHONE, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			crdHistory := NewCRDHistory()
+			crdHistory.add(crd)
+			if got := tc.filter.matches(tc.recorderType, crdHistory); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecordsType(t *testing.T) {
+	cfg := testCfg(t, `{"recorders": [
+		{"address": "10.0.0.1"},
+		{"address": "10.0.0.2", "rec_group": true},
+		{"address": "10.0.0.3", "filter": {"recorder_types": ["radio_tx", "ambient"]}},
+		{"address": "10.0.0.4", "rec_group": true, "filter": {"recorder_types": ["radio_group"]}}
+	]}`)
+	for _, tc := range []struct {
+		recorderType constant.RecorderType
+		// By channel
+		want []bool
+	}{
+		{constant.RET_PHONE, []bool{true, false, false, false}},
+		{constant.RET_RADIO_TX, []bool{true, false, true, false}},
+		{constant.RET_AMBIENT, []bool{true, true, true, false}},
+		{constant.RET_PHONE_GROUP, []bool{false, true, false, false}},
+		{constant.RET_RADIO_GROUP, []bool{false, true, false, true}},
+	} {
+		t.Run(tc.recorderType.String(), func(t *testing.T) {
+			for ch, want := range tc.want {
+				if got := cfg.recordsType(ch, tc.recorderType); got != want {
+					t.Errorf("channel %d: got %v, want %v", ch, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		recGroup      bool
+		recorderTypes []string
+		// Number of warnings
+		want int
+	}{
+		{"no filter", false, nil, 0},
+		{"non-group types", false, []string{"phone", "radio_tx"}, 0},
+		{"group types on a group recorder", true, []string{"phone_group", "radio_group"}, 0},
+		{"ambient on both", false, []string{"ambient"}, 0},
+		{"ambient on a group recorder", true, []string{"ambient"}, 0},
+		{"group type on a non-group recorder", false, []string{"phone", "phone_group"}, 1},
+		{"non-group types on a group recorder", true, []string{"phone", "radio_rx", "radio_group"}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorderCfg := RecorderCfg{RecGroup: tc.recGroup, file: "rec.json", line: 3, index: 1}
+			if tc.recorderTypes != nil {
+				recorderCfg.Filter = &RecorderFilter{RecorderTypes: tc.recorderTypes}
+			}
+			issues := recorderCfg.checkFilter("filter")
+			if len(issues) != tc.want {
+				t.Fatalf("got warnings %v, want %d", issues, tc.want)
+			}
+			for _, issue := range issues {
+				if issue.Severity != constant.CFG_WARNING || issue.Line != 3 || issue.Recorder != 1 || issue.Path != "filter" {
+					t.Errorf("issue %v does not point at the filter", issue)
+				}
+			}
+		})
+	}
+}
+
+func TestStartedSessionKeepsRecording(t *testing.T) {
+	rtspClient := newTestRTSPClient(t)
+	rtspClient.Config = testCfg(t, `{"recorders": [{"address": "10.0.0.1", "filter": {"calling_numbers": ["1001"]}}]}`)
+	callInfo := rtspClient.newCallInfo(CallKey{Name: "1001", RecorderType: constant.RET_PHONE})
+	t.Cleanup(callInfo.stop)
+
+	callInfo.crdHistory.add([]models.CRDField{{Id: constant.CALLING_NR_ID, Value: "1001"}})
+	if !callInfo.usesChannel(0) {
+		t.Fatal("matching call not recorded")
+	}
+	callInfo.crdHistory.add([]models.CRDField{{Id: constant.CALLING_NR_ID, Value: "1002"}})
+	if callInfo.usesChannel(0) {
+		t.Fatal("call no longer matching recorded before its session started")
+	}
+	key := ClientKey{CallKey: callInfo.CallKey, ch: 0}
+	rtspClient.cs.listClient.Set(key, Client{ClientKey: key, rtspClient: rtspClient, rtspState: constant.RTSP_STATE_RECORD, cfg: rtspClient.Config})
+	if !callInfo.usesChannel(0) {
+		t.Fatal("started session stopped recording when the CRD stopped matching")
+	}
+	rtspClient.cs.listClient.Set(key, Client{ClientKey: key, rtspClient: rtspClient, rtspState: constant.RTSP_STATE_DISCONNECT, cfg: rtspClient.Config})
+	if callInfo.usesChannel(0) {
+		t.Fatal("call no longer matching recorded after its session ended")
+	}
+}
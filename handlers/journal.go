@@ -0,0 +1,524 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// The journal is rewritten with only the active calls once this many records
+// were appended to it
+const journalCompactRecords = 1000
+
+// journalRecord is a line of the journal file, e.g.
+//
+//	{"op":"call","name":"1001","recorder_type":0,"target":4,"listen_port":40000,"crd":[{"id":1,"value":"1001"}]}
+//	{"op":"channel","name":"1001","recorder_type":0,"rec_addr":"10.0.0.5:554","connref":"...","setup_time":"..."}
+//	{"op":"end","name":"1001","recorder_type":0}
+//
+// A call record carries the CRD attributes of the call, one per id as kept by
+// its CRDHistory.
+type journalRecord struct {
+	Op           string `json:"op"`
+	Name         string `json:"name"`
+	RecorderType int    `json:"recorder_type"`
+	// call
+	Target     int               `json:"target,omitempty"`
+	ListenPort int               `json:"listen_port,omitempty"`
+	CRD        []journalCRDField `json:"crd,omitempty"`
+	// channel
+	RecAddr     string `json:"rec_addr,omitempty"`
+	Connref     string `json:"connref,omitempty"`
+	SetupTime   string `json:"setup_time,omitempty"`
+	ConnectTime string `json:"connect_time,omitempty"`
+}
+
+type journalCRDField struct {
+	Id    int    `json:"id"`
+	Value string `json:"value"`
+}
+
+// journalChannel is what the new session of a recovered channel keeps from
+// the one before the restart.
+type journalChannel struct {
+	connref     string
+	setupTime   string
+	connectTime string
+}
+
+// journalCall is an active call as known from the journal.
+type journalCall struct {
+	target     constant.RTSPState
+	listenPort int
+	crd        []models.CRDField
+	// By recorder address
+	channels map[string]journalChannel
+}
+
+// Journal keeps the active calls in a file, so they can be recovered after a
+// restart of the library. The records are written and synced by a goroutine of
+// the journal, so the calls never wait for the disk. A nil Journal records
+// nothing.
+type Journal struct {
+	mutex *sync.Mutex
+	path  string
+	// Only used by the writer goroutine once it started
+	file  *os.File
+	calls map[CallKey]*journalCall
+	// Records appended since the file was last rewritten
+	records int
+	// Records waiting for the writer goroutine
+	buffered []byte
+	// Signalled when records are buffered, closed by close
+	chFlush chan struct{}
+	// Closed when the writer goroutine returned
+	done   chan struct{}
+	closed bool
+	// Calls recovered from the file that the host did not report yet
+	pending map[CallKey]bool
+	utils.Logger
+}
+
+// OpenJournal reads the calls left in the journal at path, which are returned
+// to be recovered, and rewrites it with them.
+func OpenJournal(path string, logger utils.Logger) (*Journal, map[CallKey]*journalCall, error) {
+	journal := &Journal{
+		mutex:   &sync.Mutex{},
+		path:    path,
+		calls:   make(map[CallKey]*journalCall),
+		chFlush: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: make(map[CallKey]bool),
+		Logger:  logger,
+	}
+	if err := journal.read(); err != nil {
+		return nil, nil, err
+	}
+	if err := journal.compact(journal.snapshot()); err != nil {
+		return nil, nil, err
+	}
+	go journal.writeLoop()
+	recovered := make(map[CallKey]*journalCall)
+	for key, call := range journal.calls {
+		recovered[key] = call
+		journal.pending[key] = true
+	}
+	return journal, recovered, nil
+}
+
+// read replays the records of the file. A line that can not be parsed, e.g.
+// one cut by a crash, is skipped.
+func (journal *Journal) read() error {
+	file, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			journal.LogWarn("Skipping a line of the journal", "path", journal.path, "line", line, "err", err)
+			continue
+		}
+		journal.apply(record)
+	}
+	return scanner.Err()
+}
+
+// apply updates the calls with record.
+func (journal *Journal) apply(record journalRecord) {
+	key := CallKey{Name: record.Name, RecorderType: constant.RecorderType(record.RecorderType)}
+	if record.Op == "end" {
+		delete(journal.calls, key)
+		return
+	}
+	call, ok := journal.calls[key]
+	if !ok {
+		call = &journalCall{channels: make(map[string]journalChannel)}
+		journal.calls[key] = call
+	}
+	switch record.Op {
+	case "call":
+		call.target = constant.RTSPState(record.Target)
+		call.listenPort = record.ListenPort
+		call.crd = []models.CRDField{}
+		for _, field := range record.CRD {
+			call.crd = append(call.crd, models.CRDField{Id: constant.Crd(field.Id), Value: field.Value})
+		}
+	case "channel":
+		call.channels[record.RecAddr] = journalChannel{
+			connref:     record.Connref,
+			setupTime:   record.SetupTime,
+			connectTime: record.ConnectTime,
+		}
+	}
+}
+
+// snapshot returns the records that recreate the calls.
+func (journal *Journal) snapshot() []journalRecord {
+	records := []journalRecord{}
+	for key, call := range journal.calls {
+		records = append(records, call.record(key, call.crd))
+		for recAddr, channel := range call.channels {
+			records = append(records, channel.record(key, recAddr))
+		}
+	}
+	return records
+}
+
+func (call *journalCall) record(key CallKey, crd []models.CRDField) journalRecord {
+	record := journalRecord{
+		Op:           "call",
+		Name:         key.Name,
+		RecorderType: int(key.RecorderType),
+		Target:       int(call.target),
+		ListenPort:   call.listenPort,
+	}
+	for _, field := range crd {
+		record.CRD = append(record.CRD, journalCRDField{Id: int(field.Id), Value: field.Value})
+	}
+	return record
+}
+
+func (channel journalChannel) record(key CallKey, recAddr string) journalRecord {
+	return journalRecord{
+		Op:           "channel",
+		Name:         key.Name,
+		RecorderType: int(key.RecorderType),
+		RecAddr:      recAddr,
+		Connref:      channel.connref,
+		SetupTime:    channel.setupTime,
+		ConnectTime:  channel.connectTime,
+	}
+}
+
+// compact rewrites the file with records, the active calls only, and reopens
+// it for appending.
+func (journal *Journal) compact(records []journalRecord) error {
+	tmpPath := journal.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	for _, record := range records {
+		line, _ := json.Marshal(record)
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	tmpFile.Close()
+	if err := os.Rename(tmpPath, journal.path); err != nil {
+		return err
+	}
+	if journal.file != nil {
+		journal.file.Close()
+	}
+	journal.file, err = os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	return err
+}
+
+// write buffers records for the writer goroutine. The caller holds the mutex.
+func (journal *Journal) write(records ...journalRecord) {
+	if journal.closed {
+		return
+	}
+	for _, record := range records {
+		line, _ := json.Marshal(record)
+		journal.buffered = append(journal.buffered, line...)
+		journal.buffered = append(journal.buffered, '\n')
+	}
+	journal.records += len(records)
+	select {
+	case journal.chFlush <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop appends the buffered records to the file until the journal is
+// closed. The records buffered meanwhile are synced together.
+func (journal *Journal) writeLoop() {
+	defer close(journal.done)
+	for range journal.chFlush {
+		journal.flush()
+	}
+	journal.flush()
+}
+
+// flush writes and syncs the buffered records, or rewrites the file once it
+// got journalCompactRecords records.
+func (journal *Journal) flush() {
+	journal.mutex.Lock()
+	data := journal.buffered
+	journal.buffered = nil
+	var records []journalRecord
+	if journal.records >= journalCompactRecords {
+		// The calls include the changes of the buffered records
+		records = journal.snapshot()
+		journal.records = 0
+	}
+	journal.mutex.Unlock()
+	if journal.file == nil {
+		return
+	}
+	if records != nil {
+		if err := journal.compact(records); err != nil {
+			journal.LogError("Could not rewrite the journal", "path", journal.path, "err", err)
+		}
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	if _, err := journal.file.Write(data); err != nil {
+		journal.LogError("Could not write the journal", "path", journal.path, "err", err)
+		return
+	}
+	journal.file.Sync()
+}
+
+// update records the changes of an active call: its target state, listen
+// port, CRD attributes and the sessions of its channels.
+func (journal *Journal) update(key CallKey, target constant.RTSPState, listenPort int, crd []models.CRDField, channels map[string]journalChannel) {
+	if journal == nil {
+		return
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	call, ok := journal.calls[key]
+	if !ok {
+		call = &journalCall{channels: make(map[string]journalChannel)}
+		journal.calls[key] = call
+	}
+	records := []journalRecord{}
+	if !ok || call.target != target || call.listenPort != listenPort || !sameCRD(call.crd, crd) {
+		call.target = target
+		call.listenPort = listenPort
+		call.crd = append([]models.CRDField{}, crd...)
+		records = append(records, call.record(key, call.crd))
+	}
+	for recAddr, channel := range channels {
+		if call.channels[recAddr] != channel {
+			call.channels[recAddr] = channel
+			records = append(records, channel.record(key, recAddr))
+		}
+	}
+	if len(records) != 0 {
+		journal.write(records...)
+	}
+}
+
+// end records that the call was released.
+func (journal *Journal) end(key CallKey) {
+	if journal == nil {
+		return
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	delete(journal.pending, key)
+	if _, ok := journal.calls[key]; !ok {
+		return
+	}
+	delete(journal.calls, key)
+	journal.write(journalRecord{Op: "end", Name: key.Name, RecorderType: int(key.RecorderType)})
+}
+
+// claim records that the host reported the call, which is kept if it was
+// recovered.
+func (journal *Journal) claim(key CallKey) {
+	if journal == nil {
+		return
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if journal.pending[key] {
+		delete(journal.pending, key)
+		journal.LogInfo("Recovered call reported by the host, keeping it", "name", key.Name, "recorderType", int(key.RecorderType))
+	}
+}
+
+// unclaimed returns the recovered calls the host did not report and forgets
+// them.
+func (journal *Journal) unclaimed() []CallKey {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	keys := []CallKey{}
+	for key := range journal.pending {
+		keys = append(keys, key)
+	}
+	journal.pending = make(map[CallKey]bool)
+	return keys
+}
+
+// close writes the records buffered and stops the writer goroutine. The file is
+// emptied if no call is left.
+func (journal *Journal) close() {
+	if journal == nil {
+		return
+	}
+	journal.mutex.Lock()
+	if journal.closed {
+		journal.mutex.Unlock()
+		return
+	}
+	journal.closed = true
+	close(journal.chFlush)
+	journal.mutex.Unlock()
+	<-journal.done
+	if journal.file != nil {
+		journal.mutex.Lock()
+		ended := len(journal.calls) == 0
+		journal.mutex.Unlock()
+		if ended {
+			if err := journal.compact(nil); err != nil {
+				journal.LogError("Could not empty the journal", "path", journal.path, "err", err)
+			}
+		}
+		journal.file.Close()
+		journal.file = nil
+	}
+}
+
+func sameCRD(a []models.CRDField, b []models.CRDField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// journalState records the state of the call in the journal.
+func (callInfo *CallInfo) journalState() {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	if rtspClient.journal == nil {
+		return
+	}
+	channels := make(map[string]journalChannel)
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		switch callInfo.getClientIfExist(ch).rtspState {
+		case constant.RTSP_STATE_SETUP, constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE:
+		default:
+			continue
+		}
+		crd := callInfo.getCRD(ch)
+		if crd.Value == "" {
+			continue
+		}
+		channels[cfg.recAddrs[ch]] = journalChannel{
+			connref:     crd.Value,
+			setupTime:   crd.Properties.SetupTime.Value,
+			connectTime: crd.Properties.ConnectTime.Value,
+		}
+	}
+	listenPort := 0
+	if current, ok := rtspClient.GetCallInfoIfExist(callInfo.CallKey); ok {
+		listenPort = current.ListenPort
+	}
+	rtspClient.journal.update(callInfo.CallKey, callInfo.failover.getTarget(), listenPort, callInfo.crdHistory.get(), channels)
+}
+
+// RecoverCalls re-attaches to the calls left in the journal by the previous
+// run: their sessions are started again with the connref and times they had,
+// up to the state of the call. The calls the host does not report within
+// JournalGraceMs are orphaned and ended, which sends the recorders their
+// disconnect CRD. It is called once the config is loaded.
+func (rtspClient *RTSPClient) RecoverCalls() {
+	if rtspClient.journal == nil {
+		return
+	}
+	recovered := rtspClient.recovered
+	rtspClient.recovered = nil
+	if len(recovered) == 0 {
+		return
+	}
+	cfg := rtspClient.config()
+	for key, call := range recovered {
+		if call.target == constant.RTSP_STATE_NULL || !cfg.recordsAnyType(key.RecorderType) {
+			rtspClient.LogWarn("Dropping the call left in the journal, it can not be recorded", "name", key.Name, "recorderType", int(key.RecorderType))
+			rtspClient.journal.end(key)
+			continue
+		}
+		callInfo, blockState := rtspClient.GetCallInfo(key)
+		if blockState != constant.NON_BLOCK {
+			// The call is ending, it ends its journal entry too
+			rtspClient.LogWarn("Not recovering the call left in the journal, it is ending", "name", key.Name, "recorderType", int(key.RecorderType))
+			continue
+		}
+		callInfo.crdHistory.add(call.crd)
+		callInfo.failover.setTarget(call.target)
+		if call.listenPort != 0 {
+			callInfo.UpdatelistenPort(call.listenPort)
+		}
+		if call.target == constant.RTSP_STATE_RECORD {
+			callInfo.doRecordRTP(true)
+		}
+		rtspClient.LogInfo("Recovering the call left in the journal", "name", key.Name, "recorderType", int(key.RecorderType), "state", call.target)
+		callInfo.chRecover <- call
+	}
+	rtspClient.orphanTimer = time.AfterFunc(time.Duration(rtspClient.JournalGraceMs)*time.Millisecond, rtspClient.endOrphanedCalls)
+}
+
+// endOrphanedCalls ends the recovered calls the host did not report.
+func (rtspClient *RTSPClient) endOrphanedCalls() {
+	for _, key := range rtspClient.journal.unclaimed() {
+		callInfo, ok := rtspClient.GetCallInfoIfExist(key)
+		if !ok {
+			continue
+		}
+		rtspClient.LogWarn("The host did not report the recovered call, ending it", "name", key.Name, "recorderType", int(key.RecorderType))
+		ctx, cancel := context.WithTimeout(context.Background(), rtspClient.ReleaseTimeout())
+		stopped := callInfo.stopCall(ctx)
+		cancel()
+		if !stopped {
+			rtspClient.LogWarn("Could not end the recovered call, its event queue is full", "name", key.Name, "recorderType", int(key.RecorderType))
+		}
+	}
+}
+
+// doRecover starts the sessions of a recovered call, keeping the connref and
+// times of the sessions before the restart.
+func (callInfo *CallInfo) doRecover(call *journalCall) {
+	cfg := callInfo.config()
+	var wg sync.WaitGroup
+	for j := 0; j < cfg.MaxCh; j++ {
+		if !callInfo.usesChannel(j) {
+			continue
+		}
+		channel := call.channels[cfg.recAddrs[j]]
+		from := CRD{}
+		from.Value = channel.connref
+		from.Properties.SetupTime.Value = channel.setupTime
+		from.Properties.ConnectTime.Value = channel.connectTime
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			callInfo.joinChannel(j, call.target, resumeCRD(from, callInfo.crdHistory, cfg.ed137Versions[j], callInfo.RecorderType))
+		}(j)
+	}
+	wg.Wait()
+}
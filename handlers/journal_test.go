@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// readJournal returns the records of the journal file at path, sorted by
+// call then op.
+func readJournal(t *testing.T, path string) []journalRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := []journalRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Op < records[j].Op
+	})
+	return records
+}
+
+// journalRecords returns the numbers of records appended to journal since it
+// was last rewritten.
+func journalRecords(journal *Journal) int {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.records
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.journal")
+	data := `{"op":"call","name":"1001","recorder_type":0,"target":4,"listen_port":40000,"crd":[{"id":1,"value":"1001"}]}
+{"op":"channel","name":"1001","recorder_type":0,"rec_addr":"10.0.0.5:554","connref":"ref1","setup_time":"t1"}
+{"op":"call","name":"1002","recorder_type":0,"target":4}
+{"op":"end","name":"1002","recorder_type":0}
+{"op":"call","name":"1001","recorder_type":0,"target":5,"listen_port":40000,"crd":[{"id":1,"value":"1001"}]}
+{"op":"call","name":"1003","recorder_type":0,"tar`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	journal, recovered, err := OpenJournal(path, utils.CreateZapLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close()
+
+	key := CallKey{Name: "1001", RecorderType: constant.RET_PHONE}
+	want := map[CallKey]*journalCall{
+		key: {
+			target:     constant.RTSPState(5),
+			listenPort: 40000,
+			crd:        []models.CRDField{{Id: constant.Crd(1), Value: "1001"}},
+			channels:   map[string]journalChannel{"10.0.0.5:554": {connref: "ref1", setupTime: "t1"}},
+		},
+	}
+	if !reflect.DeepEqual(recovered, want) {
+		t.Fatalf("recovered %+v, want %+v", recovered, want)
+	}
+	// Rewritten with the call left only
+	wantRecords := []journalRecord{
+		{Op: "call", Name: "1001", Target: 5, ListenPort: 40000, CRD: []journalCRDField{{Id: 1, Value: "1001"}}},
+		{Op: "channel", Name: "1001", RecAddr: "10.0.0.5:554", Connref: "ref1", SetupTime: "t1"},
+	}
+	if records := readJournal(t, path); !reflect.DeepEqual(records, wantRecords) {
+		t.Fatalf("journal %+v, want %+v", records, wantRecords)
+	}
+}
+
+func TestJournalUpdateWritesChanges(t *testing.T) {
+	journal, _, err := OpenJournal(filepath.Join(t.TempDir(), "calls.journal"), utils.CreateZapLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close()
+	key := CallKey{Name: "1001", RecorderType: constant.RET_PHONE}
+	crd := []models.CRDField{{Id: constant.CALLING_NR_ID, Value: "1001"}}
+	channels := map[string]journalChannel{"10.0.0.5:554": {connref: "ref1"}, "10.0.0.6:554": {connref: "ref2"}}
+	for _, step := range []struct {
+		name     string
+		update   func()
+		wantSent int
+	}{
+		{"new call", func() { journal.update(key, constant.RTSP_STATE_RECORD, 40000, crd, channels) }, 3},
+		{"same state", func() { journal.update(key, constant.RTSP_STATE_RECORD, 40000, crd, channels) }, 0},
+		{"target changed", func() { journal.update(key, constant.RTSP_STATE_PAUSE, 40000, crd, channels) }, 1},
+		{"CRD changed", func() {
+			journal.update(key, constant.RTSP_STATE_PAUSE, 40000, append(crd, models.CRDField{Id: constant.CALLED_NR_ID, Value: "1002"}), channels)
+		}, 1},
+		{"one channel changed", func() {
+			journal.update(key, constant.RTSP_STATE_PAUSE, 40000, append(crd, models.CRDField{Id: constant.CALLED_NR_ID, Value: "1002"}),
+				map[string]journalChannel{"10.0.0.5:554": {connref: "ref1"}, "10.0.0.6:554": {connref: "ref3"}})
+		}, 1},
+		{"end", func() { journal.end(key) }, 1},
+		{"end again", func() { journal.end(key) }, 0},
+	} {
+		before := journalRecords(journal)
+		step.update()
+		if sent := journalRecords(journal) - before; sent != step.wantSent {
+			t.Fatalf("%s: %d records written, want %d", step.name, sent, step.wantSent)
+		}
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.journal")
+	journal, _, err := OpenJournal(path, utils.CreateZapLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := CallKey{Name: "1001", RecorderType: constant.RET_PHONE}
+	ended := CallKey{Name: "1002", RecorderType: constant.RET_RADIO_TX}
+	journal.update(active, constant.RTSP_STATE_RECORD, 40000, nil, map[string]journalChannel{"10.0.0.5:554": {connref: "ref1"}})
+	journal.update(ended, constant.RTSP_STATE_RECORD, 40002, nil, nil)
+	journal.end(ended)
+	journal.mutex.Lock()
+	journal.records = journalCompactRecords
+	journal.mutex.Unlock()
+	journal.update(active, constant.RTSP_STATE_PAUSE, 40000, nil, nil)
+	journal.close()
+
+	want := []journalRecord{
+		{Op: "call", Name: "1001", Target: int(constant.RTSP_STATE_PAUSE), ListenPort: 40000},
+		{Op: "channel", Name: "1001", RecAddr: "10.0.0.5:554", Connref: "ref1"},
+	}
+	if records := readJournal(t, path); !reflect.DeepEqual(records, want) {
+		t.Fatalf("journal %+v, want %+v", records, want)
+	}
+	if journalRecords(journal) >= journalCompactRecords {
+		t.Fatal("count of the records not reset by the compaction")
+	}
+}
+
+func TestJournalEmptiedOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.journal")
+	journal, _, err := OpenJournal(path, utils.CreateZapLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := CallKey{Name: "1001", RecorderType: constant.RET_PHONE}
+	journal.update(key, constant.RTSP_STATE_RECORD, 40000, nil, map[string]journalChannel{"10.0.0.5:554": {connref: "ref1"}})
+	journal.end(key)
+	journal.close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("journal left with %d bytes, want it empty", info.Size())
+	}
+}
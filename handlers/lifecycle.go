@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+type Options struct {
+	// Time a config reload waits for the active calls to be released
+	ReleaseTimeoutMs int `json:"release_timeout_ms"`
+	// Reload the config when its files change on disk
+	WatchConfig bool `json:"watch_config"`
+	// Time without change a reload waits for, so a file is read once written
+	WatchDebounceMs int `json:"watch_debounce_ms"`
+	// File keeping the active calls, empty for none
+	JournalFile string `json:"journal_file"`
+	// Time the calls recovered from the journal wait for the host to report
+	// them before they are ended
+	JournalGraceMs int `json:"journal_grace_ms"`
+	// Number of events a call keeps before it handles them, and what is done
+	// with those that do not fit
+	EventQueueSize   int                  `json:"event_queue_size"`
+	EventQueuePolicy constant.QueuePolicy `json:"event_queue_policy"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		ReleaseTimeoutMs: 4000,
+		WatchDebounceMs:  500,
+		JournalGraceMs:   10000,
+		EventQueueSize:   32,
+		EventQueuePolicy: constant.QUEUE_COALESCE,
+	}
+}
+
+// ParseOptions reads the JSON options given to Init. Missing keys keep their
+// default value and an empty string yields the default options.
+func ParseOptions(data string) (Options, error) {
+	options := DefaultOptions()
+	if data == "" {
+		return options, nil
+	}
+	if err := json.Unmarshal([]byte(data), &options); err != nil {
+		return options, err
+	}
+	return options, options.Validate()
+}
+
+// WithDefaults returns the options with their zero values replaced by those of
+// DefaultOptions, for the options built before some of them existed.
+func (options Options) WithDefaults() Options {
+	defaults := DefaultOptions()
+	if options.ReleaseTimeoutMs == 0 {
+		options.ReleaseTimeoutMs = defaults.ReleaseTimeoutMs
+	}
+	if options.WatchDebounceMs == 0 {
+		options.WatchDebounceMs = defaults.WatchDebounceMs
+	}
+	if options.JournalGraceMs == 0 {
+		options.JournalGraceMs = defaults.JournalGraceMs
+	}
+	if options.EventQueueSize == 0 {
+		options.EventQueueSize = defaults.EventQueueSize
+	}
+	if options.EventQueuePolicy == "" {
+		options.EventQueuePolicy = defaults.EventQueuePolicy
+	}
+	return options
+}
+
+// Validate returns an error for the first option out of its range.
+func (options Options) Validate() error {
+	if options.ReleaseTimeoutMs <= 0 {
+		return errors.New("release_timeout_ms must be positive")
+	}
+	if options.WatchDebounceMs <= 0 {
+		return errors.New("watch_debounce_ms must be positive")
+	}
+	if options.JournalGraceMs <= 0 {
+		return errors.New("journal_grace_ms must be positive")
+	}
+	if options.EventQueueSize <= 0 {
+		return errors.New("event_queue_size must be positive")
+	}
+	if !validQueuePolicy(options.EventQueuePolicy) {
+		return errors.New("event_queue_policy must be block, drop_oldest or coalesce")
+	}
+	return nil
+}
+
+func validQueuePolicy(policy constant.QueuePolicy) bool {
+	switch policy {
+	case constant.QUEUE_BLOCK, constant.QUEUE_DROP_OLDEST, constant.QUEUE_COALESCE:
+		return true
+	}
+	return false
+}
+
+// ReleaseTimeout is the release_timeout_ms of the timings of the config, or
+// of the options.
+func (rtspClient *RTSPClient) ReleaseTimeout() time.Duration {
+	if releaseTimeoutMs := rtspClient.config().releaseTimeoutMs; releaseTimeoutMs > 0 {
+		return time.Duration(releaseTimeoutMs) * time.Millisecond
+	}
+	return time.Duration(rtspClient.ReleaseTimeoutMs) * time.Millisecond
+}
+
+// Shutdown ends every active call and closes every recorder session. It
+// returns the number of calls that were not released before the timeout
+// expired.
+func (rtspClient *RTSPClient) Shutdown(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	rtspClient.SetReloadState(constant.SHUTDOWN_RELOAD)
+	rtspClient.resolver.stop()
+	rtspClient.health.stop()
+	if rtspClient.watcher != nil {
+		rtspClient.watcher.stop()
+	}
+	if rtspClient.orphanTimer != nil {
+		rtspClient.orphanTimer.Stop()
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	rtspClient.stopAllCallInner(ctx)
+	cancel()
+	leftCalls := rtspClient.waitCallsReleased(time.Until(deadline))
+	if leftCalls == 0 {
+		rtspClient.journal.close()
+		rtspClient.LogInfo("All calls have been released on shutdown")
+		return 0
+	}
+
+	// The calls that did not end by themselves TEARDOWN their sessions from
+	// their own goroutine once stopped
+	for it := range rtspClient.callModel.listCallInfo.IterBuffered() {
+		it.Val.stop()
+	}
+	leftCalls = rtspClient.waitCallsReleased(time.Until(deadline))
+	leftClients := 0
+	for it := range rtspClient.cs.listClient.IterBuffered() {
+		c := it.Val
+		if c.rtspState != constant.RTSP_STATE_NULL && c.rtspState != constant.RTSP_STATE_DISCONNECT {
+			leftClients++
+		}
+	}
+	// The calls left stay in the journal for the next run
+	rtspClient.journal.close()
+	rtspClient.LogWarn("Shutdown expired with calls still active", "calls", leftCalls, "sessions", leftClients)
+	return leftCalls
+}
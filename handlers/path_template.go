@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultPathTemplate is the layout of the recorders that do not set one:
+// /<vcs user>/<name>, <name>_brief, <name>_ptt, <name>_squ, ambient, phone,
+// radio or brief. Without a VCS user the path starts with two slashes, as it
+// always did.
+const defaultPathTemplate = "/{vcs_user}/{resource}"
+
+// pathVariables are the variables of a path template.
+var pathVariables = map[string]bool{
+	// VCS user of the call, lower case
+	"vcs_user": true,
+	// Name of the call, lower case, empty for the group calls
+	"name": true,
+	// Recorder type, e.g. phone or radio_tx
+	"type": true,
+	// Last part of the default layout
+	"resource":     true,
+	"frequency_id": true,
+	// Index of the recorder channel
+	"ch":      true,
+	"connref": true,
+}
+
+// checkPathTemplate reports the first syntax error of template: an unknown
+// variable, an unbalanced brace or a character that does not belong in a
+// path.
+func checkPathTemplate(template string) error {
+	if strings.Trim(template, "/") == "" {
+		return fmt.Errorf("empty path")
+	}
+	for i := 0; i < len(template); i++ {
+		switch template[i] {
+		case '{':
+			end := strings.IndexAny(template[i+1:], "{}")
+			if end < 0 || template[i+1+end] != '}' {
+				return fmt.Errorf("unclosed { at offset %d", i)
+			}
+			variable := template[i+1 : i+1+end]
+			if !pathVariables[variable] {
+				return fmt.Errorf("unknown variable {%s}", variable)
+			}
+			i += 1 + end
+		case '}':
+			return fmt.Errorf("unopened } at offset %d", i)
+		case '?', '#', ' ', '\t':
+			return fmt.Errorf("%q is not allowed in a path", template[i])
+		}
+	}
+	return nil
+}
+
+// expandPathTemplate replaces the variables of a template checked by
+// checkPathTemplate by their value. A slash is added in front of a result
+// that does not start with one.
+func expandPathTemplate(template string, values map[string]string) string {
+	var path strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			path.WriteByte(template[i])
+			continue
+		}
+		end := strings.IndexByte(template[i:], '}')
+		if end < 0 {
+			path.WriteString(template[i:])
+			break
+		}
+		path.WriteString(values[template[i+1:i+end]])
+		i += end
+	}
+	if strings.HasPrefix(path.String(), "/") {
+		return path.String()
+	}
+	return "/" + path.String()
+}
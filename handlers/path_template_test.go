@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestCheckPathTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		// Empty when the template is valid
+		err string
+	}{
+		{defaultPathTemplate, ""},
+		{"/rec/{vcs_user}/{name}_{type}/{ch}", ""},
+		{"{connref}", ""},
+		{"/{frequency_id}/", ""},
+		{"static/path", ""},
+		{"", "empty path"},
+		{"///", "empty path"},
+		{"/{user}", "unknown variable {user}"},
+		{"/{NAME}", "unknown variable {NAME}"},
+		{"/{}", "unknown variable {}"},
+		{"/{ name }", "unknown variable { name }"},
+		{"/{name", "unclosed { at offset 1"},
+		{"/{na{me}", "unclosed { at offset 1"},
+		{"/name}", "unopened } at offset 5"},
+		{"/{name}}", "unopened } at offset 7"},
+		{"/{name}?x=1", `'?' is not allowed in a path`},
+		{"/rec#1", `'#' is not allowed in a path`},
+		{"/my rec", `' ' is not allowed in a path`},
+		{"/my\trec", `'\t' is not allowed in a path`},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			err := checkPathTemplate(tc.template)
+			if tc.err == "" && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Fatalf("got error %v, want %s", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestExpandPathTemplate(t *testing.T) {
+	values := map[string]string{"vcs_user": "cwp1", "resource": "1001_ptt", "ch": "0", "name": ""}
+	noVCSUser := map[string]string{"vcs_user": "", "resource": "1001_ptt"}
+	for _, tc := range []struct {
+		name     string
+		template string
+		values   map[string]string
+		want     string
+	}{
+		{"default", defaultPathTemplate, values, "/cwp1/1001_ptt"},
+		// The path the recorders got before the templates
+		{"default without a VCS user", defaultPathTemplate, noVCSUser, "//1001_ptt"},
+		{"relative", "{vcs_user}/{resource}", values, "/cwp1/1001_ptt"},
+		{"relative without a VCS user", "{vcs_user}/{resource}", noVCSUser, "/1001_ptt"},
+		{"static", "rec/{ch}", values, "/rec/0"},
+		{"empty name", "{name}/{resource}", values, "/1001_ptt"},
+		{"unknown value", "/{connref}", values, "/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := expandPathTemplate(tc.template, tc.values); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSessionPath(t *testing.T) {
+	cfg := testCfg(t, `{"recorders": [
+		{"address": "10.0.0.3", "path_template": "/rec/{ch}/{type}/{resource}"},
+		{"address": "10.0.0.1", "path_template": "/rec/{ch}/{type}/{resource}"},
+		{"address": "10.0.0.3", "path_template": "/rec/{ch}/{type}/{resource}"},
+		{"address": "10.0.0.2", "path_template": "/rec/{ch}/{type}/{resource}"}
+	]}`)
+	for ch, want := range []string{"/rec/0/radio_tx/1001_ptt", "/rec/1/radio_tx/1001_ptt", "/rec/2/radio_tx/1001_ptt"} {
+		c := Client{ClientKey: ClientKey{CallKey: CallKey{Name: "1001", RecorderType: constant.RET_RADIO_TX}, ch: ch}, cfg: cfg}
+		if got := c.path(CRD{}); got != want {
+			t.Fatalf("channel %d: got path %s, want %s", ch, got, want)
+		}
+	}
+	// {ch} follows the order of the file
+	if want := []string{"10.0.0.3:8554", "10.0.0.1:8554", "10.0.0.2:8554"}; !reflect.DeepEqual(cfg.recAddrs, want) {
+		t.Fatalf("got channels %v, want %v", cfg.recAddrs, want)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"dvrs.lib/RTSPClient/utils"
+	"github.com/pion/rtp"
+)
+
+const (
+	pcmSampleRate       = 8000
+	pcmSamplesPerPacket = 160
+	pcmPayloadType      = 96
+	pushQueueSize       = 50
+)
+
+var (
+	ErrNoActiveCall  = errors.New("no active call")
+	ErrInvalidPacket = errors.New("invalid RTP packet")
+	ErrInvalidPCM    = errors.New("invalid PCM samples")
+	ErrPushQueueFull = errors.New("push queue is full")
+)
+
+// PCMPacketizer turns the PCM samples pushed by the host into RTP packets
+// carrying 16-bit linear PCM, which ConvertCodec then encodes to G.711. Its
+// mutex also guards the room left in the push queue of the call.
+type PCMPacketizer struct {
+	mutex     *sync.Mutex
+	ssrc      uint32
+	seq       uint16
+	timestamp uint32
+	resampler pcmResampler
+}
+
+func NewPCMPacketizer() *PCMPacketizer {
+	return &PCMPacketizer{
+		mutex:     &sync.Mutex{},
+		ssrc:      rand.Uint32(),
+		seq:       uint16(rand.Intn(1 << 16)),
+		timestamp: rand.Uint32(),
+	}
+}
+
+// packetize returns the packets of samples, numbered after those returned
+// before. The caller holds the mutex.
+func (p *PCMPacketizer) packetize(samples []int16) []rtp.Packet {
+	listPkt := []rtp.Packet{}
+	for len(samples) > 0 {
+		n := pcmSamplesPerPacket
+		if len(samples) < n {
+			n = len(samples)
+		}
+		payload := make([]byte, 2*n)
+		for i, sample := range samples[:n] {
+			payload[2*i] = byte(sample)
+			payload[2*i+1] = byte(sample >> 8)
+		}
+		listPkt = append(listPkt, rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    pcmPayloadType,
+				SequenceNumber: p.seq,
+				Timestamp:      p.timestamp,
+				SSRC:           p.ssrc,
+			},
+			Payload: payload,
+		})
+		p.seq++
+		p.timestamp += uint32(n)
+		samples = samples[n:]
+	}
+	return listPkt
+}
+
+// pcmResampler converts a stream of PCM samples to 8 kHz by linear
+// interpolation. The last sample and the phase carry over from one chunk to
+// the next, so the chunks join without a click and the output keeps the rate
+// of the input however the host splits it.
+type pcmResampler struct {
+	sampleRate int
+	// Position of the next output sample from the first sample of the next
+	// chunk, in 1/pcmSampleRate of an input sample. It is negative when the
+	// sample falls between the last sample carried and the next chunk.
+	phase int
+	last  int16
+}
+
+// resample returns samples at 8 kHz. A change of sampleRate starts a new
+// stream.
+func (resampler *pcmResampler) resample(samples []int16, sampleRate int) []int16 {
+	if sampleRate != resampler.sampleRate {
+		*resampler = pcmResampler{sampleRate: sampleRate}
+	}
+	if sampleRate == pcmSampleRate || len(samples) == 0 {
+		return samples
+	}
+	// Each output sample needs the input sample that follows its position
+	end := (len(samples) - 1) * pcmSampleRate
+	out := make([]int16, 0, (end-resampler.phase)/sampleRate+1)
+	phase := resampler.phase
+	for ; phase < end; phase += sampleRate {
+		j := phase / pcmSampleRate
+		frac := phase % pcmSampleRate
+		var a int16
+		if phase < 0 {
+			j, frac = -1, phase+pcmSampleRate
+			a = resampler.last
+		} else {
+			a = samples[j]
+		}
+		b := samples[j+1]
+		out = append(out, int16((int(a)*(pcmSampleRate-frac)+int(b)*frac)/pcmSampleRate))
+	}
+	resampler.phase = phase - len(samples)*pcmSampleRate
+	resampler.last = samples[len(samples)-1]
+	return out
+}
+
+// pushPackets queues listPkt for the recorders, all of them or none. The
+// caller holds the mutex of the packetizer.
+func (callInfo CallInfo) pushPackets(listPkt []rtp.Packet) error {
+	if cap(callInfo.chPushRTP)-len(callInfo.chPushRTP) < len(listPkt) {
+		return ErrPushQueueFull
+	}
+	for _, pkt := range listPkt {
+		callInfo.chPushRTP <- pkt
+	}
+	return nil
+}
+
+// PushRTP forwards one RTP packet of the call to its recorders without going
+// through the UDP listen port.
+func (rtspClient *RTSPClient) PushRTP(key CallKey, buf []byte) error {
+	callInfo, ok := rtspClient.GetCallInfoIfExist(key)
+	if !ok {
+		return ErrNoActiveCall
+	}
+	if !utils.IsRTPPacket(buf) {
+		return ErrInvalidPacket
+	}
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(buf); err != nil {
+		return ErrInvalidPacket
+	}
+	if pkt.Timestamp == uint32(0) || len(pkt.Payload) == 0 {
+		return ErrInvalidPacket
+	}
+	callInfo.pcm.mutex.Lock()
+	defer callInfo.pcm.mutex.Unlock()
+	if err := callInfo.pushPackets([]rtp.Packet{pkt}); err != nil {
+		return err
+	}
+	callInfo.stats.add(len(buf))
+	return nil
+}
+
+// PushPCM packetizes 16-bit linear PCM samples of the call and forwards them
+// to its recorders. Samples not at 8 kHz are resampled first. If the push
+// queue has no room for all of them, none is queued and the stream goes on as
+// if they had not been pushed, so the host can push them again.
+func (rtspClient *RTSPClient) PushPCM(key CallKey, samples []int16, sampleRate int) error {
+	callInfo, ok := rtspClient.GetCallInfoIfExist(key)
+	if !ok {
+		return ErrNoActiveCall
+	}
+	if len(samples) == 0 || sampleRate <= 0 {
+		return ErrInvalidPCM
+	}
+	pcm := callInfo.pcm
+	pcm.mutex.Lock()
+	defer pcm.mutex.Unlock()
+	saved := *pcm
+	listPkt := pcm.packetize(pcm.resampler.resample(samples, sampleRate))
+	if err := callInfo.pushPackets(listPkt); err != nil {
+		// Nothing was queued, the stream goes on from where it was
+		*pcm = saved
+		return err
+	}
+	for _, pkt := range listPkt {
+		callInfo.stats.add(pkt.MarshalSize())
+	}
+	return nil
+}
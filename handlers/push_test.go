@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ramp returns n samples rising by step from 0.
+func ramp(n int, step int) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(i * step)
+	}
+	return samples
+}
+
+func TestResamplePCMAcrossChunks(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		sampleRate int
+		total      int
+		chunk      int
+		wantLen    int
+	}{
+		{"8 kHz passes through", 8000, 480, 100, 480},
+		{"48 kHz in 10 ms chunks", 48000, 4800, 480, 800},
+		{"48 kHz in odd chunks", 48000, 4800, 7, 800},
+		{"44.1 kHz in 10 ms chunks", 44100, 4410, 441, 800},
+		{"16 kHz one sample at a time", 16000, 320, 1, 160},
+		{"11025 Hz in odd chunks", 11025, 2205, 13, 1600},
+		{"4 kHz upsampled", 4000, 400, 33, 800},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := ramp(tc.total, 3)
+			var whole pcmResampler
+			want := append([]int16{}, whole.resample(samples, tc.sampleRate)...)
+			var chunked pcmResampler
+			got := []int16{}
+			for i := 0; i < len(samples); i += tc.chunk {
+				end := i + tc.chunk
+				if end > len(samples) {
+					end = len(samples)
+				}
+				got = append(got, chunked.resample(samples[i:end], tc.sampleRate)...)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("chunked output differs from the output of the whole stream")
+			}
+			// The last output samples wait for the next input sample
+			if diff := tc.wantLen - len(got); diff < 0 || diff > pcmSampleRate/tc.sampleRate+1 {
+				t.Fatalf("got %d samples, want %d", len(got), tc.wantLen)
+			}
+			// A ramp resamples to a ramp, without a jump at the chunk joins
+			for i := 1; i < len(got); i++ {
+				if delta := int(got[i]) - int(got[i-1]); delta <= 0 || delta > 3*tc.sampleRate/pcmSampleRate+1 {
+					t.Fatalf("sample %d jumps by %d", i, delta)
+				}
+			}
+		})
+	}
+}
+
+func TestResamplePCMRateChangeRestarts(t *testing.T) {
+	var resampler pcmResampler
+	resampler.resample(ramp(7, 1), 48000)
+	got := resampler.resample([]int16{100, 200}, 16000)
+	if want := []int16{100}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPushPCMAllOrNothing(t *testing.T) {
+	rtspClient := newTestRTSPClient(t)
+	key := CallKey{Name: "1001"}
+	callInfo := rtspClient.newCallInfo(key)
+	rtspClient.callModel.listCallInfo.Set(key, callInfo)
+
+	for _, tc := range []struct {
+		name    string
+		samples int
+		err     error
+		queued  int
+	}{
+		{"fits", 160 * (pushQueueSize - 2), nil, pushQueueSize - 2},
+		{"one packet too many", 160 * 3, ErrPushQueueFull, pushQueueSize - 2},
+		{"fills the queue", 160 * 2, nil, pushQueueSize},
+		{"queue full", 1, ErrPushQueueFull, pushQueueSize},
+	} {
+		seq, received := callInfo.pcm.seq, callInfo.stats.snapshot()
+		err := rtspClient.PushPCM(key, ramp(tc.samples, 1), pcmSampleRate)
+		if err != tc.err {
+			t.Fatalf("%s: got error %v, want %v", tc.name, err, tc.err)
+		}
+		if len(callInfo.chPushRTP) != tc.queued {
+			t.Fatalf("%s: %d packets queued, want %d", tc.name, len(callInfo.chPushRTP), tc.queued)
+		}
+		if err != nil && (callInfo.pcm.seq != seq || callInfo.stats.snapshot() != received) {
+			t.Fatalf("%s: a rejected push moved the stream on", tc.name)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+	"github.com/bluenviron/gortsplib/v4"
+)
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+	// Each delay is randomly shortened or lengthened by up to this fraction,
+	// so the calls of a recorder that restarts do not reconnect together
+	reconnectJitter = 0.2
+)
+
+// RecordingGap is a period a recorder channel recorded nothing of a call,
+// from the loss of its session to the start of a new one.
+type RecordingGap struct {
+	Start time.Time
+	End   time.Time
+	// Number of reconnections tried
+	Attempts int
+}
+
+// recordingGap is a recorder channel being reconnected.
+type recordingGap struct {
+	start    time.Time
+	attempts int
+	// CRD of the lost session, whose connref and times the new session
+	// keeps
+	crd CRD
+	// Nil while a reconnection is in progress
+	timer *time.Timer
+}
+
+// CallReconnect keeps the recorder channels of a call whose session failed and
+// that are reconnected, by recorder address.
+type CallReconnect struct {
+	mutex *sync.Mutex
+	gaps  map[string]*recordingGap
+}
+
+func NewCallReconnect() *CallReconnect {
+	return &CallReconnect{
+		mutex: &sync.Mutex{},
+		gaps:  make(map[string]*recordingGap),
+	}
+}
+
+// reconnectDelay returns the delay before the reconnection following
+// attempts failed ones: reconnectMinDelay doubled each time up to
+// reconnectMaxDelay, with jitter.
+func reconnectDelay(attempts int) time.Duration {
+	delay := reconnectMinDelay
+	for i := 0; i < attempts && delay < reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	jitter := (rand.Float64()*2 - 1) * reconnectJitter
+	return time.Duration(float64(delay) * (1 + jitter))
+}
+
+// reportLostSession asks the call to check the session of client, which failed
+// or was lost.
+func (rtspClient *RTSPClient) reportLostSession(key CallKey, client *gortsplib.Client) {
+	callInfo, ok := rtspClient.GetCallInfoIfExist(key)
+	if !ok || callInfo.chLostSession == nil {
+		return
+	}
+	select {
+	case callInfo.chLostSession <- client:
+	default:
+		rtspClient.LogWarn("Could not report a lost session, the queue is full", "name", key.Name, "recorderType", int(key.RecorderType))
+	}
+}
+
+// handleLostSession fails the channel whose session is client over to its
+// standby, or reconnects it outside of the failover groups, if that session
+// failed.
+func (callInfo *CallInfo) handleLostSession(client *gortsplib.Client) {
+	cfg := callInfo.config()
+	j := -1
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		if callInfo.getClientIfExist(ch).client == client {
+			j = ch
+			break
+		}
+	}
+	if j < 0 {
+		return
+	}
+	c := callInfo.getClient(j)
+	switch c.rtspState {
+	case constant.RTSP_STATE_NULL, constant.RTSP_STATE_DISCONNECT:
+	default:
+		if !c.client.IsClose() {
+			return
+		}
+		c.errCode = constant.STATUS_ERR_SESSION_LOST
+		c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
+		callInfo.updateClient(j, &c)
+	}
+	if cfg.failoverGroups[j] != "" {
+		callInfo.doFailover(j)
+		return
+	}
+	callInfo.scheduleReconnect(j)
+}
+
+// scheduleReconnect reconnects channel j after a delay growing with the
+// attempts, unless a reconnection is already scheduled. Nothing is done once
+// the call ends.
+func (callInfo *CallInfo) scheduleReconnect(j int) {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.config()
+	if callInfo.failover.getTarget() == constant.RTSP_STATE_NULL {
+		return
+	}
+	recAddr := cfg.recAddrs[j]
+	reconnect := callInfo.reconnect
+	reconnect.mutex.Lock()
+	defer reconnect.mutex.Unlock()
+	gap, ok := reconnect.gaps[recAddr]
+	if !ok {
+		gap = &recordingGap{
+			start: time.Now(),
+			crd:   callInfo.getCRD(j),
+		}
+		reconnect.gaps[recAddr] = gap
+		rtspClient.LogWarn("Recording gap started", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "channel", j, "recorder", recAddr)
+	}
+	if gap.timer != nil {
+		return
+	}
+	delay := reconnectDelay(gap.attempts)
+	rtspClient.LogDebug("Reconnecting", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "channel", j, "recorder", recAddr, "delay", delay.Round(time.Millisecond))
+	gap.timer = time.AfterFunc(delay, func() {
+		select {
+		case callInfo.chReconnect <- recAddr:
+		case <-callInfo.chDone:
+		}
+	})
+}
+
+// doReconnect starts a new session to the recorder recAddr, brought to the
+// state of the call with the CRD of the lost session. It is scheduled again if
+// the recorder still fails.
+func (callInfo *CallInfo) doReconnect(recAddr string) {
+	cfg := callInfo.config()
+	reconnect := callInfo.reconnect
+	reconnect.mutex.Lock()
+	gap, ok := reconnect.gaps[recAddr]
+	if ok {
+		gap.timer = nil
+		gap.attempts++
+	}
+	reconnect.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	j := -1
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		if cfg.recAddrs[ch] == recAddr {
+			j = ch
+		}
+	}
+	target := callInfo.failover.getTarget()
+	if j < 0 || target == constant.RTSP_STATE_NULL || !callInfo.usesChannel(j) {
+		// Removed in a reload, moved to a standby or the call ended
+		callInfo.endGap(recAddr, -1)
+		return
+	}
+	if c := callInfo.getClient(j); c.rtspState != constant.RTSP_STATE_NULL && c.rtspState != constant.RTSP_STATE_DISCONNECT && !c.client.IsClose() {
+		// Restarted by an event of the call
+		callInfo.endGap(recAddr, j)
+		return
+	}
+	callInfo.joinChannel(j, target, resumeCRD(gap.crd, callInfo.crdHistory, cfg.ed137Versions[j], callInfo.RecorderType))
+	switch callInfo.getClient(j).rtspState {
+	case constant.RTSP_STATE_SETUP, constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE:
+		callInfo.endGap(recAddr, j)
+	default:
+		callInfo.scheduleReconnect(j)
+	}
+}
+
+// endGap stops reconnecting to recAddr. If the channel j has a new session,
+// the gap is reported to the status handler so that the recorder can mark the
+// missing segment; j is -1 if the call does not record on recAddr anymore.
+func (callInfo *CallInfo) endGap(recAddr string, j int) {
+	rtspClient := callInfo.rtspClient
+	reconnect := callInfo.reconnect
+	reconnect.mutex.Lock()
+	gap, ok := reconnect.gaps[recAddr]
+	delete(reconnect.gaps, recAddr)
+	reconnect.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if gap.timer != nil {
+		gap.timer.Stop()
+	}
+	end := time.Now()
+	if j < 0 {
+		rtspClient.LogWarn("Recording gap not closed, the call does not record on the recorder anymore", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "recorder", recAddr, "from", gap.start.Format(time.RFC3339Nano))
+		return
+	}
+	rtspClient.LogWarn("Recording gap closed", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "channel", j, "recorder", recAddr,
+		"from", gap.start.Format(time.RFC3339Nano), "to", end.Format(time.RFC3339Nano), "attempts", gap.attempts)
+	rtspClient.notifyStatus(StatusEvent{
+		CallKey:   callInfo.CallKey,
+		Ch:        j,
+		RTSPState: callInfo.getClient(j).rtspState,
+		ErrCode:   constant.STATUS_RECONNECTED,
+		Gap: &RecordingGap{
+			Start:    gap.start,
+			End:      end,
+			Attempts: gap.attempts,
+		},
+	})
+}
+
+// stop cancels the reconnections of a call that ended. The gaps still open
+// last until the end of the call.
+func (reconnect *CallReconnect) stop(callInfo *CallInfo) {
+	reconnect.mutex.Lock()
+	defer reconnect.mutex.Unlock()
+	for recAddr, gap := range reconnect.gaps {
+		if gap.timer != nil {
+			gap.timer.Stop()
+		}
+		callInfo.rtspClient.LogWarn("Recording gap lasted until the end of the call", "name", callInfo.Name, "recorderType", int(callInfo.RecorderType), "recorder", recAddr, "from", gap.start.Format(time.RFC3339Nano))
+		delete(reconnect.gaps, recAddr)
+	}
+}
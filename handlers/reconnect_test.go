@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, reconnectMinDelay},
+		{1, 2 * reconnectMinDelay},
+		{2, 4 * reconnectMinDelay},
+		{5, 32 * reconnectMinDelay},
+		{6, reconnectMaxDelay},
+		{7, reconnectMaxDelay},
+		{1000, reconnectMaxDelay},
+	} {
+		low := time.Duration(float64(tc.want) * (1 - reconnectJitter))
+		high := time.Duration(float64(tc.want) * (1 + reconnectJitter))
+		for i := 0; i < 100; i++ {
+			if delay := reconnectDelay(tc.attempts); delay < low || delay > high {
+				t.Fatalf("attempts %d: delay %v out of [%v, %v]", tc.attempts, delay, low, high)
+			}
+		}
+	}
+}
+
+// newReconnectCall returns a call recording on one recorder, with the status
+// events of its client sent to the channel returned.
+func newReconnectCall(t *testing.T) (*CallInfo, <-chan StatusEvent) {
+	rtspClient := newTestRTSPClient(t)
+	rtspClient.Config = testCfg(t, `{"recorders": [{"address": "127.0.0.1"}]}`)
+	chStatus := make(chan StatusEvent, 10)
+	rtspClient.SetStatusHandler(func(statusEvent StatusEvent) {
+		chStatus <- statusEvent
+	})
+	callInfo := rtspClient.newCallInfo(CallKey{Name: "1001", RecorderType: constant.RET_PHONE})
+	t.Cleanup(callInfo.stop)
+	return &callInfo, chStatus
+}
+
+// openGap returns a copy of the gap of recAddr, if open.
+func (callInfo *CallInfo) openGap(recAddr string) (recordingGap, bool) {
+	callInfo.reconnect.mutex.Lock()
+	defer callInfo.reconnect.mutex.Unlock()
+	gap, ok := callInfo.reconnect.gaps[recAddr]
+	if !ok {
+		return recordingGap{}, false
+	}
+	return *gap, true
+}
+
+func TestScheduleReconnect(t *testing.T) {
+	callInfo, chStatus := newReconnectCall(t)
+	recAddr := callInfo.config().recAddrs[0]
+
+	callInfo.scheduleReconnect(0)
+	if _, ok := callInfo.openGap(recAddr); ok {
+		t.Fatal("gap opened for a call that ended")
+	}
+
+	callInfo.failover.setTarget(constant.RTSP_STATE_RECORD)
+	callInfo.scheduleReconnect(0)
+	gap, ok := callInfo.openGap(recAddr)
+	if !ok || gap.timer == nil {
+		t.Fatal("no reconnection scheduled")
+	}
+	timer := gap.timer
+	callInfo.scheduleReconnect(0)
+	if gap, _ := callInfo.openGap(recAddr); gap.timer != timer {
+		t.Fatal("a second reconnection was scheduled")
+	}
+
+	callInfo.endGap(recAddr, 0)
+	if _, ok := callInfo.openGap(recAddr); ok {
+		t.Fatal("gap still open")
+	}
+	select {
+	case statusEvent := <-chStatus:
+		if statusEvent.ErrCode != constant.STATUS_RECONNECTED || statusEvent.Ch != 0 || statusEvent.Gap == nil {
+			t.Fatalf("got status %+v", statusEvent)
+		}
+		if !statusEvent.Gap.Start.Equal(gap.start) || statusEvent.Gap.End.Before(gap.start) {
+			t.Fatalf("got gap %+v, started at %v", *statusEvent.Gap, gap.start)
+		}
+	default:
+		t.Fatal("gap not reported")
+	}
+	if timer.Stop() {
+		t.Fatal("timer of the closed gap still running")
+	}
+}
+
+func TestEndGapOfChannelLeft(t *testing.T) {
+	callInfo, chStatus := newReconnectCall(t)
+	recAddr := callInfo.config().recAddrs[0]
+	callInfo.failover.setTarget(constant.RTSP_STATE_RECORD)
+	callInfo.scheduleReconnect(0)
+	callInfo.endGap(recAddr, -1)
+	if _, ok := callInfo.openGap(recAddr); ok {
+		t.Fatal("gap still open")
+	}
+	select {
+	case statusEvent := <-chStatus:
+		t.Fatalf("gap of a channel left reported: %+v", statusEvent)
+	default:
+	}
+	// Ending it again does nothing
+	callInfo.endGap(recAddr, 0)
+	select {
+	case statusEvent := <-chStatus:
+		t.Fatalf("closed gap reported: %+v", statusEvent)
+	default:
+	}
+}
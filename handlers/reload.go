@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/models"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// CRDHistory keeps the CRD attributes received by a call, so a recorder
+// channel added by a reload or a new session can rebuild the CRD of the call.
+// It holds one value per attribute, in the order they were last received,
+// which rebuilds the same CRD as every attribute received would.
+type CRDHistory struct {
+	mutex     *sync.Mutex
+	crdFields []models.CRDField
+}
+
+func NewCRDHistory() *CRDHistory {
+	return &CRDHistory{
+		mutex: &sync.Mutex{},
+	}
+}
+
+// crdMerge tells how the values received for a CRD attribute add up, as
+// SetCRDInner applies them.
+type crdMerge int
+
+const (
+	// The last value replaces the others
+	crdMergeLast crdMerge = iota
+	// The first value that is not empty is kept
+	crdMergeFirst
+	// Each distinct value is added
+	crdMergeAccumulate
+)
+
+func crdMergeOf(id constant.Crd) crdMerge {
+	switch id {
+	case constant.VCS_USER_ID, constant.DESC_ID, constant.GROUP_NAME_ID:
+		return crdMergeFirst
+	case constant.ENDPT_ID_ID:
+		return crdMergeAccumulate
+	}
+	return crdMergeLast
+}
+
+func (history *CRDHistory) add(crdFields []models.CRDField) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	for _, crdField := range crdFields {
+		history.addInner(crdField)
+	}
+}
+
+func (history *CRDHistory) addInner(crdField models.CRDField) {
+	merge := crdMergeOf(crdField.Id)
+	for i, kept := range history.crdFields {
+		if kept.Id != crdField.Id {
+			continue
+		}
+		switch merge {
+		case crdMergeFirst:
+			if kept.Value != "" {
+				return
+			}
+		case crdMergeAccumulate:
+			if kept.Value != crdField.Value {
+				continue
+			}
+			return
+		}
+		history.crdFields = append(history.crdFields[:i], history.crdFields[i+1:]...)
+		break
+	}
+	history.crdFields = append(history.crdFields, crdField)
+}
+
+func (history *CRDHistory) get() []models.CRDField {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	return append([]models.CRDField{}, history.crdFields...)
+}
+
+// value returns the value of the CRD attribute id the call holds.
+func (history *CRDHistory) value(id constant.Crd) string {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	for i := len(history.crdFields) - 1; i >= 0; i-- {
+		if history.crdFields[i].Id == id {
+			return history.crdFields[i].Value
+		}
+	}
+	return ""
+}
+
+type channelCfg struct {
+	mediaTransport   string
+	keepTimeAlive    string
+	interleave       string
+	ed137Version     string
+	recGroup         bool
+	codec            string
+	payloadType      uint8
+	filter           string
+	security         recorderSecurity
+	pathTemplate     string
+	failoverGroup    string
+	failoverPriority int
+}
+
+func (cfg *Config) channelCfg(ch int) channelCfg {
+	return channelCfg{
+		mediaTransport:   cfg.mediaTransports[ch],
+		keepTimeAlive:    cfg.keepTimeAlives[ch],
+		interleave:       cfg.interleaves[ch],
+		ed137Version:     cfg.ed137Versions[ch],
+		recGroup:         cfg.recGroups[ch],
+		codec:            cfg.codecs[ch],
+		payloadType:      cfg.payloadTypes[ch],
+		filter:           cfg.filters[ch].String(),
+		security:         cfg.securities[ch],
+		pathTemplate:     cfg.pathTemplates[ch],
+		failoverGroup:    cfg.failoverGroups[ch],
+		failoverPriority: cfg.failoverPriorities[ch],
+	}
+}
+
+// changes lists the settings that differ from newChannelCfg.
+func (channelCfg channelCfg) changes(newChannelCfg channelCfg) []string {
+	var changes []string
+	add := func(name string, oldValue string, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", name, oldValue, newValue))
+		}
+	}
+	add("media transport", channelCfg.mediaTransport, newChannelCfg.mediaTransport)
+	add("keep alive", channelCfg.keepTimeAlive, newChannelCfg.keepTimeAlive)
+	add("interleave", channelCfg.interleave, newChannelCfg.interleave)
+	add("ED137 version", channelCfg.ed137Version, newChannelCfg.ed137Version)
+	add("recorder group", strconv.FormatBool(channelCfg.recGroup), strconv.FormatBool(newChannelCfg.recGroup))
+	add("codec", channelCfg.codec, newChannelCfg.codec)
+	add("payload type", strconv.Itoa(int(channelCfg.payloadType)), strconv.Itoa(int(newChannelCfg.payloadType)))
+	add("filter", channelCfg.filter, newChannelCfg.filter)
+	add("security", channelCfg.security.String(), newChannelCfg.security.String())
+	add("path template", channelCfg.pathTemplate, newChannelCfg.pathTemplate)
+	add("failover group", channelCfg.failoverGroup, newChannelCfg.failoverGroup)
+	add("failover rank", strconv.Itoa(channelCfg.failoverPriority), strconv.Itoa(newChannelCfg.failoverPriority))
+	if channelCfg.security.password != newChannelCfg.security.password {
+		changes = append(changes, "password changed")
+	}
+	if channelCfg.security.tlsHash != newChannelCfg.security.tlsHash {
+		changes = append(changes, "certificates changed")
+	}
+	return changes
+}
+
+// cfgChanges describes the recorders added, removed or changed from oldCfg
+// to newCfg, one line each.
+func cfgChanges(oldCfg *Config, newCfg *Config) []string {
+	matched := matchChannels(oldCfg, newCfg)
+	oldChs := make(map[int]int)
+	for i, j := range matched {
+		oldChs[j] = i
+	}
+	var changes []string
+	for i, addr := range oldCfg.recAddrs {
+		if _, ok := matched[i]; !ok {
+			changes = append(changes, "removed "+addr)
+		}
+	}
+	for j, addr := range newCfg.recAddrs {
+		i, ok := oldChs[j]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("added %s (%s, %s)", addr, newCfg.codecs[j], newCfg.filters[j]))
+		} else if channelChanges := oldCfg.channelCfg(i).changes(newCfg.channelCfg(j)); len(channelChanges) != 0 {
+			changes = append(changes, "changed "+addr+": "+strings.Join(channelChanges, ", "))
+		}
+	}
+	for _, recorderType := range timingsRecorderTypes {
+		if oldTimings, newTimings := oldCfg.timingsOf(recorderType), newCfg.timingsOf(recorderType); oldTimings != newTimings {
+			changes = append(changes, fmt.Sprintf("changed the timings of %s from %+v to %+v, for the new calls", strings.ToLower(recorderType.String()), oldTimings, newTimings))
+		}
+	}
+	if oldCfg.releaseTimeoutMs != newCfg.releaseTimeoutMs {
+		changes = append(changes, fmt.Sprintf("changed release_timeout_ms from %d to %d", oldCfg.releaseTimeoutMs, newCfg.releaseTimeoutMs))
+	}
+	return changes
+}
+
+// CfgDiff is the difference between two configs, matched by recorder address.
+type CfgDiff struct {
+	// Channels whose settings did not change, old index to new index
+	kept map[int]int
+	// Old indexes of the channels that were removed or changed
+	removed []int
+	// New indexes of the channels that were added or changed
+	added []int
+}
+
+// matchChannels pairs the channels of oldCfg with those of newCfg by address,
+// old index to new index. An address listed several times pairs its n-th
+// channel in oldCfg with its n-th channel in newCfg.
+func matchChannels(oldCfg *Config, newCfg *Config) map[int]int {
+	newChs := make(map[string][]int)
+	for j, addr := range newCfg.recAddrs {
+		newChs[addr] = append(newChs[addr], j)
+	}
+	matched := make(map[int]int)
+	for i, addr := range oldCfg.recAddrs {
+		if chs := newChs[addr]; len(chs) != 0 {
+			matched[i] = chs[0]
+			newChs[addr] = chs[1:]
+		}
+	}
+	return matched
+}
+
+func diffConfig(oldCfg *Config, newCfg *Config) CfgDiff {
+	diff := CfgDiff{
+		kept: make(map[int]int),
+	}
+	matched := matchChannels(oldCfg, newCfg)
+	keptChs := make(map[int]bool)
+	for i := range oldCfg.recAddrs {
+		j, ok := matched[i]
+		if ok && oldCfg.channelCfg(i) == newCfg.channelCfg(j) {
+			diff.kept[i] = j
+			keptChs[j] = true
+		} else {
+			diff.removed = append(diff.removed, i)
+		}
+	}
+	for j := range newCfg.recAddrs {
+		if !keptChs[j] {
+			diff.added = append(diff.added, j)
+		}
+	}
+	return diff
+}
+
+type removedClient struct {
+	c   Client
+	crd CRD
+}
+
+// callCfg is the config a call runs with. A reload never renumbers the
+// sessions of a call during their requests: the call takes the new config in
+// its own goroutine, between two events. It is shared by pointer between the
+// copies of the CallInfo.
+type callCfg struct {
+	mutex  *sync.RWMutex
+	config *Config
+	// Configs published since, oldest first
+	pending []*Config
+	ready   chan struct{}
+}
+
+func newCallCfg(config *Config) *callCfg {
+	return &callCfg{
+		mutex:  &sync.RWMutex{},
+		config: config,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// publish hands config to the call, which takes it in applyConfigs.
+func (cfg *callCfg) publish(config *Config) {
+	cfg.mutex.Lock()
+	cfg.pending = append(cfg.pending, config)
+	cfg.mutex.Unlock()
+	select {
+	case cfg.ready <- struct{}{}:
+	default:
+	}
+}
+
+// config returns the config the call runs with. The channel indexes of its
+// sessions are those of this config.
+func (callInfo CallInfo) config() *Config {
+	callInfo.cfg.mutex.RLock()
+	defer callInfo.cfg.mutex.RUnlock()
+	return callInfo.cfg.config
+}
+
+// config returns the config the new calls start with.
+func (rtspClient *RTSPClient) config() *Config {
+	rtspClient.cfgMutex.RLock()
+	defer rtspClient.cfgMutex.RUnlock()
+	return rtspClient.Config
+}
+
+// reloadConfig switches to newCfg without stopping the active calls, each of
+// which takes it between two of its events, see applyConfigs.
+func (rtspClient *RTSPClient) reloadConfig(newCfg *Config) {
+	rtspClient.cfgMutex.Lock()
+	oldCfg := rtspClient.Config
+	rtspClient.Config = newCfg
+	rtspClient.publishConfig()
+	rtspClient.cfgMutex.Unlock()
+	diff := diffConfig(oldCfg, newCfg)
+	rtspClient.LogInfo("Reload config", "kept", len(diff.kept), "removed", len(diff.removed), "added", len(diff.added))
+}
+
+// publishConfig hands the config to the active calls. The caller holds
+// cfgMutex, so no call is created meanwhile with the previous one.
+func (rtspClient *RTSPClient) publishConfig() {
+	for it := range rtspClient.callModel.listCallInfo.IterBuffered() {
+		it.Val.cfg.publish(rtspClient.Config)
+	}
+}
+
+// applyConfigs moves the call to the configs published since its last event.
+// The sessions of unchanged channels are kept, those of removed channels are
+// torn down and sessions start on the added channels.
+func (callInfo *CallInfo) applyConfigs() {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.cfg
+	cfg.mutex.Lock()
+	pending := cfg.pending
+	cfg.pending = nil
+	cfg.mutex.Unlock()
+	for _, newCfg := range pending {
+		diff := diffConfig(callInfo.config(), newCfg)
+		listRemoved := callInfo.renumber(newCfg, diff)
+		rtspClient.closeRemovedClients(listRemoved)
+		// An ending call starts no session
+		if current, ok := rtspClient.GetCallInfoIfExist(callInfo.CallKey); ok && current.blockState == constant.NON_BLOCK {
+			callInfo.doJoinChannels(diff.added)
+		}
+	}
+}
+
+// renumber moves the sessions and CRDs of the call to their channel in
+// newCfg, and returns those of the removed channels.
+func (callInfo *CallInfo) renumber(newCfg *Config, diff CfgDiff) []removedClient {
+	rtspClient := callInfo.rtspClient
+	cfg := callInfo.cfg
+	cfg.mutex.Lock()
+	defer cfg.mutex.Unlock()
+	clients := make(map[int]Client)
+	crds := make(map[int]CRD)
+	for i := 0; i < cfg.config.MaxCh; i++ {
+		key := ClientKey{
+			CallKey: callInfo.CallKey,
+			ch:      i,
+		}
+		if c, ok := rtspClient.cs.listClient.Get(key); ok {
+			clients[i] = c
+		}
+		if crd, ok := rtspClient.crds.listCRD.Get(key); ok {
+			crds[i] = crd
+		}
+		rtspClient.cs.listClient.Remove(key)
+		rtspClient.crds.listCRD.Remove(key)
+	}
+	for i, crd := range crds {
+		if j, ok := diff.kept[i]; ok {
+			rtspClient.crds.listCRD.Set(ClientKey{CallKey: callInfo.CallKey, ch: j}, crd)
+		}
+	}
+	listRemoved := []removedClient{}
+	for i, c := range clients {
+		j, ok := diff.kept[i]
+		if !ok {
+			listRemoved = append(listRemoved, removedClient{c: c, crd: crds[i]})
+			continue
+		}
+		c.ch = j
+		c.cfg = newCfg
+		rtspClient.cs.listClient.Set(c.ClientKey, c)
+	}
+	cfg.config = newCfg
+	return listRemoved
+}
+
+func (rtspClient *RTSPClient) closeRemovedClients(listRemoved []removedClient) {
+	var wg sync.WaitGroup
+	for _, removed := range listRemoved {
+		c := removed.c
+		if c.rtspState == constant.RTSP_STATE_NULL || c.rtspState == constant.RTSP_STATE_DISCONNECT || c.client.IsClose() {
+			continue
+		}
+		wg.Add(1)
+		go func(removed removedClient) {
+			defer wg.Done()
+			removed.crd.EnableDisconnect(removed.c.RecorderType)
+			removed.c.closeWithCRD(&removed.crd)
+		}(removed)
+	}
+	wg.Wait()
+}
+
+// joinState returns the state the sessions of the call have reached, which
+// the channels added by a reload have to catch up with.
+func (callInfo CallInfo) joinState() constant.RTSPState {
+	cfg := callInfo.config()
+	joinState := constant.RTSP_STATE_NULL
+	for i := 0; i < cfg.MaxCh; i++ {
+		c := callInfo.getClientIfExist(i)
+		switch c.rtspState {
+		case constant.RTSP_STATE_RECORD:
+			return constant.RTSP_STATE_RECORD
+		case constant.RTSP_STATE_PAUSE:
+			joinState = constant.RTSP_STATE_PAUSE
+		case constant.RTSP_STATE_SETUP:
+			if joinState == constant.RTSP_STATE_NULL {
+				joinState = constant.RTSP_STATE_SETUP
+			}
+		}
+	}
+	return joinState
+}
+
+// doJoinChannels starts the sessions of the channels added by a reload with
+// the current CRD of the call, up to the state of the other channels.
+func (callInfo *CallInfo) doJoinChannels(chs []int) {
+	cfg := callInfo.config()
+	recorderType := callInfo.RecorderType
+	joinState := callInfo.joinState()
+	if joinState == constant.RTSP_STATE_NULL {
+		return
+	}
+	crdFields := callInfo.crdHistory.get()
+	var wg sync.WaitGroup
+	for _, j := range chs {
+		if j >= cfg.MaxCh || !callInfo.usesChannel(j) {
+			continue
+		}
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			crd := CRD{}
+			crd.SetCRDInner(crdFields, cfg.ed137Versions[j], recorderType)
+			callInfo.joinChannel(j, joinState, crd)
+		}(j)
+	}
+	wg.Wait()
+}
+
+// joinChannel starts the session of channel j with crd, up to joinState.
+func (callInfo *CallInfo) joinChannel(j int, joinState constant.RTSPState, crd CRD) {
+	rtspClient := callInfo.rtspClient
+	recorderType := callInfo.RecorderType
+	defer callInfo.updateCRD(j, &crd)
+	c := callInfo.getClient(j)
+	defer callInfo.updateClient(j, &c)
+	if c.rtspState != constant.RTSP_STATE_NULL && c.rtspState != constant.RTSP_STATE_DISCONNECT {
+		return
+	}
+	switch recorderType {
+	case constant.RET_PHONE:
+		crd.EnableSetupPhone()
+	case constant.RET_RADIO_TX, constant.RET_RADIO_RX:
+		if c.cfg.ed137Versions[j] == "ED137C" {
+			if recorderType == constant.RET_RADIO_TX {
+				crd.Properties.CallRef.Value += ("_PTT_" + utils.CreateRand4Digits())
+			} else {
+				crd.Properties.CallRef.Value += ("_SQU_" + utils.CreateRand4Digits())
+			}
+		}
+		crd.EnableSetupRadio()
+	case constant.RET_BRIEF:
+		if joinState != constant.RTSP_STATE_RECORD {
+			return
+		}
+		crd.EnableConnectBrief()
+	default:
+		if joinState != constant.RTSP_STATE_RECORD {
+			return
+		}
+		crd.EnableConnectGroup()
+	}
+	crdByt, _ := xml.MarshalIndent(crd, "", "    ")
+	u, err := c.Start(crd)
+	if err != nil {
+		rtspClient.LogDebug("Error Starting", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+		c.setRTSPState(constant.RTSP_STATE_DISCONNECT)
+		return
+	}
+	if err := c.AnnounceSetup(u); err != nil {
+		rtspClient.LogDebug("Error sending ANNOUNCE or SETUP request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+		c.closeOnErr(err)
+		return
+	}
+	switch recorderType {
+	case constant.RET_PHONE, constant.RET_RADIO_TX, constant.RET_RADIO_RX:
+		if err := c.SetParameter(u, crd, crdByt); err != nil {
+			rtspClient.LogDebug("Error sending SET_PARAMETER request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+			c.closeOnErr(err)
+			return
+		}
+		if joinState == constant.RTSP_STATE_SETUP || recorderType != constant.RET_PHONE && joinState == constant.RTSP_STATE_PAUSE {
+			return
+		}
+		switch recorderType {
+		case constant.RET_PHONE:
+			crd.EnableConfirmPhone()
+		case constant.RET_RADIO_TX:
+			if crd.Operations.PTT_Type == "" || crd.Operations.PTT_Type == "0" {
+				crd.Operations.PTT.Value = "1"
+			} else {
+				crd.Operations.PTT.Value = crd.Operations.PTT_Type
+			}
+			crd.EnableConfirmRadio()
+		default:
+			crd.Operations.SQU.Value = "1"
+			crd.EnableConfirmRadio()
+		}
+		crdByt, _ = xml.MarshalIndent(crd, "", "    ")
+	}
+	if err := c.Record(crd, crdByt); err != nil {
+		rtspClient.LogDebug("Error sending RECORD request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+		c.closeOnErr(err)
+		return
+	}
+	if joinState == constant.RTSP_STATE_PAUSE {
+		crd.Operations.Enabled = true
+		crd.EnablePausePhone()
+		crdByt, _ = xml.MarshalIndent(crd, "", "    ")
+		if err := c.Pause(crd, crdByt); err != nil {
+			rtspClient.LogDebug("Error sending PAUSE request", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+			c.closeOnErr(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestMatchChannels(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		oldAddrs []string
+		newAddrs []string
+		want     map[int]int
+	}{
+		{"same channels", []string{"a", "b"}, []string{"a", "b"}, map[int]int{0: 0, 1: 1}},
+		{"moved", []string{"a", "b"}, []string{"b", "a"}, map[int]int{0: 1, 1: 0}},
+		{"added", []string{"a"}, []string{"b", "a"}, map[int]int{0: 1}},
+		{"removed", []string{"a", "b"}, []string{"b"}, map[int]int{1: 0}},
+		{"duplicate address, n-th to n-th", []string{"a", "b", "a"}, []string{"a", "a", "b"}, map[int]int{0: 0, 1: 2, 2: 1}},
+		{"duplicate address removed once", []string{"a", "a"}, []string{"a"}, map[int]int{0: 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := matchChannels(&Config{recAddrs: tc.oldAddrs}, &Config{recAddrs: tc.newAddrs})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	oldRecJSON := `{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2"}]}`
+	for _, tc := range []struct {
+		name        string
+		newRecJSON  string
+		wantKept    map[int]int
+		wantRemoved []int
+		wantAdded   []int
+	}{
+		{"unchanged", oldRecJSON, map[int]int{0: 0, 1: 1}, nil, nil},
+		{
+			"keep alive changed",
+			`{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2", "keep_alive_interval": 5}]}`,
+			map[int]int{0: 0}, []int{1}, []int{1},
+		},
+		{
+			"recorder added",
+			`{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.3"}, {"address": "10.0.0.2"}]}`,
+			map[int]int{0: 0, 1: 2}, nil, []int{1},
+		},
+		{
+			"recorder removed",
+			`{"recorders": [{"address": "10.0.0.2"}]}`,
+			map[int]int{1: 0}, []int{0}, nil,
+		},
+		{
+			"duplicate address merged",
+			`{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2"}, {"address": "10.0.0.1"}]}`,
+			map[int]int{0: 0, 1: 1}, nil, nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diff := diffConfig(testCfg(t, oldRecJSON), testCfg(t, tc.newRecJSON))
+			if !reflect.DeepEqual(diff.kept, tc.wantKept) {
+				t.Errorf("kept %v, want %v", diff.kept, tc.wantKept)
+			}
+			if !reflect.DeepEqual(diff.removed, tc.wantRemoved) {
+				t.Errorf("removed %v, want %v", diff.removed, tc.wantRemoved)
+			}
+			if !reflect.DeepEqual(diff.added, tc.wantAdded) {
+				t.Errorf("added %v, want %v", diff.added, tc.wantAdded)
+			}
+		})
+	}
+}
+
+// newReloadCall returns a call running with the config of recJSON whose
+// channels all have a session, in state, and a CRD holding the address of the
+// channel as connref.
+func newReloadCall(t *testing.T, recJSON string, state constant.RTSPState) *CallInfo {
+	rtspClient := newTestRTSPClient(t)
+	rtspClient.Config = testCfg(t, recJSON)
+	callInfo := rtspClient.newCallInfo(CallKey{Name: "1001", RecorderType: constant.RET_PHONE})
+	t.Cleanup(callInfo.stop)
+	for ch, addr := range rtspClient.Config.recAddrs {
+		key := ClientKey{CallKey: callInfo.CallKey, ch: ch}
+		rtspClient.cs.listClient.Set(key, Client{ClientKey: key, rtspClient: rtspClient, rtspState: state, cfg: rtspClient.Config})
+		rtspClient.crds.listCRD.Set(key, CRD{Value: addr})
+	}
+	return &callInfo
+}
+
+// sessions returns the connref of the CRD and the state of the session of
+// each channel of the call, by channel.
+func (callInfo *CallInfo) sessions() ([]string, []constant.RTSPState) {
+	cfg := callInfo.config()
+	connrefs := []string{}
+	states := []constant.RTSPState{}
+	for ch := 0; ch < cfg.MaxCh; ch++ {
+		connrefs = append(connrefs, callInfo.getCRD(ch).Value)
+		states = append(states, callInfo.getClientIfExist(ch).rtspState)
+	}
+	return connrefs, states
+}
+
+func TestRenumber(t *testing.T) {
+	callInfo := newReloadCall(t, `{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2"}, {"address": "10.0.0.3"}]}`, constant.RTSP_STATE_RECORD)
+	oldCfg := callInfo.config()
+	newCfg := testCfg(t, `{"recorders": [{"address": "10.0.0.4"}, {"address": "10.0.0.3"}, {"address": "10.0.0.1", "keep_alive_interval": 5}]}`)
+	removed := callInfo.renumber(newCfg, diffConfig(oldCfg, newCfg))
+
+	if callInfo.config() != newCfg {
+		t.Fatal("the call still runs with the old config")
+	}
+	var removedConnrefs []string
+	for _, r := range removed {
+		removedConnrefs = append(removedConnrefs, r.crd.Value)
+	}
+	if len(removedConnrefs) != 2 || removedConnrefs[0] == removedConnrefs[1] {
+		t.Fatalf("got removed sessions %v, want those of 10.0.0.1 and 10.0.0.2", removedConnrefs)
+	}
+	for _, connref := range removedConnrefs {
+		if connref != "10.0.0.1:8554" && connref != "10.0.0.2:8554" {
+			t.Fatalf("got removed sessions %v, want those of 10.0.0.1 and 10.0.0.2", removedConnrefs)
+		}
+	}
+	// 10.0.0.3 moved from channel 2 to 1, the others have no session
+	connrefs, states := callInfo.sessions()
+	if want := []string{"", "10.0.0.3:8554", ""}; !reflect.DeepEqual(connrefs, want) {
+		t.Fatalf("got CRDs %v, want %v", connrefs, want)
+	}
+	if want := []constant.RTSPState{constant.RTSP_STATE_NULL, constant.RTSP_STATE_RECORD, constant.RTSP_STATE_NULL}; !reflect.DeepEqual(states, want) {
+		t.Fatalf("got sessions %v, want %v", states, want)
+	}
+	if got := callInfo.rtspClient.cs.listClient.Count(); got != 1 {
+		t.Fatalf("got %d sessions, want 1", got)
+	}
+}
+
+func TestApplyConfigs(t *testing.T) {
+	// The sessions are not started, none is closed on the recorders
+	callInfo := newReloadCall(t, `{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2"}]}`, constant.RTSP_STATE_NULL)
+	rtspClient := callInfo.rtspClient
+	unchanged := testCfg(t, `{"recorders": [{"address": "10.0.0.1"}, {"address": "10.0.0.2"}]}`)
+	reordered := testCfg(t, `{"recorders": [{"address": "10.0.0.3"}, {"address": "10.0.0.2"}, {"address": "10.0.0.1"}]}`)
+	callInfo.cfg.publish(unchanged)
+	callInfo.cfg.publish(reordered)
+	callInfo.applyConfigs()
+
+	if callInfo.config() != reordered {
+		t.Fatal("the call does not run with the last config published")
+	}
+	connrefs, _ := callInfo.sessions()
+	if want := []string{"", "10.0.0.2:8554", "10.0.0.1:8554"}; !reflect.DeepEqual(connrefs, want) {
+		t.Fatalf("got CRDs %v, want %v", connrefs, want)
+	}
+	for ch := 1; ch < 3; ch++ {
+		key := ClientKey{CallKey: callInfo.CallKey, ch: ch}
+		if c, ok := rtspClient.cs.listClient.Get(key); !ok || c.ch != ch || c.cfg != reordered {
+			t.Fatalf("session of channel %d not moved to the new config", ch)
+		}
+	}
+	callInfo.applyConfigs()
+	if callInfo.config() != reordered {
+		t.Fatal("a config was applied twice")
+	}
+}
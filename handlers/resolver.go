@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/utils"
+)
+
+const (
+	resolveInterval = 60 * time.Second
+	resolveTimeout  = 5 * time.Second
+)
+
+// Resolver keeps the addresses of the recorders configured by hostname and
+// resolves them again periodically. The sessions dial the last address
+// resolved through dialContext, so a DNS failure does not stop new
+// recordings to a recorder resolved before.
+type Resolver struct {
+	mutex *sync.RWMutex
+	// Last addresses resolved for each hostname of the config
+	addrs        map[string][]string
+	refreshMutex *sync.Mutex
+	chStop       chan struct{}
+	stopOnce     *sync.Once
+	utils.Logger
+}
+
+func NewResolver(logger utils.Logger) *Resolver {
+	return &Resolver{
+		mutex:        &sync.RWMutex{},
+		addrs:        make(map[string][]string),
+		refreshMutex: &sync.Mutex{},
+		chStop:       make(chan struct{}),
+		stopOnce:     &sync.Once{},
+		Logger:       logger,
+	}
+}
+
+// setHosts sets the hostnames to resolve from the recorder addresses of a
+// config and resolves the new ones.
+func (resolver *Resolver) setHosts(recAddrs []string) {
+	resolver.mutex.Lock()
+	addrs := make(map[string][]string)
+	for _, recAddr := range recAddrs {
+		host, _, err := net.SplitHostPort(recAddr)
+		if err != nil || net.ParseIP(host) != nil {
+			continue
+		}
+		addrs[host] = resolver.addrs[host]
+	}
+	resolver.addrs = addrs
+	resolver.mutex.Unlock()
+	go resolver.refresh(false)
+}
+
+// dialAddr returns the address to dial for recAddr: its last resolved IP if
+// its host is a hostname, recAddr itself otherwise.
+func (resolver *Resolver) dialAddr(recAddr string) string {
+	host, port, err := net.SplitHostPort(recAddr)
+	if err != nil {
+		return recAddr
+	}
+	resolver.mutex.RLock()
+	defer resolver.mutex.RUnlock()
+	if addrs := resolver.addrs[host]; len(addrs) != 0 {
+		return net.JoinHostPort(addrs[0], port)
+	}
+	return recAddr
+}
+
+// dialContext dials addr at the address given by dialAddr.
+func (resolver *Resolver) dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, resolver.dialAddr(addr))
+}
+
+// refresh resolves the hostnames again, all of them or only those never
+// resolved. A hostname that fails to resolve keeps its last addresses.
+func (resolver *Resolver) refresh(all bool) {
+	resolver.refreshMutex.Lock()
+	defer resolver.refreshMutex.Unlock()
+	resolver.mutex.RLock()
+	hosts := []string{}
+	for host, addrs := range resolver.addrs {
+		if all || len(addrs) == 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	resolver.mutex.RUnlock()
+
+	for _, host := range hosts {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			resolver.LogWarn("Could not resolve recorder, keeping its last address", "host", host, "err", err)
+			continue
+		}
+		resolver.mutex.Lock()
+		oldAddrs, ok := resolver.addrs[host]
+		if ok {
+			resolver.addrs[host] = addrs
+		}
+		resolver.mutex.Unlock()
+		if ok && !sameAddrs(oldAddrs, addrs) {
+			resolver.LogInfo("Recorder resolved", "host", host, "addrs", addrs)
+		}
+	}
+}
+
+func sameAddrs(addrs1 []string, addrs2 []string) bool {
+	sorted1 := append([]string{}, addrs1...)
+	sorted2 := append([]string{}, addrs2...)
+	sort.Strings(sorted1)
+	sort.Strings(sorted2)
+	return strings.Join(sorted1, ",") == strings.Join(sorted2, ",")
+}
+
+func (resolver *Resolver) run() {
+	ticker := time.NewTicker(resolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			resolver.refresh(true)
+		case <-resolver.chStop:
+			return
+		}
+	}
+}
+
+func (resolver *Resolver) stop() {
+	resolver.stopOnce.Do(func() {
+		close(resolver.chStop)
+	})
+}
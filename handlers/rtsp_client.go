@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"dvrs.lib/RTSPClient/constant"
 	"dvrs.lib/RTSPClient/utils"
+	"github.com/bluenviron/gortsplib/v4"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pion/rtp"
 )
 
 type CheckReload struct {
@@ -28,81 +33,154 @@ type CallModel struct {
 
 type RTSPClient struct {
 	*Config
+	saveCfg *Config
 	CheckReload
+	Options
+	cfgMutex  *sync.RWMutex
+	configDir string
 	callModel CallModel
 	cs        ClientModel
 	crds      CRDModel
+	calls     *callCounter
+	resolver  *Resolver
+	health    *RecorderHealth
+	// Nil unless Options.WatchConfig is set
+	watcher *CfgWatcher
+	// Report of the last config load, guarded by ReloadMutex
+	cfgReport CfgReport
+	// Nil unless Options.JournalFile is set
+	journal *Journal
+	// Calls read from the journal, until RecoverCalls
+	recovered map[CallKey]*journalCall
+	// Ends the recovered calls the host did not report, nil until
+	// RecoverCalls recovered any
+	orphanTimer *time.Timer
+	// Events dropped and coalesced by the queues of the calls
+	eventCounts *eventCounts
+	StatusNotifier
 	utils.Logger
 }
 
-var rtspClient *RTSPClient
-
-func GetRTSPClient() *RTSPClient {
-	if rtspClient == nil {
-		saveCfg = NewCfg()
-		rtspClient = &RTSPClient{
-			callModel: CallModel{
-				listCallInfo: cmap.NewWithCustomShardingFunction[CallKey, CallInfo](CallKey.Hash),
-			},
-			Config: NewCfg(),
-			CheckReload: CheckReload{
-				ReloadState: constant.NON_RELOAD,
-				ReloadMutex: &sync.RWMutex{},
-			},
-			cs: ClientModel{
-				listClient: cmap.NewWithCustomShardingFunction[ClientKey, Client](ClientKey.Hash),
-			},
-			crds:   CRDModel{listCRD: cmap.NewWithCustomShardingFunction[ClientKey, CRD](ClientKey.Hash)},
-			Logger: utils.CreateZapLogger(),
+// NewRTSPClient creates a client reading its config from configDir, or from
+// the legacy config paths if configDir is empty. The config is loaded by
+// LoadRecConfig.
+func NewRTSPClient(configDir string, options Options, logger utils.Logger) *RTSPClient {
+	rtspClient := &RTSPClient{
+		callModel: CallModel{
+			listCallInfo: cmap.NewWithCustomShardingFunction[CallKey, CallInfo](CallKey.Hash),
+		},
+		Config:  NewCfg(),
+		saveCfg: NewCfg(),
+		CheckReload: CheckReload{
+			ReloadState: constant.NON_RELOAD,
+			ReloadMutex: &sync.RWMutex{},
+		},
+		Options:   options,
+		cfgMutex:  &sync.RWMutex{},
+		configDir: configDir,
+		cs: ClientModel{
+			listClient: cmap.NewWithCustomShardingFunction[ClientKey, Client](ClientKey.Hash),
+		},
+		crds:        CRDModel{listCRD: cmap.NewWithCustomShardingFunction[ClientKey, CRD](ClientKey.Hash)},
+		calls:       newCallCounter(),
+		resolver:    NewResolver(logger),
+		eventCounts: &eventCounts{},
+		StatusNotifier: StatusNotifier{
+			statusMutex: &sync.RWMutex{},
+		},
+		Logger: logger,
+	}
+	rtspClient.health = NewRecorderHealth(rtspClient.resolver.dialContext, logger)
+	go rtspClient.resolver.run()
+	go rtspClient.health.run()
+	if options.WatchConfig {
+		recFiles, devSysFiles := rtspClient.cfgFiles()
+		paths := append(rtspClient.recJSONFiles(), recFiles...)
+		paths = append(paths, devSysFiles...)
+		watcher, err := NewCfgWatcher(paths, time.Duration(options.WatchDebounceMs)*time.Millisecond, rtspClient.onCfgFilesChanged, logger)
+		if err != nil {
+			rtspClient.LogError("Could not watch the config files", "err", err)
+		} else {
+			rtspClient.watcher = watcher
+		}
+	}
+	if options.JournalFile != "" {
+		journal, recovered, err := OpenJournal(options.JournalFile, logger)
+		if err != nil {
+			rtspClient.LogError("Could not open the journal", "err", err)
+		} else {
+			rtspClient.journal = journal
+			rtspClient.recovered = recovered
 		}
-
 	}
 	return rtspClient
 }
+
 func (rtspClient *RTSPClient) GetCallInfo(Key CallKey) (CallInfo, constant.BlockState) {
 	if rtspClient.callModel.listCallInfo.Has(Key) {
 		callInfo, _ := rtspClient.callModel.listCallInfo.Get(Key)
 		return callInfo, callInfo.blockState
 	} else {
-		callInfo := CallInfo{
-			CallKey: Key,
-			RTPClient: RTPClient{
-				chUpdateListenConn: make(chan int, 2),
-				chRecordRTP:        make(chan bool, 1),
-			},
-			ThreadHandle: ThreadHandle{
-				chDone: make(chan bool),
-				wg:     &sync.WaitGroup{},
-			},
-			EventQueue: EventQueue{
-				chBriefStateInfo:           make(chan BriefStateInfo, 10),
-				chGroupStateInfo:         make(chan GroupStateInfo, 10),
-				chCallMediaStateInfo:       make(chan CallMediaStateInfo, 10),
-				chCallStateInfo:            make(chan CallStateInfo, 10),
-				chRadioButtonStateInfo:     make(chan RadioButtonStateInfo, 20),
-				chLastCallMediaStateInfo:   make(chan CallMediaStateInfo, 1),
-				chLastRadioButtonStateInfo: make(chan RadioButtonStateInfo, 1),
-			},
-			SleepHandle: SleepHandle{
-				goSleep: make(chan bool, 1),
-				sleep:   false,
-			},
-		}
+		// A reload publishes its config to the calls already listed
+		rtspClient.cfgMutex.RLock()
+		callInfo := rtspClient.newCallInfo(Key)
 		callInfo.Lock()
 		rtspClient.callModel.listCallInfo.Set(Key, callInfo)
 		callInfo.Unlock()
+		rtspClient.cfgMutex.RUnlock()
+		rtspClient.calls.add()
 		go callInfo.runInner()
 		return callInfo, constant.NON_BLOCK
 	}
 }
 
+// newCallInfo creates the state of a new call, whose goroutines are not
+// started yet.
+func (rtspClient *RTSPClient) newCallInfo(Key CallKey) CallInfo {
+	chDone := make(chan bool)
+	return CallInfo{
+		CallKey:    Key,
+		rtspClient: rtspClient,
+		RTPClient: RTPClient{
+			chUpdateListenConn: make(chan int, 2),
+			chRecordRTP:        make(chan bool, 1),
+			chPushRTP:          make(chan rtp.Packet, pushQueueSize),
+			pcm:                NewPCMPacketizer(),
+			stats:              &RTPStats{},
+		},
+		ThreadHandle: ThreadHandle{
+			chDone:   chDone,
+			doneOnce: &sync.Once{},
+			wg:       &sync.WaitGroup{},
+		},
+		EventQueue: EventQueue{
+			events:        NewCallEventQueue(rtspClient.EventQueueSize, rtspClient.EventQueuePolicy, chDone, rtspClient.eventCounts),
+			debounced:     NewCallEventQueue(debouncedQueueSize, constant.QUEUE_BLOCK, chDone, &eventCounts{}),
+			chLostSession: make(chan *gortsplib.Client, 10),
+			chReconnect:   make(chan string, 10),
+			chRecover:     make(chan *journalCall, 1),
+		},
+		SleepHandle: SleepHandle{
+			goSleep: make(chan bool, 1),
+			sleep:   false,
+			after:   time.After,
+		},
+		crdHistory: NewCRDHistory(),
+		failover:   NewCallFailover(),
+		reconnect:  NewCallReconnect(),
+		cfg:        newCallCfg(rtspClient.Config),
+		timings:    rtspClient.Config.timingsOf(Key.RecorderType),
+	}
+}
+
 func (rtspClient *RTSPClient) GetCallInfoIfExist(Key CallKey) (CallInfo, bool) {
 	if rtspClient.callModel.listCallInfo.Has(Key) {
 		callInfo, _ := rtspClient.callModel.listCallInfo.Get(Key)
 		return callInfo, true
 	}
 	return CallInfo{
-		CallKey: Key,
+		CallKey:    Key,
+		rtspClient: rtspClient,
 	}, false
 }
 
@@ -122,70 +200,290 @@ func (rtspClient *RTSPClient) SetReloadState(ReloadState constant.ReloadState) {
 	rtspClient.ReloadState = ReloadState
 }
 
+// cfgFiles returns the candidate paths of rec.cfg and device_system.cfg,
+// in the order they are tried.
+func (rtspClient *RTSPClient) cfgFiles() ([]string, []string) {
+	if rtspClient.configDir == "" {
+		return []string{string(constant.REC_CFG_FILE), string(constant.ALT_REC_CFG_FILE)},
+			[]string{string(constant.DEV_SYS_CFG_FILE), string(constant.ALT_DEV_SYS_CFG_FILE)}
+	}
+	return []string{filepath.Join(rtspClient.configDir, string(constant.REC_CFG_NAME))},
+		[]string{filepath.Join(rtspClient.configDir, string(constant.DEV_SYS_CFG_NAME))}
+}
+
+// recJSONFiles returns the candidate paths of rec.json, in the order they are
+// tried.
+func (rtspClient *RTSPClient) recJSONFiles() []string {
+	if rtspClient.configDir == "" {
+		return []string{string(constant.REC_JSON_CFG_FILE), string(constant.ALT_REC_JSON_CFG_FILE)}
+	}
+	return []string{filepath.Join(rtspClient.configDir, string(constant.REC_JSON_CFG_NAME))}
+}
+
+func (rtspClient *RTSPClient) readCfgFile(paths []string) ([]byte, string) {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, path
+		}
+		rtspClient.LogInfo("Could not load", "path", path, "err", err)
+	}
+	return nil, ""
+}
+
+// loadCfg reads the config files into cfg. rec.json is used if it exists,
+// rec.cfg and device_system.cfg otherwise. An error in rec.json rejects the
+// whole file, an error in the legacy files only rejects its recorder entry.
+func (rtspClient *RTSPClient) loadCfg(cfg *Config) CfgReport {
+	for _, path := range rtspClient.recJSONFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		recFile, issues := parseRecJSON(data, path)
+		report := CfgReport{Files: []string{path}, Issues: issues}
+		if len(issues.Errors()) != 0 {
+			return report
+		}
+		rtspClient.watchTLSFiles(recFile.Recorders)
+		recFile.Recorders, issues = loadTLSConfigs(recFile.Recorders)
+		report.Issues = append(report.Issues, issues...)
+		if len(issues) != 0 {
+			return report
+		}
+		cfg.LoadRecFile(recFile)
+		cfg.CheckDupConfig()
+		report.Applied = true
+		report.Channels = cfg.MaxCh
+		return report
+	}
+	recFiles, devSysFiles := rtspClient.cfgFiles()
+	recData, recPath := rtspClient.readCfgFile(recFiles)
+	devSysData, devSysPath := rtspClient.readCfgFile(devSysFiles)
+	recFile, issues := ParseLegacyRecFile(recData, recPath)
+	devSysRecorders, devSysIssues := ParseLegacyDevSysFile(devSysData, devSysPath)
+	recFile.Recorders = append(recFile.Recorders, devSysRecorders...)
+	issues = append(issues, devSysIssues...)
+	issues = append(issues, checkFailoverGroups(recFile.Recorders)...)
+	issues = append(issues, checkDupAddrs(recFile.Recorders)...)
+	var tlsIssues CfgIssues
+	rtspClient.watchTLSFiles(recFile.Recorders)
+	recFile.Recorders, tlsIssues = loadTLSConfigs(recFile.Recorders)
+	issues = append(issues, tlsIssues...)
+	cfg.LoadRecFile(recFile)
+	cfg.CheckDupConfig()
+	report := CfgReport{Applied: true, Channels: cfg.MaxCh, Issues: issues}
+	for _, path := range []string{recPath, devSysPath} {
+		if path != "" {
+			report.Files = append(report.Files, path)
+		}
+	}
+	return report
+}
+
+// watchTLSFiles has the watcher, if any, reload the config when the
+// certificate files of recorders change.
+func (rtspClient *RTSPClient) watchTLSFiles(recorders []RecorderCfg) {
+	if rtspClient.watcher != nil {
+		rtspClient.watcher.setTLSFiles(tlsFiles(recorders))
+	}
+}
+
+// LoadRecConfig reads the config files and applies them, unless they are
+// rejected. The issues found are logged and returned in the report.
+func (rtspClient *RTSPClient) LoadRecConfig() CfgReport {
+	return rtspClient.loadRecConfig(false)
+}
+
+// onCfgFilesChanged reloads the config after the watcher saw its files
+// change. Any error rejects the config, as a file still being written or
+// saved with a mistake would otherwise drop recorders.
+func (rtspClient *RTSPClient) onCfgFilesChanged() {
+	if rtspClient.GetReloadState() == constant.SHUTDOWN_RELOAD {
+		return
+	}
+	rtspClient.LogInfo("Config files changed, reloading")
+	rtspClient.loadRecConfig(true)
+}
+
+// CfgReport returns the report of the last config load.
+func (rtspClient *RTSPClient) CfgReport() CfgReport {
+	rtspClient.ReloadMutex.RLock()
+	defer rtspClient.ReloadMutex.RUnlock()
+	return rtspClient.cfgReport
+}
+
+func (rtspClient *RTSPClient) loadRecConfig(strict bool) CfgReport {
+	newCfg := NewCfg()
+	report := rtspClient.loadCfg(newCfg)
+	if strict && len(report.Issues.Errors()) != 0 {
+		report.Applied = false
+		report.Channels = 0
+	}
+	for _, issue := range report.Issues {
+		if issue.Severity == constant.CFG_ERROR {
+			rtspClient.LogError("Recorder config", "issue", issue.Error())
+		} else {
+			rtspClient.LogWarn("Recorder config", "issue", issue.Error())
+		}
+	}
+	if !report.Applied {
+		rtspClient.LogError("Invalid recorder config, keeping the current one")
+		rtspClient.ReloadMutex.Lock()
+		rtspClient.cfgReport = report
+		rtspClient.ReloadMutex.Unlock()
+		return report
+	}
+
+	rtspClient.resolver.setHosts(newCfg.recAddrs)
+	rtspClient.ReloadMutex.Lock()
+	defer rtspClient.ReloadMutex.Unlock()
+	rtspClient.cfgReport = report
+	rtspClient.cfgMutex.RLock()
+	changes := cfgChanges(rtspClient.Config, newCfg)
+	rtspClient.cfgMutex.RUnlock()
+	if len(changes) == 0 {
+		rtspClient.LogInfo("Recorder config unchanged")
+	}
+	for _, change := range changes {
+		rtspClient.LogInfo("Recorder config", "change", change)
+	}
+	if rtspClient.ReloadState != constant.NON_RELOAD {
+		rtspClient.saveCfg = newCfg
+		rtspClient.LogInfo(newCfg.String())
+	} else {
+		rtspClient.LogInfo(newCfg.String())
+		rtspClient.reloadConfig(newCfg)
+	}
+	rtspClient.LogDebug("Load rec.cfg successfully")
+	return report
+}
+
+// callCounter counts the calls whose goroutines are running. Waiting for them
+// needs no goroutine of its own, so a wait that times out leaves nothing
+// behind.
+type callCounter struct {
+	mutex  *sync.Mutex
+	active int
+	// Closed once no call is running
+	released chan struct{}
+}
+
+func newCallCounter() *callCounter {
+	released := make(chan struct{})
+	close(released)
+	return &callCounter{
+		mutex:    &sync.Mutex{},
+		released: released,
+	}
+}
+
+func (counter *callCounter) add() {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	if counter.active == 0 {
+		counter.released = make(chan struct{})
+	}
+	counter.active++
+}
+
+func (counter *callCounter) done() {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.active--
+	if counter.active == 0 {
+		close(counter.released)
+	}
+}
+
+// waitChan returns a channel closed once no call is running.
+func (counter *callCounter) waitChan() <-chan struct{} {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.released
+}
+
+// waitCallsReleased waits until every CallInfo goroutine has finished or the
+// timeout expires, and returns the number of calls still active.
+func (rtspClient *RTSPClient) waitCallsReleased(timeout time.Duration) int {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-rtspClient.calls.waitChan():
+		return 0
+	case <-timer.C:
+		return rtspClient.callModel.listCallInfo.Count()
+	}
+}
+
 func (rtspClient *RTSPClient) waitForRealeaseCall() {
-	maxWaitDuration := 4 * time.Second
-	intervalDuration := 50 * time.Millisecond
-	maxWait := time.After(maxWaitDuration)
-	interval := time.After(0)
 	defer rtspClient.updateConfigAfterReload()
-	for {
-		select {
-		case <-maxWait:
-			rtspClient.LogWarn("Time waiting for all callInfo to release has been expired, pottentally have future errors")
-			return
-		case <-interval:
-			if rtspClient.GetReloadState() == constant.NORMAL_RELOAD && rtspClient.cs.listClient.IsEmpty() && rtspClient.crds.listCRD.IsEmpty() && rtspClient.callModel.listCallInfo.IsEmpty() {
-				rtspClient.LogDebug("All calls have been release successfully")
-				return
-			}
-			interval = time.After(intervalDuration)
-		}
+	if leftCalls := rtspClient.waitCallsReleased(rtspClient.ReleaseTimeout()); leftCalls != 0 {
+		rtspClient.LogWarn("Time waiting for all callInfo to release has been expired", "calls", leftCalls)
+		return
 	}
+	rtspClient.LogDebug("All calls have been release successfully")
 }
 
 func (rtspClient *RTSPClient) updateConfigAfterReload() {
 	rtspClient.ReloadMutex.Lock()
 	defer rtspClient.ReloadMutex.Unlock()
-	rtspClient.Reset()
-	rtspClient.Config = saveCfg.Copy()
+	rtspClient.cfgMutex.Lock()
+	defer rtspClient.cfgMutex.Unlock()
+	rtspClient.Config = rtspClient.saveCfg.Copy()
 	rtspClient.CheckDupConfig()
-	saveCfg.Reset()
+	// The calls not released in time go on with it
+	rtspClient.publishConfig()
+	rtspClient.saveCfg.Reset()
 	rtspClient.ReloadState = constant.NON_RELOAD
 }
 
-func (rtspClient *RTSPClient) StopAllCall() {
+// StopAllCall ends every active call and applies the config loaded in the
+// meantime once they are released. It returns EVENT_QUEUE_FULL if the event
+// ending one of the calls could not be queued within the release timeout.
+func (rtspClient *RTSPClient) StopAllCall() constant.EventResult {
 	rtspClient.SetReloadState(constant.NORMAL_RELOAD)
+	ctx, cancel := context.WithTimeout(context.Background(), rtspClient.ReleaseTimeout())
+	leftCalls := rtspClient.stopAllCallInner(ctx)
+	cancel()
+	go rtspClient.waitForRealeaseCall()
+	if leftCalls != 0 {
+		rtspClient.LogWarn("Could not stop calls, their event queue is full", "calls", leftCalls)
+		return constant.EVENT_QUEUE_FULL
+	}
+	return constant.EVENT_ACCEPTED
+}
+
+// stopAllCallInner queues the event that ends each active call and returns
+// the number of calls whose event queue was full until ctx was done.
+func (rtspClient *RTSPClient) stopAllCallInner(ctx context.Context) int {
+	leftCalls := 0
 	for it := range rtspClient.callModel.listCallInfo.IterBuffered() {
 		callInfo := it.Val
 		if callInfo.blockState != constant.NON_BLOCK {
 			continue
 		}
-		switch callInfo.RecorderType {
-		case constant.RET_PHONE:
-			select {
-			case callInfo.chCallStateInfo <- CallStateInfo{state: constant.PJSIP_INV_STATE_DISCONNECTED}:
-				callInfo.setBlockState(constant.NORMAL_BLOCK)
-			default:
-			}
-		case constant.RET_RADIO_TX, constant.RET_RADIO_RX:
-			select {
-			case callInfo.chRadioButtonStateInfo <- RadioButtonStateInfo{state: constant.BUTTON_INVALID}:
-				callInfo.setBlockState(constant.NORMAL_BLOCK)
-			default:
-			}
-		case constant.RET_BRIEF:
-			select {
-			case callInfo.chBriefStateInfo <- BriefStateInfo{state: constant.BRIEF_FALSE}:
-				callInfo.setBlockState(constant.NORMAL_BLOCK)
-			default:
-			}
-		case constant.RET_AMBIENT:
-			select {
-			case callInfo.chGroupStateInfo <- GroupStateInfo{state: constant.GROUP_FALSE}:
-				callInfo.setBlockState(constant.NORMAL_BLOCK)
-			default:
-			}
+		if !callInfo.stopCall(ctx) {
+			leftCalls++
 		}
 	}
-	go rtspClient.waitForRealeaseCall()
+	return leftCalls
+}
+
+// stopCall queues the event that ends the call. It returns false if the event
+// queue was full until ctx was done.
+func (callInfo CallInfo) stopCall(ctx context.Context) bool {
+	var result constant.EventResult
+	switch callInfo.RecorderType {
+	case constant.RET_PHONE:
+		result = callInfo.HandleCallState(ctx, constant.PJSIP_INV_STATE_DISCONNECTED, nil)
+	case constant.RET_RADIO_TX, constant.RET_RADIO_RX:
+		result = callInfo.HandleRadioButtonState(ctx, constant.BUTTON_INVALID, nil)
+	case constant.RET_BRIEF:
+		result = callInfo.HandleBriefState(ctx, constant.BRIEF_FALSE, nil)
+	case constant.RET_AMBIENT, constant.RET_PHONE_GROUP, constant.RET_RADIO_GROUP, constant.RET_BRIEF_GROUP:
+		result = callInfo.HandleGroupState(ctx, constant.GROUP_FALSE, nil)
+	}
+	// A call that ended already needs no event
+	return result != constant.EVENT_QUEUE_FULL
 }
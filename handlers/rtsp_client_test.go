@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// newTestRTSPClient returns a client with the default options whose config
+// directory is empty, so it reads none of the files of the host. It is shut
+// down when the test ends.
+func newTestRTSPClient(t *testing.T) *RTSPClient {
+	t.Helper()
+	rtspClient := NewRTSPClient(t.TempDir(), DefaultOptions(), utils.CreateZapLogger())
+	t.Cleanup(func() {
+		rtspClient.Shutdown(time.Second)
+	})
+	return rtspClient
+}
+
+// testCfg returns the config of the rec.json document recJSON.
+func testCfg(t *testing.T, recJSON string) *Config {
+	t.Helper()
+	recFile, issues := parseRecJSON([]byte(recJSON), "rec.json")
+	if len(issues.Errors()) != 0 {
+		t.Fatal(issues)
+	}
+	cfg := NewCfg()
+	cfg.LoadRecFile(recFile)
+	cfg.CheckDupConfig()
+	return cfg
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dvrs.lib/RTSPClient/constant"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+)
+
+// recorderSecurity is how the signalling to a recorder is authenticated and
+// encrypted.
+type recorderSecurity struct {
+	username string
+	password string
+	// any, digest or basic
+	auth     string
+	rtsps    bool
+	caFile   string
+	certFile string
+	keyFile  string
+	// Hash of the contents of the CA, certificate and key files, so a file
+	// rewritten in place is seen as a change
+	tlsHash string
+}
+
+// String describes the settings without the password.
+func (security recorderSecurity) String() string {
+	str := "rtsp"
+	if security.rtsps {
+		str = "rtsps"
+	}
+	if security.username != "" {
+		str += ", user " + security.username + " (" + security.auth + " auth)"
+	}
+	if security.caFile != "" {
+		str += ", CA " + security.caFile
+	}
+	if security.certFile != "" {
+		str += ", certificate " + security.certFile
+	}
+	return str
+}
+
+func (security recorderSecurity) scheme() string {
+	if security.rtsps {
+		return "rtsps"
+	}
+	return "rtsp"
+}
+
+// authMethods returns the authentication methods the recorder may ask for,
+// nil for any.
+func (security recorderSecurity) authMethods() []headers.AuthMethod {
+	switch security.auth {
+	case "digest":
+		return []headers.AuthMethod{headers.AuthDigest}
+	case "basic":
+		return []headers.AuthMethod{headers.AuthBasic}
+	default:
+		return nil
+	}
+}
+
+func (recorderCfg RecorderCfg) security() recorderSecurity {
+	auth := recorderCfg.Auth
+	if auth == "" {
+		auth = "any"
+	}
+	return recorderSecurity{
+		username: recorderCfg.Username,
+		password: recorderCfg.Password,
+		auth:     auth,
+		rtsps:    recorderCfg.Rtsps,
+		caFile:   recorderCfg.cfgPath(recorderCfg.CAFile),
+		certFile: recorderCfg.cfgPath(recorderCfg.CertFile),
+		keyFile:  recorderCfg.cfgPath(recorderCfg.KeyFile),
+		tlsHash:  recorderCfg.tlsHash,
+	}
+}
+
+// cfgPath returns path relative to the directory of the config file of the
+// recorder.
+func (recorderCfg RecorderCfg) cfgPath(path string) string {
+	if path == "" || filepath.IsAbs(path) || recorderCfg.file == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(recorderCfg.file), path)
+}
+
+// checkSecurity reports the authentication and TLS settings that do not go
+// together. The keys are prefixed with prefix.
+func (recorderCfg RecorderCfg) checkSecurity(prefix string) CfgIssues {
+	var issues CfgIssues
+	add := func(severity constant.CfgSeverity, issueType constant.CfgIssueType, key string, msg string) {
+		issues = append(issues, CfgIssue{Severity: severity, Type: issueType, File: recorderCfg.file, Line: recorderCfg.line, Recorder: recorderCfg.index, Path: prefix + key, Msg: msg})
+	}
+	if recorderCfg.Password != "" && recorderCfg.Username == "" {
+		add(constant.CFG_ERROR, constant.CFG_MISSING_KEY, "username", "a password needs a username")
+	}
+	if recorderCfg.CertFile != "" && recorderCfg.KeyFile == "" {
+		add(constant.CFG_ERROR, constant.CFG_MISSING_KEY, "key_file", "a client certificate needs its key")
+	}
+	if recorderCfg.KeyFile != "" && recorderCfg.CertFile == "" {
+		add(constant.CFG_ERROR, constant.CFG_MISSING_KEY, "cert_file", "a client key needs its certificate")
+	}
+	if recorderCfg.Rtsps {
+		if recorderCfg.MediaTransport != "tcp" {
+			add(constant.CFG_ERROR, constant.CFG_INVALID_VALUE, "media_transport", "rtsps needs media_transport tcp, the media is sent in the TLS connection")
+		}
+	} else {
+		if recorderCfg.CAFile != "" || recorderCfg.CertFile != "" {
+			add(constant.CFG_WARNING, constant.CFG_INVALID_VALUE, "rtsps", "the CA and client certificate are only used with rtsps")
+		}
+		if recorderCfg.Username != "" && recorderCfg.Auth == "basic" {
+			add(constant.CFG_WARNING, constant.CFG_INVALID_VALUE, "auth", "basic auth without rtsps sends the password in clear text")
+		}
+	}
+	if recorderCfg.Username == "" && recorderCfg.Auth != "" && recorderCfg.Auth != "any" {
+		add(constant.CFG_WARNING, constant.CFG_INVALID_VALUE, "auth", "unused without username")
+	}
+	return issues
+}
+
+// tlsConfig reads the CA and client certificate files of the recorder. It
+// also returns the hash of what was read.
+func (security recorderSecurity) tlsConfig() (*tls.Config, string, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	hash := sha256.New()
+	if security.caFile != "" {
+		caPEM, err := os.ReadFile(security.caFile)
+		if err != nil {
+			return nil, "", err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, "", fmt.Errorf("no PEM certificate found in %s", security.caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+		hash.Write(caPEM)
+	}
+	if security.certFile != "" {
+		certPEM, err := os.ReadFile(security.certFile)
+		if err != nil {
+			return nil, "", err
+		}
+		keyPEM, err := os.ReadFile(security.keyFile)
+		if err != nil {
+			return nil, "", err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		hash.Write(certPEM)
+		hash.Write(keyPEM)
+	}
+	return tlsConfig, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// tlsFiles returns the certificate files of the enabled rtsps recorders.
+func tlsFiles(recorders []RecorderCfg) []string {
+	var files []string
+	for _, recorderCfg := range recorders {
+		if !recorderCfg.Enable || !recorderCfg.Rtsps {
+			continue
+		}
+		security := recorderCfg.security()
+		for _, file := range []string{security.caFile, security.certFile, security.keyFile} {
+			if file != "" {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// loadTLSConfigs reads the certificate files of the enabled rtsps recorders.
+// The recorders whose files can not be read are left out and reported.
+func loadTLSConfigs(recorders []RecorderCfg) ([]RecorderCfg, CfgIssues) {
+	loaded := []RecorderCfg{}
+	var issues CfgIssues
+	for _, recorderCfg := range recorders {
+		if recorderCfg.Enable && recorderCfg.Rtsps {
+			tlsConfig, tlsHash, err := recorderCfg.security().tlsConfig()
+			if err != nil {
+				issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: recorderCfg.file, Line: recorderCfg.line, Recorder: recorderCfg.index, Msg: err.Error()})
+				continue
+			}
+			recorderCfg.tlsConfig = tlsConfig
+			recorderCfg.tlsHash = tlsHash
+		}
+		loaded = append(loaded, recorderCfg)
+	}
+	return loaded, issues
+}
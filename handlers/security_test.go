@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed CA certificate named cn to path.
+func writeCA(t *testing.T, path string, cn string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateRewrittenInPlace(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	recJSON := []byte(`{"recorders": [{"address": "rec1", "rtsps": true, "media_transport": "tcp", "ca_file": "ca.pem"}]}`)
+	load := func() *Config {
+		recFile, issues := parseRecJSON(recJSON, filepath.Join(dir, "rec.json"))
+		if len(issues.Errors()) != 0 {
+			t.Fatal(issues)
+		}
+		if got := tlsFiles(recFile.Recorders); len(got) != 1 || got[0] != caFile {
+			t.Fatalf("got TLS files %v, want %s", got, caFile)
+		}
+		recFile.Recorders, issues = loadTLSConfigs(recFile.Recorders)
+		if len(issues) != 0 {
+			t.Fatal(issues)
+		}
+		cfg := NewCfg()
+		cfg.LoadRecFile(recFile)
+		cfg.CheckDupConfig()
+		return cfg
+	}
+
+	writeCA(t, caFile, "first")
+	oldCfg := load()
+	if diff := diffConfig(oldCfg, load()); len(diff.kept) != 1 {
+		t.Fatalf("unchanged certificate restarts the channel: %+v", diff)
+	}
+	writeCA(t, caFile, "second")
+	newCfg := load()
+	if diff := diffConfig(oldCfg, newCfg); len(diff.kept) != 0 || len(diff.removed) != 1 || len(diff.added) != 1 {
+		t.Fatalf("rewritten certificate keeps the channel: %+v", diff)
+	}
+	if changes := cfgChanges(oldCfg, newCfg); len(changes) != 1 || !strings.Contains(changes[0], "certificates changed") {
+		t.Fatalf("got changes %v", changes)
+	}
+}
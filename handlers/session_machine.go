@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+type sessionTransition struct {
+	from constant.RTSPState
+	to   constant.RTSPState
+}
+
+// SessionMachine is the transition table of the recorder sessions of a
+// recorder type and ED-137 version: the state changes allowed and the request
+// each one sends.
+type SessionMachine struct {
+	recorderType constant.RecorderType
+	ed137Version string
+	transitions  map[sessionTransition]constant.SessionRequest
+}
+
+// NewSessionMachine declares the transitions of the sessions of recorderType
+// to a recorder of ed137Version. A session is connected, announced and set up,
+// then recorded and paused: a phone call on hold is signalled with a
+// SET_PARAMETER, the other sessions with PAUSE. A phone session staying in
+// PAUSE or RECORD sends its CRD with a SET_PARAMETER, in RECORD only when the
+// CRD changed, see Client.Record. A session may fail in any state, and is
+// torn down with its last CRD by ED137C.
+func NewSessionMachine(recorderType constant.RecorderType, ed137Version string) *SessionMachine {
+	teardown := constant.REQUEST_TEARDOWN
+	if ed137Version == "ED137C" {
+		teardown = constant.REQUEST_CRD_TEARDOWN
+	}
+	transitions := map[sessionTransition]constant.SessionRequest{
+		{constant.RTSP_STATE_NULL, constant.RTSP_STATE_START}:       constant.REQUEST_CONNECT,
+		{constant.RTSP_STATE_DISCONNECT, constant.RTSP_STATE_START}: constant.REQUEST_CONNECT,
+		{constant.RTSP_STATE_START, constant.RTSP_STATE_ANNOUNCE}:   constant.REQUEST_ANNOUNCE,
+		{constant.RTSP_STATE_ANNOUNCE, constant.RTSP_STATE_SETUP}:   constant.REQUEST_SETUP,
+		{constant.RTSP_STATE_SETUP, constant.RTSP_STATE_RECORD}:     constant.REQUEST_RECORD,
+		// Failed before the session was set up
+		{constant.RTSP_STATE_NULL, constant.RTSP_STATE_DISCONNECT}:       constant.REQUEST_NONE,
+		{constant.RTSP_STATE_START, constant.RTSP_STATE_DISCONNECT}:      constant.REQUEST_NONE,
+		{constant.RTSP_STATE_ANNOUNCE, constant.RTSP_STATE_DISCONNECT}:   constant.REQUEST_NONE,
+		{constant.RTSP_STATE_DISCONNECT, constant.RTSP_STATE_DISCONNECT}: constant.REQUEST_NONE,
+		{constant.RTSP_STATE_SETUP, constant.RTSP_STATE_DISCONNECT}:      teardown,
+		{constant.RTSP_STATE_RECORD, constant.RTSP_STATE_DISCONNECT}:     teardown,
+		{constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_DISCONNECT}:      teardown,
+	}
+	if recorderType == constant.RET_PHONE {
+		transitions[sessionTransition{constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE}] = constant.REQUEST_SET_PARAMETER
+		transitions[sessionTransition{constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_RECORD}] = constant.REQUEST_SET_PARAMETER
+		transitions[sessionTransition{constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_PAUSE}] = constant.REQUEST_SET_PARAMETER
+		transitions[sessionTransition{constant.RTSP_STATE_RECORD, constant.RTSP_STATE_RECORD}] = constant.REQUEST_SET_PARAMETER
+	} else {
+		transitions[sessionTransition{constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE}] = constant.REQUEST_PAUSE
+		transitions[sessionTransition{constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_RECORD}] = constant.REQUEST_RECORD
+	}
+	return &SessionMachine{
+		recorderType: recorderType,
+		ed137Version: ed137Version,
+		transitions:  transitions,
+	}
+}
+
+// sessionMachines are the machines of every recorder type and ED-137 version,
+// the versions other than ED137A and ED137C being handled as ED137B.
+var sessionMachines = func() map[constant.RecorderType]map[string]*SessionMachine {
+	machines := make(map[constant.RecorderType]map[string]*SessionMachine)
+	for _, recorderType := range []constant.RecorderType{constant.RET_PHONE, constant.RET_RADIO_TX, constant.RET_RADIO_RX, constant.RET_BRIEF,
+		constant.RET_AMBIENT, constant.RET_PHONE_GROUP, constant.RET_RADIO_GROUP, constant.RET_BRIEF_GROUP} {
+		machines[recorderType] = make(map[string]*SessionMachine)
+		for _, ed137Version := range []string{"ED137A", "ED137B", "ED137C"} {
+			machines[recorderType][ed137Version] = NewSessionMachine(recorderType, ed137Version)
+		}
+	}
+	return machines
+}()
+
+func sessionMachineOf(recorderType constant.RecorderType, ed137Version string) *SessionMachine {
+	if machine, ok := sessionMachines[recorderType][ed137Version]; ok {
+		return machine
+	}
+	if machine, ok := sessionMachines[recorderType]["ED137B"]; ok {
+		return machine
+	}
+	return NewSessionMachine(recorderType, "ED137B")
+}
+
+// Request returns the request sent to go from one state to the other, and
+// false if the transition is not allowed. Staying in a state sends nothing,
+// unless the machine declares a request for it.
+func (machine *SessionMachine) Request(from constant.RTSPState, to constant.RTSPState) (constant.SessionRequest, bool) {
+	request, ok := machine.transitions[sessionTransition{from, to}]
+	if !ok && from == to && to != constant.RTSP_STATE_DISCONNECT {
+		return constant.REQUEST_NONE, true
+	}
+	return request, ok
+}
+
+// TransitionError is a state change of a recorder session that its machine
+// rejects. The session is left as it was.
+type TransitionError struct {
+	CallKey
+	Ch           int
+	From         constant.RTSPState
+	To           constant.RTSPState
+	ED137Version string
+}
+
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("%s session of %q on channel %d (%s) can not go from %s to %s", err.RecorderType, err.Name, err.Ch, err.ED137Version, err.From, err.To)
+}
+
+// IsTransitionError tells whether err is a rejected transition.
+func IsTransitionError(err error) bool {
+	var transitionErr *TransitionError
+	return errors.As(err, &transitionErr)
+}
+
+// TransitionEvent is a state change of a recorder session, or one that was
+// rejected.
+type TransitionEvent struct {
+	CallKey
+	Ch      int
+	From    constant.RTSPState
+	To      constant.RTSPState
+	Request constant.SessionRequest
+	// Cause of a change to DISCONNECT
+	ErrCode constant.StatusCode
+	// Set when the transition was rejected
+	Err *TransitionError
+}
+
+type TransitionObserver func(TransitionEvent)
+
+// SetTransitionObserver sets the function called with every transition of
+// the recorder sessions. It is called from the goroutines of the calls and
+// must not block.
+func (rtspClient *RTSPClient) SetTransitionObserver(transitionObserver TransitionObserver) {
+	rtspClient.statusMutex.Lock()
+	defer rtspClient.statusMutex.Unlock()
+	rtspClient.transitionObserver = transitionObserver
+}
+
+func (rtspClient *RTSPClient) notifyTransition(transitionEvent TransitionEvent) {
+	rtspClient.statusMutex.RLock()
+	transitionObserver := rtspClient.transitionObserver
+	rtspClient.statusMutex.RUnlock()
+	if transitionObserver != nil {
+		transitionObserver(transitionEvent)
+	}
+}
+
+// request returns the request the session sends to go to state to. A
+// rejected transition is logged, reported to the observer and returned as a
+// *TransitionError.
+func (c *Client) request(to constant.RTSPState) (constant.SessionRequest, error) {
+	request, ok := sessionMachineOf(c.RecorderType, c.ed137Version).Request(c.rtspState, to)
+	if ok {
+		return request, nil
+	}
+	err := &TransitionError{
+		CallKey:      c.CallKey,
+		Ch:           c.ch,
+		From:         c.rtspState,
+		To:           to,
+		ED137Version: c.ed137Version,
+	}
+	c.rtspClient.LogWarn("Rejected session transition", "name", c.Name, "recorderType", int(c.RecorderType), "channel", c.ch, "err", err)
+	c.rtspClient.notifyTransition(TransitionEvent{
+		CallKey: c.CallKey,
+		Ch:      c.ch,
+		From:    c.rtspState,
+		To:      to,
+		Err:     err,
+	})
+	return constant.REQUEST_NONE, err
+}
+
+// closeOnErr closes the session after a request failed. A transition the
+// machine rejected sent nothing, the session is left as it is.
+func (c *Client) closeOnErr(err error) {
+	if IsTransitionError(err) {
+		return
+	}
+	c.CloseByErr()
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"testing"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+func TestSessionMachineRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		recorderType constant.RecorderType
+		ed137Version string
+		from         constant.RTSPState
+		to           constant.RTSPState
+		want         constant.SessionRequest
+		ok           bool
+	}{
+		{"connect", constant.RET_RADIO_TX, "ED137B", constant.RTSP_STATE_NULL, constant.RTSP_STATE_START, constant.REQUEST_CONNECT, true},
+		{"record after setup", constant.RET_PHONE, "ED137B", constant.RTSP_STATE_SETUP, constant.RTSP_STATE_RECORD, constant.REQUEST_RECORD, true},
+		{"phone on hold", constant.RET_PHONE, "ED137B", constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE, constant.REQUEST_SET_PARAMETER, true},
+		{"phone resumed", constant.RET_PHONE, "ED137B", constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_RECORD, constant.REQUEST_SET_PARAMETER, true},
+		{"phone still on hold", constant.RET_PHONE, "ED137B", constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_PAUSE, constant.REQUEST_SET_PARAMETER, true},
+		{"phone still recording", constant.RET_PHONE, "ED137A", constant.RTSP_STATE_RECORD, constant.RTSP_STATE_RECORD, constant.REQUEST_SET_PARAMETER, true},
+		{"radio released", constant.RET_RADIO_TX, "ED137B", constant.RTSP_STATE_RECORD, constant.RTSP_STATE_PAUSE, constant.REQUEST_PAUSE, true},
+		{"radio pressed", constant.RET_RADIO_RX, "ED137B", constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_RECORD, constant.REQUEST_RECORD, true},
+		{"radio still paused", constant.RET_RADIO_TX, "ED137B", constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_PAUSE, constant.REQUEST_NONE, true},
+		{"radio still recording", constant.RET_RADIO_TX, "ED137B", constant.RTSP_STATE_RECORD, constant.RTSP_STATE_RECORD, constant.REQUEST_NONE, true},
+		{"teardown", constant.RET_BRIEF, "ED137B", constant.RTSP_STATE_RECORD, constant.RTSP_STATE_DISCONNECT, constant.REQUEST_TEARDOWN, true},
+		{"teardown with CRD", constant.RET_BRIEF, "ED137C", constant.RTSP_STATE_PAUSE, constant.RTSP_STATE_DISCONNECT, constant.REQUEST_CRD_TEARDOWN, true},
+		{"record before setup", constant.RET_PHONE, "ED137B", constant.RTSP_STATE_START, constant.RTSP_STATE_RECORD, constant.REQUEST_NONE, false},
+		{"pause before record", constant.RET_RADIO_TX, "ED137B", constant.RTSP_STATE_SETUP, constant.RTSP_STATE_PAUSE, constant.REQUEST_NONE, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request, ok := sessionMachineOf(tc.recorderType, tc.ed137Version).Request(tc.from, tc.to)
+			if request != tc.want || ok != tc.ok {
+				t.Fatalf("%s to %s: got %v %v, want %v %v", tc.from, tc.to, request, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
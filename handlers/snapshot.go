@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+// RTPStats counts the RTP packets of a call or a recorder session. It is
+// shared by pointer so the copies kept in the concurrent maps see the same
+// counters.
+type RTPStats struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	errors  atomic.Uint64
+}
+
+func (stats *RTPStats) add(n int) {
+	if stats == nil {
+		return
+	}
+	stats.packets.Add(1)
+	stats.bytes.Add(uint64(n))
+}
+
+func (stats *RTPStats) addErr() {
+	if stats == nil {
+		return
+	}
+	stats.errors.Add(1)
+}
+
+type StatsSnapshot struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+	Errors  uint64 `json:"errors"`
+}
+
+func (stats *RTPStats) snapshot() StatsSnapshot {
+	if stats == nil {
+		return StatsSnapshot{}
+	}
+	return StatsSnapshot{
+		Packets: stats.packets.Load(),
+		Bytes:   stats.bytes.Load(),
+		Errors:  stats.errors.Load(),
+	}
+}
+
+type ChannelSnapshot struct {
+	Ch        int           `json:"ch"`
+	GroupName string        `json:"group_name,omitempty"`
+	RecAddr   string        `json:"rec_addr"`
+	Codec     string        `json:"codec,omitempty"`
+	RTSPState string        `json:"rtsp_state"`
+	URL       string        `json:"url,omitempty"`
+	LastCRD   string        `json:"last_crd,omitempty"`
+	Sent      StatsSnapshot `json:"sent"`
+}
+
+type CallSnapshot struct {
+	Name         string             `json:"name"`
+	RecorderType string             `json:"recorder_type"`
+	Sleep        bool               `json:"sleep"`
+	Blocked      bool               `json:"blocked"`
+	ListenPort   int                `json:"listen_port"`
+	Received     StatsSnapshot      `json:"received"`
+	Events       EventQueueSnapshot `json:"events"`
+	Debounced    int                `json:"debounced"`
+	Channels     []ChannelSnapshot  `json:"channels"`
+}
+
+type StateSnapshot struct {
+	Time      string `json:"time"`
+	Reloading bool   `json:"reloading"`
+	// Events dropped and coalesced since the start, ended calls included
+	EventsDropped   uint64         `json:"events_dropped"`
+	EventsCoalesced uint64         `json:"events_coalesced"`
+	Calls           []CallSnapshot `json:"calls"`
+}
+
+// GetStateSnapshot collects what the library knows about every active call
+// and its recorder sessions.
+func (rtspClient *RTSPClient) GetStateSnapshot() StateSnapshot {
+	snapshot := StateSnapshot{
+		Time:            time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Reloading:       rtspClient.GetReloadState() != constant.NON_RELOAD,
+		EventsDropped:   rtspClient.eventCounts.dropped.Load(),
+		EventsCoalesced: rtspClient.eventCounts.coalesced.Load(),
+		Calls:           []CallSnapshot{},
+	}
+	for it := range rtspClient.callModel.listCallInfo.IterBuffered() {
+		callInfo := it.Val
+		// The sessions of the call are numbered by the config it runs with
+		callInfo.cfg.mutex.RLock()
+		cfg := callInfo.cfg.config
+		callSnapshot := CallSnapshot{
+			Name:         callInfo.Name,
+			RecorderType: callInfo.RecorderType.String(),
+			Sleep:        callInfo.sleep,
+			Blocked:      callInfo.blockState != constant.NON_BLOCK,
+			ListenPort:   callInfo.ListenPort,
+			Received:     callInfo.stats.snapshot(),
+			Events:       callInfo.events.snapshot(),
+			Debounced:    callInfo.debounced.snapshot().Queued,
+			Channels:     []ChannelSnapshot{},
+		}
+		for i := 0; i < cfg.MaxCh; i++ {
+			key := ClientKey{
+				CallKey: callInfo.CallKey,
+				ch:      i,
+			}
+			c, ok := rtspClient.cs.listClient.Get(key)
+			if !ok {
+				continue
+			}
+			callSnapshot.Channels = append(callSnapshot.Channels, ChannelSnapshot{
+				Ch:        c.ch,
+				GroupName: c.groupName,
+				RecAddr:   cfg.recAddrs[i],
+				RTSPState: c.rtspState.String(),
+				Codec:     c.codec,
+				URL:       c.url,
+				LastCRD:   c.lastCRD,
+				Sent:      c.stats.snapshot(),
+			})
+		}
+		callInfo.cfg.mutex.RUnlock()
+		snapshot.Calls = append(snapshot.Calls, callSnapshot)
+	}
+	return snapshot
+}
+
+func (rtspClient *RTSPClient) GetStateSnapshotJSON() ([]byte, error) {
+	return json.Marshal(rtspClient.GetStateSnapshot())
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"sync"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+type StatusEvent struct {
+	CallKey
+	Ch        int
+	RTSPState constant.RTSPState
+	ErrCode   constant.StatusCode
+	// The gap a reconnection closed, with ErrCode STATUS_RECONNECTED
+	Gap *RecordingGap
+}
+
+type StatusHandler func(StatusEvent)
+
+type StatusNotifier struct {
+	statusHandler      StatusHandler
+	transitionObserver TransitionObserver
+	statusMutex        *sync.RWMutex
+}
+
+func (rtspClient *RTSPClient) SetStatusHandler(statusHandler StatusHandler) {
+	rtspClient.statusMutex.Lock()
+	defer rtspClient.statusMutex.Unlock()
+	rtspClient.statusHandler = statusHandler
+}
+
+func (rtspClient *RTSPClient) notifyStatus(statusEvent StatusEvent) {
+	rtspClient.statusMutex.RLock()
+	statusHandler := rtspClient.statusHandler
+	rtspClient.statusMutex.RUnlock()
+	if statusHandler != nil {
+		statusHandler(statusEvent)
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+// Timings are the delays the calls of a recorder type run with.
+type Timings struct {
+	// Time a phone call on hold or a radio whose button was released waits
+	// before its sessions pause, so a short hold or PTT release does not
+	// pause the recording. Only the phone and radio calls wait.
+	HangTimeMs int `json:"hang_time_ms"`
+	// Period of the reads of the RTP packets of a call
+	RTPPollMs int `json:"rtp_poll_ms"`
+	// Time each read waits for RTP packets, shorter than RTPPollMs
+	RTPReadMs int `json:"rtp_read_ms"`
+}
+
+func DefaultTimings() Timings {
+	return Timings{
+		HangTimeMs: 5000,
+		RTPPollMs:  200,
+		RTPReadMs:  30,
+	}
+}
+
+func (timings Timings) hangTime() time.Duration {
+	return time.Duration(timings.HangTimeMs) * time.Millisecond
+}
+
+func (timings Timings) rtpPoll() time.Duration {
+	return time.Duration(timings.RTPPollMs) * time.Millisecond
+}
+
+func (timings Timings) rtpRead() time.Duration {
+	return time.Duration(timings.RTPReadMs) * time.Millisecond
+}
+
+// TimingsCfg is the timings key of rec.json. The values given replace the
+// defaults for every recorder type, and those of RecorderTypes replace them
+// for one type, by lower case name, e.g. radio_tx.
+type TimingsCfg struct {
+	HangTimeMs *int `json:"hang_time_ms,omitempty"`
+	RTPPollMs  *int `json:"rtp_poll_ms,omitempty"`
+	RTPReadMs  *int `json:"rtp_read_ms,omitempty"`
+	// Replaces the release_timeout_ms option, for every call only
+	ReleaseTimeoutMs *int                  `json:"release_timeout_ms,omitempty"`
+	RecorderTypes    map[string]TimingsCfg `json:"recorder_types,omitempty"`
+	// Where the timings key was read
+	line int
+}
+
+var timingsRecorderTypes = []constant.RecorderType{constant.RET_PHONE, constant.RET_RADIO_TX, constant.RET_RADIO_RX, constant.RET_BRIEF,
+	constant.RET_AMBIENT, constant.RET_PHONE_GROUP, constant.RET_RADIO_GROUP, constant.RET_BRIEF_GROUP}
+
+func (timingsCfg TimingsCfg) apply(timings Timings) Timings {
+	if timingsCfg.HangTimeMs != nil {
+		timings.HangTimeMs = *timingsCfg.HangTimeMs
+	}
+	if timingsCfg.RTPPollMs != nil {
+		timings.RTPPollMs = *timingsCfg.RTPPollMs
+	}
+	if timingsCfg.RTPReadMs != nil {
+		timings.RTPReadMs = *timingsCfg.RTPReadMs
+	}
+	return timings
+}
+
+// resolve returns the timings of every recorder type.
+func (timingsCfg TimingsCfg) resolve() map[constant.RecorderType]Timings {
+	global := timingsCfg.apply(DefaultTimings())
+	resolved := make(map[constant.RecorderType]Timings)
+	for _, recorderType := range timingsRecorderTypes {
+		resolved[recorderType] = timingsCfg.RecorderTypes[strings.ToLower(recorderType.String())].apply(global)
+	}
+	return resolved
+}
+
+// check reports the recorder types whose RTP reads would not end before the
+// next one starts. The ranges of the values are checked by the schema.
+func (timingsCfg TimingsCfg) check(file string) CfgIssues {
+	var issues CfgIssues
+	resolved := timingsCfg.resolve()
+	for _, recorderType := range timingsRecorderTypes {
+		timings := resolved[recorderType]
+		if timings.RTPReadMs < timings.RTPPollMs {
+			continue
+		}
+		issues = append(issues, CfgIssue{Severity: constant.CFG_ERROR, Type: constant.CFG_INVALID_VALUE, File: file, Line: timingsCfg.line, Recorder: -1, Path: "timings",
+			Msg: fmt.Sprintf("rtp_read_ms (%d) must be less than rtp_poll_ms (%d) for %s", timings.RTPReadMs, timings.RTPPollMs, strings.ToLower(recorderType.String()))})
+	}
+	return issues
+}
+
+// timingsOf returns the timings of the calls of recorderType.
+func (cfg *Config) timingsOf(recorderType constant.RecorderType) Timings {
+	if timings, ok := cfg.timings[recorderType]; ok {
+		return timings
+	}
+	return DefaultTimings()
+}
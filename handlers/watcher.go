@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/utils"
+)
+
+// CfgWatcher calls onChange once the config files stop changing for the
+// debounce time. It watches the directories of the files, so a file replaced
+// by a rename or created later is seen too.
+type CfgWatcher struct {
+	mutex *sync.Mutex
+	// Cleaned paths of the files watched
+	files map[string]bool
+	// Cleaned paths of the certificate files the config refers to, see
+	// setTLSFiles
+	tlsFiles map[string]bool
+	// Directories watched
+	dirs     map[string]bool
+	debounce time.Duration
+	onChange func()
+	chEvent  chan struct{}
+	chStop   chan struct{}
+	stopOnce *sync.Once
+	// Closes the watch, set by watch
+	closer io.Closer
+	inotifyWatch
+	utils.Logger
+}
+
+func NewCfgWatcher(paths []string, debounce time.Duration, onChange func(), logger utils.Logger) (*CfgWatcher, error) {
+	watcher := &CfgWatcher{
+		mutex:    &sync.Mutex{},
+		files:    make(map[string]bool),
+		tlsFiles: make(map[string]bool),
+		dirs:     make(map[string]bool),
+		debounce: debounce,
+		onChange: onChange,
+		chEvent:  make(chan struct{}, 1),
+		chStop:   make(chan struct{}),
+		stopOnce: &sync.Once{},
+		Logger:   logger,
+	}
+	dirs := []string{}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		watcher.files[path] = true
+		if dir := filepath.Dir(path); !watcher.dirs[dir] {
+			watcher.dirs[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if err := watcher.watch(dirs); err != nil {
+		return nil, err
+	}
+	go watcher.run()
+	return watcher, nil
+}
+
+// setTLSFiles replaces the certificate files watched besides the config
+// files, so a certificate rewritten in place reloads the config too.
+func (watcher *CfgWatcher) setTLSFiles(paths []string) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	watcher.tlsFiles = make(map[string]bool)
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		watcher.tlsFiles[path] = true
+		if dir := filepath.Dir(path); !watcher.dirs[dir] {
+			if err := watcher.addDir(dir); err != nil {
+				watcher.LogInfo("Could not watch", "dir", dir, "err", err)
+				continue
+			}
+			watcher.dirs[dir] = true
+		}
+	}
+}
+
+// notify records a change of the file name in dir, if it is watched.
+func (watcher *CfgWatcher) notify(dir string, name string) {
+	path := filepath.Join(dir, name)
+	watcher.mutex.Lock()
+	watched := watcher.files[path] || watcher.tlsFiles[path]
+	watcher.mutex.Unlock()
+	if watched {
+		watcher.changed()
+	}
+}
+
+func (watcher *CfgWatcher) changed() {
+	select {
+	case watcher.chEvent <- struct{}{}:
+	default:
+	}
+}
+
+func (watcher *CfgWatcher) run() {
+	var chDebounce <-chan time.Time
+	for {
+		select {
+		case <-watcher.chEvent:
+			// Every write pushes the reload back, so a file being written is
+			// read once complete
+			chDebounce = time.After(watcher.debounce)
+		case <-chDebounce:
+			chDebounce = nil
+			watcher.onChange()
+		case <-watcher.chStop:
+			return
+		}
+	}
+}
+
+func (watcher *CfgWatcher) stop() {
+	watcher.stopOnce.Do(func() {
+		close(watcher.chStop)
+		watcher.closer.Close()
+	})
+}
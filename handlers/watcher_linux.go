@@ -0,0 +1,91 @@
+//go:build linux
+
+package handlers
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE
+
+// inotifyWatch is the inotify instance of a CfgWatcher.
+type inotifyWatch struct {
+	fd int
+	// Directory of each watch descriptor
+	wds map[int32]string
+}
+
+// watch starts an inotify watch on dirs. The directories that do not exist
+// are skipped.
+func (watcher *CfgWatcher) watch(dirs []string) error {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	wds := make(map[int32]string)
+	for _, dir := range dirs {
+		wd, err := unix.InotifyAddWatch(fd, dir, watchMask)
+		if err != nil {
+			watcher.LogInfo("Could not watch", "dir", dir, "err", err)
+			continue
+		}
+		wds[int32(wd)] = dir
+	}
+	if len(wds) == 0 {
+		unix.Close(fd)
+		return errors.New("none of the config directories can be watched")
+	}
+	// A non-blocking fd wrapped in an os.File is read through the runtime
+	// poller, so closing it stops readEvents
+	file := os.NewFile(uintptr(fd), "inotify")
+	watcher.closer = file
+	watcher.fd = fd
+	watcher.wds = wds
+	go watcher.readEvents(file)
+	return nil
+}
+
+// addDir watches one more directory. The caller holds the mutex of watcher.
+func (watcher *CfgWatcher) addDir(dir string) error {
+	wd, err := unix.InotifyAddWatch(watcher.fd, dir, watchMask)
+	if err != nil {
+		return err
+	}
+	watcher.wds[int32(wd)] = dir
+	return nil
+}
+
+func (watcher *CfgWatcher) readEvents(file *os.File) {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				watcher.LogError("Stop watching the config files", "err", err)
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				// Events were lost, reload to be safe
+				watcher.changed()
+				continue
+			}
+			watcher.mutex.Lock()
+			dir, ok := watcher.wds[event.Wd]
+			watcher.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			watcher.notify(dir, strings.TrimRight(string(nameBytes), "\x00"))
+		}
+	}
+}
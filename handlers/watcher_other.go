@@ -0,0 +1,15 @@
+//go:build !linux
+
+package handlers
+
+import "errors"
+
+func (watcher *CfgWatcher) watch(dirs []string) error {
+	return errors.New("watching the config files is only supported on Linux")
+}
+
+type inotifyWatch struct{}
+
+func (watcher *CfgWatcher) addDir(dir string) error {
+	return errors.New("watching the config files is only supported on Linux")
+}
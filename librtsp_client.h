@@ -25,6 +25,47 @@ typedef struct { const char *p; ptrdiff_t n; } _GoString_;
 #include <stdio.h>
 #include <stdlib.h>
 
+// Returned by the On* and StopAllCall functions
+typedef enum {
+	EVENT_ACCEPTED = 0,
+	EVENT_DROPPED_RELOADING,
+	EVENT_DROPPED_BLOCKED,
+	EVENT_DROPPED_NO_CHANNEL,
+	EVENT_QUEUE_FULL,
+	EVENT_INVALID_ARGUMENT,
+	EVENT_DROPPED_CLOSED,
+	EVENT_DROPPED_NO_INSTANCE,
+} EventResult;
+
+typedef void (*StatusCallback)(char* name, int recorderType, int channel, int rtspState, int errCode);
+
+static inline void invokeStatusCallback(StatusCallback cb, char* name, int recorderType, int channel, int rtspState, int errCode) {
+	cb(name, recorderType, channel, rtspState, errCode);
+}
+
+// A recording gap closed by a reconnection, from startMs to endMs in
+// milliseconds since the epoch, after attempts reconnections
+typedef void (*GapCallback)(char* name, int recorderType, int channel, long long startMs, long long endMs, int attempts);
+
+static inline void invokeGapCallback(GapCallback cb, char* name, int recorderType, int channel, long long startMs, long long endMs, int attempts) {
+	cb(name, recorderType, channel, startMs, endMs, attempts);
+}
+
+// level is one of the LOG_LEVEL_* values, fields a JSON object
+typedef void (*LogCallback)(int level, char* message, char* fields);
+
+static inline void invokeLogCallback(LogCallback cb, int level, char* message, char* fields) {
+	cb(level, message, fields);
+}
+
+typedef enum {
+	LOG_LEVEL_DEBUG = 0,
+	LOG_LEVEL_INFO,
+	LOG_LEVEL_WARN,
+	LOG_LEVEL_ERROR,
+	LOG_LEVEL_FATAL,
+} LogLevel;
+
 #line 1 "cgo-generated-wrapper"
 
 
@@ -82,13 +123,44 @@ extern "C" {
 #endif
 
 extern char* GetGitVersion();
-extern void OnBriefState(int statusC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
-extern void OnGroupState(int recorderTypeC, int statusC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int crdMsgSize, int crdMsgIdSize);
-extern void OnRadioState(int sipTypeC, int radioButtonStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
-extern void OnCallState(int callStateC, int sipTypeC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSizeC, int crdMsgSizeC, int crdMsgIdSizeC);
-extern void OnCallMediaState(int mediaStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int nameSize, int crdMsgSizeC, int crdMsgIdSizeC);
-extern void LoadRecConfig();
-extern void StopAllCall();
+extern int OnBriefState(int statusC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
+extern int InstanceOnBriefState(int instanceC, int statusC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
+extern int OnGroupState(int recorderTypeC, int statusC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int crdMsgSize, int crdMsgIdSize);
+extern int InstanceOnGroupState(int instanceC, int recorderTypeC, int statusC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int crdMsgSize, int crdMsgIdSize);
+extern int OnRadioState(int sipTypeC, int radioButtonStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
+extern int InstanceOnRadioState(int instanceC, int sipTypeC, int radioButtonStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSize, int crdMsgSize, int crdMsgIdSize);
+extern int OnCallState(int callStateC, int sipTypeC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSizeC, int crdMsgSizeC, int crdMsgIdSizeC);
+extern int InstanceOnCallState(int instanceC, int callStateC, int sipTypeC, char* nameC, char* crdMsgC, char* crdMsgIdC, int listenPortC, int nameSizeC, int crdMsgSizeC, int crdMsgIdSizeC);
+extern int OnCallMediaState(int mediaStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int nameSize, int crdMsgSizeC, int crdMsgIdSizeC);
+extern int InstanceOnCallMediaState(int instanceC, int mediaStateC, char* nameC, char* crdMsgC, char* crdMsgIdC, int nameSize, int crdMsgSizeC, int crdMsgIdSizeC);
+extern int OnCallEvent(char* docC, int docSizeC);
+extern int InstanceOnCallEvent(int instanceC, char* docC, int docSizeC);
+extern int LoadRecConfig();
+extern int InstanceLoadRecConfig(int instanceC);
+extern char* GetConfigReport();
+extern char* InstanceGetConfigReport(int instanceC);
+extern char* GetEffectiveConfig();
+extern char* InstanceGetEffectiveConfig(int instanceC);
+extern int StopAllCall();
+extern int InstanceStopAllCall(int instanceC);
+extern void RegisterStatusCallback(StatusCallback cb);
+extern void InstanceRegisterStatusCallback(int instanceC, StatusCallback cb);
+extern void RegisterGapCallback(GapCallback cb);
+extern void InstanceRegisterGapCallback(int instanceC, GapCallback cb);
+extern void SetLogCallback(LogCallback cb);
+extern void InstanceSetLogCallback(int instanceC, LogCallback cb);
+extern int SetLogLevel(int levelC);
+extern int InstanceSetLogLevel(int instanceC, int levelC);
+extern int PushRTP(char* nameC, int recorderTypeC, unsigned char* bufC, int lenC, int nameSize);
+extern int InstancePushRTP(int instanceC, char* nameC, int recorderTypeC, unsigned char* bufC, int lenC, int nameSize);
+extern int PushPCM(char* nameC, int recorderTypeC, short int* samplesC, int countC, int sampleRateC, int nameSize);
+extern int InstancePushPCM(int instanceC, char* nameC, int recorderTypeC, short int* samplesC, int countC, int sampleRateC, int nameSize);
+extern char* GetStateSnapshot();
+extern char* InstanceGetStateSnapshot(int instanceC);
+extern int Init(char* configDirC, char* optionsC);
+extern int Shutdown(int timeoutMsC);
+extern int CreateInstance(char* configDirC, char* optionsC);
+extern int DestroyInstance(int instanceC, int timeoutMsC);
 
 #ifdef __cplusplus
 }
@@ -1,5 +1,7 @@
 package models
 
+import "dvrs.lib/RTSPClient/constant"
+
 type CRDAttribute struct {
 	Name     string `xml:"name,attr"`
 	Value    string `xml:",chardata"`
@@ -10,3 +12,8 @@ type SubOperation struct {
 	CRDAttribute
 	Time string `xml:"time,attr"`
 }
+
+type CRDField struct {
+	Id    constant.Crd
+	Value string
+}
@@ -77,6 +77,24 @@ func canonicalAddr(u *base.URL) string {
 	return net.JoinHostPort(addr, port)
 }
 
+// filterAuthenticate keeps the WWW-Authenticate values of the given methods.
+func filterAuthenticate(v base.HeaderValue, methods []headers.AuthMethod) base.HeaderValue {
+	if len(methods) == 0 {
+		return v
+	}
+	var ret base.HeaderValue
+	for _, vi := range v {
+		for _, method := range methods {
+			if method == headers.AuthBasic && strings.HasPrefix(vi, "Basic") ||
+				method == headers.AuthDigest && strings.HasPrefix(vi, "Digest") {
+				ret = append(ret, vi)
+				break
+			}
+		}
+	}
+	return ret
+}
+
 func isAnyPort(p int) bool {
 	return p == 0 || p == 1
 }
@@ -298,6 +316,9 @@ type Client struct {
 	Wg67Version string
 	//
 	UseInterleaved bool
+	// authentication methods accepted from the server.
+	// It defaults to all of them, Digest being preferred.
+	AuthMethods []headers.AuthMethod
 
 	SkipTearDown bool
 	// system functions (all optional)
@@ -963,6 +984,9 @@ func (c *Client) connOpen() error {
 		tlsConfig := c.TLSConfig
 		if tlsConfig == nil {
 			tlsConfig = &tls.Config{}
+		} else {
+			// the configuration may be shared by several clients
+			tlsConfig = tlsConfig.Clone()
 		}
 		tlsConfig.ServerName = c.connURL.Hostname()
 
@@ -1040,7 +1064,7 @@ func (c *Client) do(req *base.Request, skipResponse bool) (*base.Response, error
 		pass, _ := req.URL.User.Password()
 		user := req.URL.User.Username()
 
-		sender, err := auth.NewSender(res.Header["WWW-Authenticate"], user, pass)
+		sender, err := auth.NewSender(filterAuthenticate(res.Header["WWW-Authenticate"], c.AuthMethods), user, pass)
 		if err != nil {
 			return nil, liberrors.ErrClientAuthSetup{Err: err}
 		}
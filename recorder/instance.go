@@ -0,0 +1,150 @@
+package recorder
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The default client serves the legacy C API. Instances are independent
+// clients, each with its own calls, config, logger and reload state. Id 0
+// always refers to the default client.
+var (
+	defaultClient *RecorderClient
+	// Whether defaultClient was created by Default rather than Init
+	defaultImplicit bool
+	// Set by Shutdown, until Init is called again
+	defaultShutdown bool
+	defaultMutex    = &sync.Mutex{}
+	instances       = map[int]*RecorderClient{}
+	instanceMutex   = &sync.Mutex{}
+	lastInstanceId  = 0
+)
+
+// Default returns the client created by Init. Hosts that never call Init get
+// a client with the default options and the legacy config paths. It returns
+// nil once Shutdown was called, until Init is called again.
+func Default() *RecorderClient {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	if defaultClient == nil && !defaultShutdown {
+		client, err := NewRecorderClient(Config{Options: DefaultOptions()})
+		if err != nil {
+			return nil
+		}
+		defaultClient = client
+		defaultImplicit = true
+	}
+	return defaultClient
+}
+
+// Init creates the default client, reading its config from configDir. An
+// empty configDir keeps the legacy config paths. A client created by Default
+// before Init is replaced: its status and gap handlers and its log settings
+// are kept and it is closed.
+func Init(configDir string, options Options) (*RecorderClient, error) {
+	errInitialized := errors.New("RTSP client is already initialized")
+	defaultMutex.Lock()
+	initialized := defaultClient != nil && !defaultImplicit
+	defaultMutex.Unlock()
+	if initialized {
+		return nil, errInitialized
+	}
+	client, err := NewRecorderClient(Config{
+		ConfigDir: configDir,
+		Options:   options,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defaultMutex.Lock()
+	if defaultClient != nil && !defaultImplicit {
+		// Another Init won
+		defaultMutex.Unlock()
+		client.Close()
+		return nil, errInitialized
+	}
+	implicitClient := defaultClient
+	if implicitClient != nil {
+		implicitClient.mutex.RLock()
+		client.SetStatusHandler(implicitClient.statusCallback)
+		client.SetGapHandler(implicitClient.gapCallback)
+		implicitClient.mutex.RUnlock()
+		client.SetLogLevel(implicitClient.logger.Level())
+		client.SetLogHandler(implicitClient.logger.Handler())
+	}
+	defaultClient = client
+	defaultImplicit = false
+	defaultShutdown = false
+	defaultMutex.Unlock()
+	if implicitClient != nil {
+		implicitClient.Close()
+	}
+	return client, nil
+}
+
+// Shutdown shuts the default client down and releases it so Init can be
+// called again. Default returns nil until then. It returns the number of
+// calls that were not released before the timeout expired.
+func Shutdown(timeout time.Duration) int {
+	defaultMutex.Lock()
+	client := defaultClient
+	defaultClient = nil
+	defaultImplicit = false
+	defaultShutdown = true
+	defaultMutex.Unlock()
+	if client == nil {
+		return 0
+	}
+	return client.Shutdown(timeout)
+}
+
+// CreateInstance creates a client independent from the default one and
+// returns its id, or -1 if the options are invalid.
+func CreateInstance(configDir string, options Options) int {
+	instanceMutex.Lock()
+	defer instanceMutex.Unlock()
+	instance, err := NewRecorderClient(Config{
+		ConfigDir: configDir,
+		Options:   options,
+		Name:      "instance-" + strconv.Itoa(lastInstanceId+1),
+	})
+	if err != nil {
+		return -1
+	}
+	lastInstanceId++
+	instances[lastInstanceId] = instance
+	return lastInstanceId
+}
+
+// GetInstance returns the client of an instance id, or the default client for
+// id 0. It returns false if there is none.
+func GetInstance(id int) (*RecorderClient, bool) {
+	if id == 0 {
+		client := Default()
+		return client, client != nil
+	}
+	instanceMutex.Lock()
+	defer instanceMutex.Unlock()
+	instance, ok := instances[id]
+	return instance, ok
+}
+
+// DestroyInstance shuts the instance down like Shutdown does for the default
+// client. It returns the number of calls still active when the timeout
+// expired, or -1 if the id is unknown.
+func DestroyInstance(id int, timeout time.Duration) int {
+	if id == 0 {
+		return Shutdown(timeout)
+	}
+	instanceMutex.Lock()
+	instance, ok := instances[id]
+	delete(instances, id)
+	instanceMutex.Unlock()
+	if !ok {
+		return -1
+	}
+	return instance.Shutdown(timeout)
+}
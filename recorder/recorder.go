@@ -0,0 +1,388 @@
+// Package recorder records calls and radio sessions to ED-137 RTSP recorders.
+// It is the Go API of the library; the cgo exports of librtsp_client are a
+// shim over it.
+package recorder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+	"dvrs.lib/RTSPClient/handlers"
+	"dvrs.lib/RTSPClient/models"
+	"dvrs.lib/RTSPClient/utils"
+)
+
+type (
+	CallKey       = handlers.CallKey
+	CallEvent     = handlers.CallEvent
+	StatusEvent   = handlers.StatusEvent
+	RecordingGap  = handlers.RecordingGap
+	Options       = handlers.Options
+	StateSnapshot = handlers.StateSnapshot
+	CRDField      = models.CRDField
+	CfgReport     = handlers.CfgReport
+	CfgIssue      = handlers.CfgIssue
+	CfgIssues     = handlers.CfgIssues
+	// The config a client runs with, see RecorderClient.EffectiveConfig
+	EffectiveConfig   = handlers.EffectiveConfig
+	EffectiveRecorder = handlers.EffectiveRecorder
+	CfgSource         = handlers.CfgSource
+	Timings           = handlers.Timings
+	// A state change of a recorder session, see
+	// RecorderClient.SetTransitionObserver
+	TransitionEvent = handlers.TransitionEvent
+	TransitionError = handlers.TransitionError
+)
+
+const defaultStatusBuffer = 64
+
+var (
+	ErrClosed        = errors.New("recorder client is closed")
+	ErrNoActiveCall  = handlers.ErrNoActiveCall
+	ErrInvalidPacket = handlers.ErrInvalidPacket
+	ErrInvalidPCM    = handlers.ErrInvalidPCM
+	ErrPushQueueFull = handlers.ErrPushQueueFull
+)
+
+// EventError reports an event that was not queued. Result says why.
+type EventError struct {
+	Result constant.EventResult
+	Err    error
+}
+
+func (eventErr *EventError) Error() string {
+	if eventErr.Err != nil {
+		return "event " + eventErr.Result.String() + ": " + eventErr.Err.Error()
+	}
+	return "event " + eventErr.Result.String()
+}
+
+func (eventErr *EventError) Unwrap() error {
+	return eventErr.Err
+}
+
+// EventResultOf maps the error returned for an event to its EventResult.
+func EventResultOf(err error) constant.EventResult {
+	if err == nil {
+		return constant.EVENT_ACCEPTED
+	}
+	var eventErr *EventError
+	if errors.As(err, &eventErr) {
+		return eventErr.Result
+	}
+	return constant.EVENT_INVALID_ARGUMENT
+}
+
+type Config struct {
+	// Directory holding rec-config/rec.json, or rec-config/rec.cfg and
+	// system/device_system.cfg. The legacy config paths are used if it is
+	// empty.
+	ConfigDir string
+	// Options left zero take the value of DefaultOptions
+	Options Options
+	// Name given to the logger, empty for none
+	Name string
+	// Capacity of the Status channel. Events are dropped while it is full.
+	StatusBuffer int
+}
+
+// RecorderClient handles the calls of one host. Each RecorderClient has its
+// own calls, config and recorder sessions.
+type RecorderClient struct {
+	rtspClient     *handlers.RTSPClient
+	logger         utils.ZapLogger
+	chStatus       chan StatusEvent
+	statusCallback handlers.StatusHandler
+	gapCallback    GapHandler
+	closed         bool
+	statusClosed   bool
+	mutex          *sync.RWMutex
+}
+
+// GapHandler receives the recording gap closed by a reconnection, on the
+// channel ch of the call.
+type GapHandler func(key CallKey, ch int, gap RecordingGap)
+
+func DefaultOptions() Options {
+	return handlers.DefaultOptions()
+}
+
+// ParseOptions reads options from a JSON object, see handlers.ParseOptions.
+func ParseOptions(data string) (Options, error) {
+	return handlers.ParseOptions(data)
+}
+
+// ParseCallEvent decodes and validates a JSON call event, see
+// handlers.ParseCallEvent.
+func ParseCallEvent(data []byte) (CallEvent, error) {
+	return handlers.ParseCallEvent(data)
+}
+
+// ParseCRDPairs converts the comma-separated CRD values and ids of the legacy
+// API.
+func ParseCRDPairs(crdMsg string, crdMsgId string) []CRDField {
+	return handlers.ParseCRDPairs(crdMsg, crdMsgId)
+}
+
+// NewRecorderClient creates a client and loads its config.
+func NewRecorderClient(cfg Config) (*RecorderClient, error) {
+	cfg.Options = cfg.Options.WithDefaults()
+	if err := cfg.Options.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.StatusBuffer <= 0 {
+		cfg.StatusBuffer = defaultStatusBuffer
+	}
+	logger := utils.CreateZapLogger()
+	if cfg.Name != "" {
+		logger = logger.Named(cfg.Name)
+	}
+	client := &RecorderClient{
+		rtspClient: handlers.NewRTSPClient(cfg.ConfigDir, cfg.Options, logger),
+		logger:     logger,
+		chStatus:   make(chan StatusEvent, cfg.StatusBuffer),
+		mutex:      &sync.RWMutex{},
+	}
+	client.rtspClient.SetStatusHandler(client.onStatus)
+	client.ReloadConfig()
+	client.rtspClient.RecoverCalls()
+	return client, nil
+}
+
+func (client *RecorderClient) onStatus(statusEvent StatusEvent) {
+	client.mutex.RLock()
+	statusClosed := client.statusClosed
+	statusCallback := client.statusCallback
+	gapCallback := client.gapCallback
+	if !statusClosed {
+		select {
+		case client.chStatus <- statusEvent:
+		default:
+		}
+	}
+	client.mutex.RUnlock()
+	if !statusClosed && statusCallback != nil {
+		statusCallback(statusEvent)
+	}
+	if !statusClosed && gapCallback != nil && statusEvent.Gap != nil {
+		gapCallback(statusEvent.CallKey, statusEvent.Ch, *statusEvent.Gap)
+	}
+}
+
+// Status delivers the state changes of the recorder sessions. It is closed by
+// Close.
+func (client *RecorderClient) Status() <-chan StatusEvent {
+	return client.chStatus
+}
+
+// SetStatusHandler calls statusHandler on each state change of the recorder
+// sessions, in addition to the Status channel. A nil statusHandler removes it.
+func (client *RecorderClient) SetStatusHandler(statusHandler func(StatusEvent)) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.statusCallback = statusHandler
+}
+
+// SetGapHandler calls gapHandler for each recording gap closed by a
+// reconnection, after the status handler got its STATUS_RECONNECTED event. A
+// nil gapHandler removes it.
+func (client *RecorderClient) SetGapHandler(gapHandler GapHandler) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.gapCallback = gapHandler
+}
+
+// SetTransitionObserver calls transitionObserver on each transition of the
+// recorder sessions, including those their state machine rejects. It is
+// called from the goroutines of the calls and must not block. A nil
+// transitionObserver removes it.
+func (client *RecorderClient) SetTransitionObserver(transitionObserver func(TransitionEvent)) {
+	client.rtspClient.SetTransitionObserver(transitionObserver)
+}
+
+func (client *RecorderClient) isClosed() bool {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.closed
+}
+
+// OnCallEvent validates the event and queues it on its call. An event that is
+// not queued is reported by an *EventError. With QUEUE_BLOCK it waits for room
+// until ctx is done, then returns EVENT_QUEUE_FULL with the error of ctx.
+func (client *RecorderClient) OnCallEvent(ctx context.Context, callEvent CallEvent) error {
+	if err := ctx.Err(); err != nil {
+		return &EventError{Result: constant.EVENT_QUEUE_FULL, Err: err}
+	}
+	if client.isClosed() {
+		return &EventError{Result: constant.EVENT_DROPPED_CLOSED, Err: ErrClosed}
+	}
+	if err := callEvent.Validate(); err != nil {
+		return &EventError{Result: constant.EVENT_INVALID_ARGUMENT, Err: err}
+	}
+	if result := client.rtspClient.HandleCallEvent(ctx, callEvent); result != constant.EVENT_ACCEPTED {
+		return &EventError{Result: result, Err: ctx.Err()}
+	}
+	return nil
+}
+
+// EventContext returns the context the C API queues an event with. With
+// QUEUE_BLOCK the event waits for room at most the release timeout, then
+// EVENT_QUEUE_FULL is returned instead of blocking the host.
+func (client *RecorderClient) EventContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), client.rtspClient.ReleaseTimeout())
+}
+
+func (client *RecorderClient) OnCallState(ctx context.Context, key CallKey, state constant.CallState, crd []CRDField) error {
+	return client.OnCallEvent(ctx, CallEvent{
+		Event:        constant.CALL_STATE_EVENT,
+		Name:         key.Name,
+		RecorderType: key.RecorderType,
+		State:        int(state),
+		CRD:          crd,
+	})
+}
+
+func (client *RecorderClient) OnCallMediaState(ctx context.Context, key CallKey, state constant.CallMediaState, crd []CRDField) error {
+	return client.OnCallEvent(ctx, CallEvent{
+		Event:        constant.CALL_MEDIA_STATE_EVENT,
+		Name:         key.Name,
+		RecorderType: constant.RET_PHONE,
+		State:        int(state),
+		CRD:          crd,
+	})
+}
+
+func (client *RecorderClient) OnRadioState(ctx context.Context, key CallKey, state constant.RadioButtonState, crd []CRDField) error {
+	return client.OnCallEvent(ctx, CallEvent{
+		Event:        constant.RADIO_STATE_EVENT,
+		Name:         key.Name,
+		RecorderType: key.RecorderType,
+		State:        int(state),
+		CRD:          crd,
+	})
+}
+
+func (client *RecorderClient) OnBriefState(ctx context.Context, key CallKey, state constant.BriefState, crd []CRDField) error {
+	return client.OnCallEvent(ctx, CallEvent{
+		Event:        constant.BRIEF_STATE_EVENT,
+		Name:         key.Name,
+		RecorderType: constant.RET_BRIEF,
+		State:        int(state),
+		CRD:          crd,
+	})
+}
+
+// OnGroupState reports the state of a group recording. Group recordings are
+// identified by their recorder type only.
+func (client *RecorderClient) OnGroupState(ctx context.Context, recorderType constant.RecorderType, state constant.GroupState, crd []CRDField) error {
+	return client.OnCallEvent(ctx, CallEvent{
+		Event:        constant.GROUP_STATE_EVENT,
+		RecorderType: recorderType,
+		State:        int(state),
+		CRD:          crd,
+	})
+}
+
+// PushRTP forwards one RTP packet of an active call to its recorders.
+func (client *RecorderClient) PushRTP(key CallKey, buf []byte) error {
+	if client.isClosed() {
+		return ErrClosed
+	}
+	return client.rtspClient.PushRTP(key, buf)
+}
+
+// PushPCM forwards 16-bit linear PCM samples of an active call to its
+// recorders. On ErrPushQueueFull none of the samples was queued and they can
+// be pushed again.
+func (client *RecorderClient) PushPCM(key CallKey, samples []int16, sampleRate int) error {
+	if client.isClosed() {
+		return ErrClosed
+	}
+	return client.rtspClient.PushPCM(key, samples, sampleRate)
+}
+
+// ReloadConfig reads the config again. Active calls keep recording on the
+// channels whose settings did not change. The report lists the issues found,
+// and whether the config was rejected.
+func (client *RecorderClient) ReloadConfig() CfgReport {
+	return client.rtspClient.LoadRecConfig()
+}
+
+// ConfigReport returns the report of the last config load, including those
+// done when Options.WatchConfig is set.
+func (client *RecorderClient) ConfigReport() CfgReport {
+	return client.rtspClient.CfgReport()
+}
+
+// EffectiveConfig returns the config the client runs with: the typed values
+// once the defaults are applied and the duplicate addresses merged, with the
+// file and line each of them comes from.
+func (client *RecorderClient) EffectiveConfig() EffectiveConfig {
+	return client.rtspClient.EffectiveConfig()
+}
+
+// StopAllCall ends every active call, then applies the config loaded in the
+// meantime. It returns an *EventError if some calls could not be stopped.
+func (client *RecorderClient) StopAllCall() error {
+	if result := client.rtspClient.StopAllCall(); result != constant.EVENT_ACCEPTED {
+		return &EventError{Result: result}
+	}
+	return nil
+}
+
+func (client *RecorderClient) Snapshot() StateSnapshot {
+	return client.rtspClient.GetStateSnapshot()
+}
+
+func (client *RecorderClient) SnapshotJSON() ([]byte, error) {
+	return client.rtspClient.GetStateSnapshotJSON()
+}
+
+func (client *RecorderClient) Logger() utils.Logger {
+	return client.rtspClient.Logger
+}
+
+// Shutdown ends every active call and closes every recorder session, waiting
+// at most timeout for them. It returns the number of calls still active when
+// the timeout expired. The client can not be used afterwards.
+func (client *RecorderClient) Shutdown(timeout time.Duration) int {
+	client.mutex.Lock()
+	if client.closed {
+		client.mutex.Unlock()
+		return 0
+	}
+	client.closed = true
+	client.mutex.Unlock()
+	leftCalls := client.rtspClient.Shutdown(timeout)
+	client.rtspClient.SetStatusHandler(nil)
+	client.rtspClient.SetTransitionObserver(nil)
+	client.mutex.Lock()
+	client.statusClosed = true
+	close(client.chStatus)
+	client.mutex.Unlock()
+	return leftCalls
+}
+
+// Close is Shutdown with the release timeout of the options.
+func (client *RecorderClient) Close() error {
+	timeout := time.Duration(client.rtspClient.ReleaseTimeoutMs) * time.Millisecond
+	if leftCalls := client.Shutdown(timeout); leftCalls != 0 {
+		return errors.New("calls still active after the release timeout")
+	}
+	return nil
+}
+
+// SetLogHandler sends the log entries of the client to handler instead of
+// stdout. A nil handler restores stdout.
+func (client *RecorderClient) SetLogHandler(handler utils.LogHandler) {
+	client.logger.SetHandler(handler)
+}
+
+// SetLogLevel changes the minimum level logged by the client. It returns false
+// if level is unknown.
+func (client *RecorderClient) SetLogLevel(level constant.LogLevel) bool {
+	return client.logger.SetLevel(level)
+}
@@ -0,0 +1,209 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dvrs.lib/RTSPClient/constant"
+)
+
+// configDir returns a config directory whose rec.json holds recJSON.
+func configDir(t *testing.T, recJSON string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeRecJSON(t, dir, recJSON)
+	return dir
+}
+
+func writeRecJSON(t *testing.T, dir string, recJSON string) {
+	t.Helper()
+	path := filepath.Join(dir, string(constant.REC_JSON_CFG_NAME))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(recJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hangUp is a valid event of a phone call. Without recorders it is dropped
+// with EVENT_DROPPED_NO_CHANNEL.
+var hangUp = CallEvent{
+	Event:        constant.CALL_STATE_EVENT,
+	Name:         "1001",
+	RecorderType: constant.RET_PHONE,
+	State:        int(constant.PJSIP_INV_STATE_DISCONNECTED),
+}
+
+func TestEventResultOf(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want constant.EventResult
+	}{
+		{"no error", nil, constant.EVENT_ACCEPTED},
+		{"event error", &EventError{Result: constant.EVENT_DROPPED_RELOADING}, constant.EVENT_DROPPED_RELOADING},
+		{"event error with its cause", &EventError{Result: constant.EVENT_QUEUE_FULL, Err: context.DeadlineExceeded}, constant.EVENT_QUEUE_FULL},
+		{"wrapped event error", fmt.Errorf("call 1001: %w", &EventError{Result: constant.EVENT_DROPPED_CLOSED, Err: ErrClosed}), constant.EVENT_DROPPED_CLOSED},
+		{"other error", errors.New("bad"), constant.EVENT_INVALID_ARGUMENT},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EventResultOf(tc.err); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOnCallEventResult(t *testing.T) {
+	client, err := NewRecorderClient(Config{ConfigDir: configDir(t, `{"recorders": []}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	invalid := hangUp
+	invalid.RecorderType = constant.RET_RADIO_TX
+	for _, tc := range []struct {
+		name  string
+		ctx   context.Context
+		event CallEvent
+		want  constant.EventResult
+	}{
+		{"no recorder", context.Background(), hangUp, constant.EVENT_DROPPED_NO_CHANNEL},
+		{"invalid event", context.Background(), invalid, constant.EVENT_INVALID_ARGUMENT},
+		{"context done", cancelled, hangUp, constant.EVENT_QUEUE_FULL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EventResultOf(client.OnCallEvent(tc.ctx, tc.event)); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+	if leftCalls := client.Shutdown(time.Second); leftCalls != 0 {
+		t.Fatalf("%d calls left", leftCalls)
+	}
+	if got := EventResultOf(client.OnCallEvent(context.Background(), hangUp)); got != constant.EVENT_DROPPED_CLOSED {
+		t.Fatalf("after Shutdown got %s, want %s", got, constant.EVENT_DROPPED_CLOSED)
+	}
+	if _, ok := <-client.Status(); ok {
+		t.Fatal("Status still open after Shutdown")
+	}
+}
+
+func TestDefaultLifecycle(t *testing.T) {
+	dir := configDir(t, `{"recorders": []}`)
+	client, err := Init(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(time.Second)
+	if _, err := Init(dir, DefaultOptions()); err == nil {
+		t.Fatal("second Init accepted")
+	}
+	if got, ok := GetInstance(0); !ok || got != client {
+		t.Fatal("instance 0 is not the client of Init")
+	}
+	if leftCalls := Shutdown(time.Second); leftCalls != 0 {
+		t.Fatalf("%d calls left", leftCalls)
+	}
+	if Default() != nil {
+		t.Fatal("Default returns a client after Shutdown")
+	}
+	if _, ok := GetInstance(0); ok {
+		t.Fatal("instance 0 exists after Shutdown")
+	}
+	if got := EventResultOf(client.OnCallEvent(context.Background(), hangUp)); got != constant.EVENT_DROPPED_CLOSED {
+		t.Fatalf("event after Shutdown got %s, want %s", got, constant.EVENT_DROPPED_CLOSED)
+	}
+
+	again, err := Init(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Init after Shutdown: %v", err)
+	}
+	if again == client || Default() != again {
+		t.Fatal("Init after Shutdown did not create a new default client")
+	}
+}
+
+func TestInitRejectsInvalidOptions(t *testing.T) {
+	options := DefaultOptions()
+	options.EventQueuePolicy = "drop_newest"
+	if _, err := Init(configDir(t, `{"recorders": []}`), options); err == nil {
+		Shutdown(time.Second)
+		t.Fatal("invalid options accepted")
+	}
+}
+
+func TestInstanceLifecycle(t *testing.T) {
+	dir := configDir(t, `{"recorders": []}`)
+	first := CreateInstance(dir, DefaultOptions())
+	second := CreateInstance(dir, DefaultOptions())
+	if first <= 0 || second <= 0 || first == second {
+		t.Fatalf("got instance ids %d and %d", first, second)
+	}
+	firstClient, ok := GetInstance(first)
+	if !ok {
+		t.Fatal("first instance not found")
+	}
+	if secondClient, _ := GetInstance(second); secondClient == firstClient {
+		t.Fatal("instances share their client")
+	}
+	if leftCalls := DestroyInstance(first, time.Second); leftCalls != 0 {
+		t.Fatalf("%d calls left", leftCalls)
+	}
+	if _, ok := GetInstance(first); ok {
+		t.Fatal("destroyed instance still found")
+	}
+	if got := DestroyInstance(first, time.Second); got != -1 {
+		t.Fatalf("destroying an unknown instance got %d, want -1", got)
+	}
+	if _, ok := GetInstance(second); !ok {
+		t.Fatal("destroying an instance removed another one")
+	}
+	DestroyInstance(second, time.Second)
+
+	options := DefaultOptions()
+	options.EventQueueSize = -1
+	if id := CreateInstance(dir, options); id != -1 {
+		DestroyInstance(id, time.Second)
+		t.Fatalf("invalid options got instance %d, want -1", id)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := configDir(t, `{"recorders": []}`)
+	client, err := NewRecorderClient(Config{ConfigDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown(time.Second)
+	if report := client.ConfigReport(); !report.Applied || report.Channels != 0 {
+		t.Fatalf("got report %+v", report)
+	}
+
+	writeRecJSON(t, dir, `{"recorders": [{"address": "127.0.0.1"}]}`)
+	if report := client.ReloadConfig(); !report.Applied || report.Channels != 1 {
+		t.Fatalf("got report %+v", report)
+	}
+	if recorders := client.EffectiveConfig().Recorders; len(recorders) != 1 {
+		t.Fatalf("got %d recorders, want 1", len(recorders))
+	}
+
+	writeRecJSON(t, dir, `{"recorders": [{"address": "127.0.0.1", "port": 0}]}`)
+	report := client.ReloadConfig()
+	if report.Applied || len(report.Issues.Errors()) == 0 {
+		t.Fatalf("invalid config got report %+v", report)
+	}
+	if got := client.ConfigReport(); got.Applied {
+		t.Fatal("ConfigReport is not the report of the last load")
+	}
+	if recorders := client.EffectiveConfig().Recorders; len(recorders) != 1 {
+		t.Fatalf("rejected config replaced the recorders: got %d, want 1", len(recorders))
+	}
+}
@@ -21,13 +21,51 @@ func CreateRand4Digits() string {
 	return fmt.Sprintf("%04d", rand.Intn(1e4))
 }
 
-func ExtractIpAddr(str string) string {
-	ipPattern := regexp.MustCompile(`\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)
-	match := ipPattern.FindString(str)
-	if match == "" || net.ParseIP(match) == nil {
+var hostLabelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// ParseHost checks that str is an IPv4 address, an IPv6 address, bracketed or
+// not, or a hostname, and returns it without brackets.
+func ParseHost(str string) (string, bool) {
+	if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+		ip := net.ParseIP(str[1 : len(str)-1])
+		if ip == nil || ip.To4() != nil {
+			return "", false
+		}
+		return str[1 : len(str)-1], true
+	}
+	if net.ParseIP(str) != nil {
+		return str, true
+	}
+	name := strings.TrimSuffix(str, ".")
+	if name == "" || len(name) > 253 {
+		return "", false
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if !hostLabelPattern.MatchString(label) {
+			return "", false
+		}
+	}
+	// A name made only of digits and dots is a malformed IPv4 address
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return "", false
+	}
+	return name, true
+}
+
+// ExtractHost returns the host at the start of str, skipping spaces and
+// quotes, or "" if it is not a valid host.
+func ExtractHost(str string) string {
+	str = strings.TrimLeft(str, " \t\"'")
+	end := strings.IndexAny(str, " \t\"';,}")
+	if end >= 0 {
+		str = str[:end]
+	}
+	host, ok := ParseHost(str)
+	if !ok {
 		return ""
 	}
-	return match
+	return host
 }
 
 func ExtractPort(str string) string {
@@ -55,10 +93,12 @@ func RemoveComments(str string) string {
 			inBlockComment = false
 			i++ // Skip the second character of */
 		} else if !inBlockComment && strings.HasPrefix(str[i:], "//") {
-			for i < len(str) && str[i] != '\n' {
+			// Stop before the newline so it is kept
+			for i+1 < len(str) && str[i+1] != '\n' {
 				i++
 			}
-		} else if !inBlockComment {
+		} else if !inBlockComment || str[i] == '\n' {
+			// Newlines of block comments are kept so line numbers do not change
 			result.WriteByte(str[i])
 		}
 	}
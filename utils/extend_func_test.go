@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	// 253 characters
+	longestName := strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 61)
+	for _, tc := range []struct {
+		str    string
+		want   string
+		wantOk bool
+	}{
+		{"10.0.0.1", "10.0.0.1", true},
+		{"fd00::1", "fd00::1", true},
+		{"::1", "::1", true},
+		{"[fd00::1]", "fd00::1", true},
+		{"[::ffff:10.0.0.1]", "", false},
+		{"[10.0.0.1]", "", false},
+		{"[fd00::1", "", false},
+		{"[rec1]", "", false},
+		{"rec1", "rec1", true},
+		{"rec-1.example.com", "rec-1.example.com", true},
+		{"rec1.example.com.", "rec1.example.com", true},
+		{"1rec", "1rec", true},
+		{"rec1.123", "", false},
+		{"10.0.0.256", "", false},
+		{"1234", "", false},
+		{"10.0.0", "", false},
+		{".", "", false},
+		{"", "", false},
+		{"rec..example.com", "", false},
+		{"-rec1", "", false},
+		{"rec1-", "", false},
+		{"rec_1", "", false},
+		{strings.Repeat("a", 63) + ".com", strings.Repeat("a", 63) + ".com", true},
+		{strings.Repeat("a", 64) + ".com", "", false},
+		{longestName, longestName, true},
+		{longestName + "a", "", false},
+	} {
+		t.Run(tc.str, func(t *testing.T) {
+			got, ok := ParseHost(tc.str)
+			if got != tc.want || ok != tc.wantOk {
+				t.Fatalf("got %q, %v, want %q, %v", got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestExtractHost(t *testing.T) {
+	for _, tc := range []struct {
+		// Value of a rec_ip line, after the key
+		value string
+		want  string
+	}{
+		{"10.0.0.1", "10.0.0.1"},
+		{` "10.0.0.1";`, "10.0.0.1"},
+		{`'rec1.example.com',`, "rec1.example.com"},
+		{"\t10.0.0.1 }", "10.0.0.1"},
+		{`"[fd00::1]";`, "fd00::1"},
+		{`"fd00::1"`, "fd00::1"},
+		{`"[10.0.0.1]"`, ""},
+		{`"10.0.0.1"; // main recorder`, "10.0.0.1"},
+		{`"10.0.0.1" /* main recorder */;`, "10.0.0.1"},
+		{`/* "10.0.0.1" */ "10.0.0.2";`, "10.0.0.2"},
+		{`// "10.0.0.1";`, ""},
+		{`"";`, ""},
+		{`"1234";`, ""},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			if got := ExtractHost(RemoveComments(tc.value)); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
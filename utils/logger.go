@@ -1,26 +1,150 @@
 package utils
 
 import (
-	"fmt"
+	"sync"
 	"time"
 
+	"dvrs.lib/RTSPClient/constant"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Logger logs a message with the key and value pairs following it as
+// structured fields, e.g. LogInfo("Recorder is down", "recorder", recAddr).
 type Logger interface {
-	LogDebug(v ...interface{})
-	LogInfo(v ...interface{})
-	LogWarn(v ...interface{})
-	LogError(v ...interface{})
-	LogFatal(v ...interface{})
+	LogDebug(msg string, keysAndValues ...interface{})
+	LogInfo(msg string, keysAndValues ...interface{})
+	LogWarn(msg string, keysAndValues ...interface{})
+	LogError(msg string, keysAndValues ...interface{})
+	LogFatal(msg string, keysAndValues ...interface{})
 }
 
 type ZapLogger struct {
-	logger *zap.Logger
+	logger   *zap.SugaredLogger
+	settings *logSettings
 }
 
+// LogHandler receives every log entry, with the fields attached to it such as
+// the logger name and the caller.
+type LogHandler func(level constant.LogLevel, message string, fields map[string]interface{})
+
+// logSettings are the level and the handler of a logger and of the loggers
+// named from it, so they can be changed on a running system.
+type logSettings struct {
+	level   zap.AtomicLevel
+	mutex   *sync.RWMutex
+	handler LogHandler
+}
+
+func (settings *logSettings) getHandler() LogHandler {
+	settings.mutex.RLock()
+	defer settings.mutex.RUnlock()
+	return settings.handler
+}
+
+var zapLevels = map[constant.LogLevel]zapcore.Level{
+	constant.LOG_LEVEL_DEBUG: zapcore.DebugLevel,
+	constant.LOG_LEVEL_INFO:  zapcore.InfoLevel,
+	constant.LOG_LEVEL_WARN:  zapcore.WarnLevel,
+	constant.LOG_LEVEL_ERROR: zapcore.ErrorLevel,
+	constant.LOG_LEVEL_FATAL: zapcore.FatalLevel,
+}
+
+// SetLevel changes the minimum level logged. It returns false if level is
+// unknown.
+func (zapLogger ZapLogger) SetLevel(level constant.LogLevel) bool {
+	zapLevel, ok := zapLevels[level]
+	if !ok {
+		return false
+	}
+	zapLogger.settings.level.SetLevel(zapLevel)
+	return true
+}
+
+func (zapLogger ZapLogger) Level() constant.LogLevel {
+	return toLogLevel(zapLogger.settings.level.Level())
+}
+
+// SetHandler sends the log entries to handler instead of stdout. A nil
+// handler restores stdout.
+func (zapLogger ZapLogger) SetHandler(handler LogHandler) {
+	zapLogger.settings.mutex.Lock()
+	defer zapLogger.settings.mutex.Unlock()
+	zapLogger.settings.handler = handler
+}
+
+func (zapLogger ZapLogger) Handler() LogHandler {
+	return zapLogger.settings.getHandler()
+}
+
+func toLogLevel(zapLevel zapcore.Level) constant.LogLevel {
+	switch {
+	case zapLevel <= zapcore.DebugLevel:
+		return constant.LOG_LEVEL_DEBUG
+	case zapLevel == zapcore.InfoLevel:
+		return constant.LOG_LEVEL_INFO
+	case zapLevel == zapcore.WarnLevel:
+		return constant.LOG_LEVEL_WARN
+	case zapLevel == zapcore.ErrorLevel:
+		return constant.LOG_LEVEL_ERROR
+	default:
+		return constant.LOG_LEVEL_FATAL
+	}
+}
+
+// handlerCore writes to the log handler when one is set and to the wrapped
+// console core otherwise.
+type handlerCore struct {
+	zapcore.Core
+	fields   []zapcore.Field
+	settings *logSettings
+}
+
+func (core *handlerCore) With(fields []zapcore.Field) zapcore.Core {
+	return &handlerCore{
+		Core:     core.Core.With(fields),
+		fields:   append(append([]zapcore.Field{}, core.fields...), fields...),
+		settings: core.settings,
+	}
+}
+
+func (core *handlerCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+func (core *handlerCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	handler := core.settings.getHandler()
+	if handler == nil {
+		return core.Core.Write(entry, fields)
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range core.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	enc.Fields["timestamp"] = entry.Time.UTC().Format("2006-01-02T15:04:05")
+	if entry.LoggerName != "" {
+		enc.Fields["logger"] = entry.LoggerName
+	}
+	if entry.Caller.Defined {
+		enc.Fields["caller"] = entry.Caller.TrimmedPath()
+	}
+	handler(toLogLevel(entry.Level), entry.Message, enc.Fields)
+	return nil
+}
+
+// CreateZapLogger creates a logger at the info level writing to stdout. Its
+// level and handler are its own, shared only with the loggers named from it.
 func CreateZapLogger() ZapLogger {
+	settings := &logSettings{
+		level: zap.NewAtomicLevelAt(zap.InfoLevel),
+		mutex: &sync.RWMutex{},
+	}
 	encoderCfg := zap.NewProductionEncoderConfig()
 	encoderCfg.TimeKey = "timestamp"
 	// Custom time encoder to remove 'Z' and milliseconds
@@ -31,7 +155,7 @@ func CreateZapLogger() ZapLogger {
 	encoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder // Capitalize log levels
 
 	config := zap.Config{
-		Level:             zap.NewAtomicLevelAt(zap.InfoLevel),
+		Level:             settings.level,
 		Development:       false,
 		DisableCaller:     false, // Disable caller information
 		DisableStacktrace: false,
@@ -46,25 +170,32 @@ func CreateZapLogger() ZapLogger {
 		},
 	}
 
-	return ZapLogger{logger: zap.Must(config.Build(zap.AddCallerSkip(1)))}
+	logger := zap.Must(config.Build(zap.AddCallerSkip(1), zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &handlerCore{Core: core, settings: settings}
+	})))
+	return ZapLogger{logger: logger.Sugar(), settings: settings}
+}
+
+func (zapLogger ZapLogger) LogDebug(msg string, keysAndValues ...interface{}) {
+	zapLogger.logger.Debugw(msg, keysAndValues...)
 }
 
-func (zapLogger ZapLogger) LogDebug(v ...interface{}) {
-	zapLogger.logger.Debug(fmt.Sprintln(v...))
+func (zapLogger ZapLogger) LogInfo(msg string, keysAndValues ...interface{}) {
+	zapLogger.logger.Infow(msg, keysAndValues...)
 }
 
-func (zapLogger ZapLogger) LogInfo(v ...interface{}) {
-	zapLogger.logger.Info(fmt.Sprintln(v...))
+func (zapLogger ZapLogger) LogWarn(msg string, keysAndValues ...interface{}) {
+	zapLogger.logger.Warnw(msg, keysAndValues...)
 }
 
-func (zapLogger ZapLogger) LogWarn(v ...interface{}) {
-	zapLogger.logger.Warn(fmt.Sprintln(v...))
+func (zapLogger ZapLogger) LogError(msg string, keysAndValues ...interface{}) {
+	zapLogger.logger.Errorw(msg, keysAndValues...)
 }
 
-func (zapLogger ZapLogger) LogError(v ...interface{}) {
-	zapLogger.logger.Error(fmt.Sprintln(v...))
+func (zapLogger ZapLogger) LogFatal(msg string, keysAndValues ...interface{}) {
+	zapLogger.logger.Fatalw(msg, keysAndValues...)
 }
 
-func (zapLogger ZapLogger) LogFatal(v ...interface{}) {
-	zapLogger.logger.Fatal(fmt.Sprintln(v...))
+func (zapLogger ZapLogger) Named(name string) ZapLogger {
+	return ZapLogger{logger: zapLogger.logger.Named(name), settings: zapLogger.settings}
 }
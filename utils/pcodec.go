@@ -5,8 +5,13 @@ import (
 	"github.com/zaf/g711"
 )
 
+// ConvertCodec converts a packet carrying G.711 (payload type 0 or 8) or 8 kHz
+// little-endian linear PCM (payload type 96) to codec: g711alaw, g711ulaw,
+// l16 (8 kHz) or l16_16k (16 kHz). L16 is big-endian as in RFC 3551. The
+// payload type is set to the usual one of codec. It returns false if the
+// packet or codec is not supported.
 func ConvertCodec(pkt *rtp.Packet, codec string) bool {
-	if codec != "g711alaw" && codec != "g711ulaw" || pkt.PayloadType != 8 && pkt.PayloadType != 0 && pkt.PayloadType != 96 {
+	if codec != "g711alaw" && codec != "g711ulaw" && codec != "l16" && codec != "l16_16k" || pkt.PayloadType != 8 && pkt.PayloadType != 0 && pkt.PayloadType != 96 {
 		return false
 	}
 	if codec == "g711ulaw" && pkt.PayloadType == 0 || codec == "g711alaw" && pkt.PayloadType == 8 {
@@ -28,8 +33,51 @@ func ConvertCodec(pkt *rtp.Packet, codec string) bool {
 			payLoad = g711.Alaw2Ulaw(payLoad)
 		}
 		pkt.Header.PayloadType = 0
-	default:
+	case "l16", "l16_16k":
+		switch pkt.PayloadType {
+		case 8:
+			payLoad = g711.DecodeAlaw(payLoad)
+		case 0:
+			payLoad = g711.DecodeUlaw(payLoad)
+		}
+		if codec == "l16_16k" {
+			payLoad = upsampleLPCM(payLoad)
+			pkt.Timestamp *= 2
+		}
+		payLoad = swapLPCM(payLoad)
+		pkt.Header.PayloadType = 96
 	}
 	pkt.Payload = payLoad
 	return true
 }
+
+// upsampleLPCM doubles the sample rate of little-endian 16-bit PCM by linear
+// interpolation.
+func upsampleLPCM(lpcm []byte) []byte {
+	n := len(lpcm) / 2
+	out := make([]byte, 4*n)
+	for i := 0; i < n; i++ {
+		sample := int32(int16(uint16(lpcm[2*i]) | uint16(lpcm[2*i+1])<<8))
+		next := sample
+		if i+1 < n {
+			next = int32(int16(uint16(lpcm[2*i+2]) | uint16(lpcm[2*i+3])<<8))
+		}
+		middle := int16((sample + next) / 2)
+		out[4*i] = byte(sample)
+		out[4*i+1] = byte(sample >> 8)
+		out[4*i+2] = byte(middle)
+		out[4*i+3] = byte(middle >> 8)
+	}
+	return out
+}
+
+// swapLPCM converts 16-bit PCM between little and big endian, into a new
+// slice.
+func swapLPCM(lpcm []byte) []byte {
+	out := make([]byte, len(lpcm)&^1)
+	for i := 0; i+1 < len(lpcm); i += 2 {
+		out[i] = lpcm[i+1]
+		out[i+1] = lpcm[i]
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/zaf/g711"
+)
+
+// le returns samples as little-endian 16-bit PCM.
+func le(samples ...int16) []byte {
+	out := []byte{}
+	for _, sample := range samples {
+		out = append(out, byte(sample), byte(uint16(sample)>>8))
+	}
+	return out
+}
+
+// be returns samples as big-endian 16-bit PCM.
+func be(samples ...int16) []byte {
+	out := []byte{}
+	for _, sample := range samples {
+		out = append(out, byte(uint16(sample)>>8), byte(sample))
+	}
+	return out
+}
+
+func TestConvertCodec(t *testing.T) {
+	alaw := []byte{g711.EncodeAlawFrame(1000), g711.EncodeAlawFrame(-3000)}
+	ulaw := []byte{g711.EncodeUlawFrame(1000), g711.EncodeUlawFrame(-3000)}
+	// Samples of the G.711 payloads once decoded
+	a1, a2 := g711.DecodeAlawFrame(alaw[0]), g711.DecodeAlawFrame(alaw[1])
+	u1, u2 := g711.DecodeUlawFrame(ulaw[0]), g711.DecodeUlawFrame(ulaw[1])
+	for _, tc := range []struct {
+		name          string
+		payloadType   uint8
+		payload       []byte
+		codec         string
+		want          []byte
+		wantType      uint8
+		wantTimestamp uint32
+	}{
+		{"A-law to L16", 8, alaw, "l16", be(a1, a2), 96, 160},
+		{"µ-law to L16", 0, ulaw, "l16", be(u1, u2), 96, 160},
+		// The last sample is repeated
+		{"A-law to L16 16 kHz", 8, alaw, "l16_16k", be(a1, (a1+a2)/2, a2, a2), 96, 320},
+		{"L16 to L16", 96, le(1000, -3000), "l16", be(1000, -3000), 96, 160},
+		{"L16 to L16 16 kHz", 96, le(1000, -3000), "l16_16k", be(1000, -1000, -3000, -3000), 96, 320},
+		{"L16 to A-law", 96, le(1000, -3000), "g711alaw", alaw, 8, 160},
+		{"L16 to µ-law", 96, le(1000, -3000), "g711ulaw", ulaw, 0, 160},
+		{"A-law to µ-law", 8, alaw, "g711ulaw", g711.Alaw2Ulaw(alaw), 0, 160},
+		{"A-law unchanged", 8, alaw, "g711alaw", alaw, 8, 160},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkt := &rtp.Packet{Header: rtp.Header{PayloadType: tc.payloadType, Timestamp: 160}, Payload: tc.payload}
+			if !ConvertCodec(pkt, tc.codec) {
+				t.Fatal("not converted")
+			}
+			if !bytes.Equal(pkt.Payload, tc.want) {
+				t.Errorf("got payload %x, want %x", pkt.Payload, tc.want)
+			}
+			if pkt.PayloadType != tc.wantType {
+				t.Errorf("got payload type %d, want %d", pkt.PayloadType, tc.wantType)
+			}
+			if pkt.Timestamp != tc.wantTimestamp {
+				t.Errorf("got timestamp %d, want %d", pkt.Timestamp, tc.wantTimestamp)
+			}
+		})
+	}
+}
+
+func TestConvertCodecUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		payloadType uint8
+		codec       string
+	}{
+		{"unknown codec", 8, "opus"},
+		{"unknown payload type", 9, "l16"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := []byte{1, 2}
+			pkt := &rtp.Packet{Header: rtp.Header{PayloadType: tc.payloadType}, Payload: payload}
+			if ConvertCodec(pkt, tc.codec) {
+				t.Fatal("converted")
+			}
+			if pkt.PayloadType != tc.payloadType || !bytes.Equal(pkt.Payload, payload) {
+				t.Fatal("packet changed")
+			}
+		})
+	}
+}